@@ -3,6 +3,7 @@ package bitstream
 import (
 	"bufio"
 	"bytes"
+	"math/rand"
 	"testing"
 )
 
@@ -147,3 +148,68 @@ func TestBitStream(t *testing.T) {
 		}
 	}
 }
+
+func TestReader(t *testing.T) {
+	//compare against Bitstream, also for streams shorter than the container
+	for _, size := range []int{1, 5, 8, 9, 100, 1000} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		bs := NewBitstream(bufio.NewReader(bytes.NewReader(data)))
+		br := NewReader(data)
+		bitsRead := 0
+		for {
+			n := rand.Intn(58)
+			if bitsRead+n > size*8 {
+				break
+			}
+			should, err := bs.Read(n)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			bitsRead += n
+
+			if rand.Intn(2) == 0 {
+				br.Refill()
+				if x := br.Peek(uint(n)); x != should {
+					t.Fatalf("Size %d: Peeked %d, should be %d", size, x, should)
+				}
+				br.Consume(uint(n))
+			} else if x := br.Read(uint(n)); x != should {
+				t.Fatalf("Size %d: Read %d, should be %d", size, x, should)
+			}
+			if br.BitsRead() != bitsRead || br.Overread() {
+				t.Fatalf("Size %d: Read %d bits, should be %d", size, br.BitsRead(), bitsRead)
+			}
+		}
+
+		//after the end there are only zeros
+		rest := size*8 - br.BitsRead()
+		br.Read(uint(rest))
+		if br.Overread() || br.BytesRead() != size {
+			t.Fatalf("Size %d: Overread before the end", size)
+		}
+		if br.Read(9) != 0 || !br.Overread() {
+			t.Fatalf("Size %d: Reading after the end is wrong", size)
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+
+	br := Reader{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Init(data)
+		for br.BitsRead() < len(data)*8 {
+			br.Refill()
+			br.Read(9)
+			br.Read(16)
+			br.Read(11)
+		}
+	}
+}
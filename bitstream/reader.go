@@ -0,0 +1,87 @@
+package bitstream
+
+import (
+	"encoding/binary"
+)
+
+//Reader reads a stream forwards from the lowest bit of the first byte like Bitstream, but from a byte slice.
+//It keeps the next bits in a 64 bit container that is refilled with one unaligned load.
+//Instead of unwinding bits, callers peek as many bits as they might need and consume only what they used.
+//
+//Reading further than the stream goes is allowed and yields zeros. Overread tells if that happened
+type Reader struct {
+	data         []byte
+	ptr          int    //container was loaded from data[ptr:]
+	container    uint64 //the next bit to read is the lowest one that has not been consumed
+	bitsConsumed uint   //from the bottom of the container
+}
+
+//NewReader creates a new Reader that starts with the first bit of data
+func NewReader(data []byte) *Reader {
+	br := &Reader{}
+	br.Init(data)
+	return br
+}
+
+//Init resets the reader to the first bit of data
+func (br *Reader) Init(data []byte) {
+	br.data = data
+	br.ptr = 0
+	br.bitsConsumed = 0
+	br.load()
+}
+
+func (br *Reader) load() {
+	if br.ptr+8 <= len(br.data) {
+		br.container = binary.LittleEndian.Uint64(br.data[br.ptr:])
+		return
+	}
+	//near the end the missing bytes are zeros
+	br.container = 0
+	for i := br.ptr; i < len(br.data); i++ {
+		br.container |= uint64(br.data[i]) << uint(8*(i-br.ptr))
+	}
+}
+
+//Refill moves the container up as far as possible. Afterwards it has at least 57 unconsumed bits
+func (br *Reader) Refill() {
+	bytes := br.bitsConsumed >> 3
+	br.ptr += int(bytes)
+	br.bitsConsumed -= bytes * 8
+	br.load()
+}
+
+//Peek returns the next n bits without consuming them. The container has to hold enough bits, n has to be at most 57
+func (br *Reader) Peek(n uint) uint64 {
+	return (br.container >> br.bitsConsumed) & (1<<n - 1)
+}
+
+//Consume drops n bits that have been peeked before
+func (br *Reader) Consume(n uint) {
+	br.bitsConsumed += n
+}
+
+//Read returns the next n bits and refills the container if needed. n has to be at most 57
+func (br *Reader) Read(n uint) uint64 {
+	if br.bitsConsumed+n > 64 {
+		br.Refill()
+	}
+	value := br.Peek(n)
+	br.bitsConsumed += n
+	return value
+}
+
+//BitsRead returns the number of bits that have been consumed since Init
+func (br *Reader) BitsRead() int {
+	return br.ptr*8 + int(br.bitsConsumed)
+}
+
+//BytesRead returns the number of bytes that have been started
+func (br *Reader) BytesRead() int {
+	return (br.BitsRead() + 7) / 8
+}
+
+//Overread tells if more bits have been consumed than the stream has
+func (br *Reader) Overread() bool {
+	return br.BitsRead() > len(br.data)*8
+}
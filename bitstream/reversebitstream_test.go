@@ -1,6 +1,7 @@
 package bitstream
 
 import (
+	"math/rand"
 	"testing"
 )
 
@@ -227,3 +228,63 @@ func TestEdges(t *testing.T) {
 		panic("DD")
 	}
 }
+
+func TestReverseReader(t *testing.T) {
+	//compare against Reversebitstream, also for streams shorter than the container and reads after the end
+	for _, size := range []int{0, 1, 5, 8, 9, 100, 1000} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		rbs := NewReversebitstream(data)
+		br := NewReverseReader(data)
+		for rbs.BitsStillInStream() > -64 {
+			n := rand.Intn(58)
+			should, _ := rbs.Read(n)
+			if rand.Intn(2) == 0 {
+				br.Reload()
+				if x := br.Peek(uint(n)); x != should {
+					t.Fatalf("Size %d: Peeked %d, should be %d", size, x, should)
+				}
+				br.Consume(uint(n))
+			} else if x := br.Read(uint(n)); x != should {
+				t.Fatalf("Size %d: Read %d, should be %d", size, x, should)
+			}
+			if br.BitsStillInStream() != rbs.BitsStillInStream() {
+				t.Fatalf("Size %d: %d bits left, should be %d", size, br.BitsStillInStream(), rbs.BitsStillInStream())
+			}
+			if br.Finished() != (rbs.BitsStillInStream() == -1) {
+				t.Fatalf("Size %d: Finished is wrong with %d bits left", size, rbs.BitsStillInStream())
+			}
+		}
+	}
+
+	br := NewReverseReader([]byte{0xAB, 0x05})
+	n, err := br.SkipPadding()
+	if err != nil || n != 6 || br.BitsStillInStream() != 9 {
+		t.Errorf("Padding skipped wrong: %d bits, %d left", n, br.BitsStillInStream())
+	}
+	br.Init([]byte{0xAB, 0x00})
+	if _, err := br.SkipPadding(); err != ErrBadPadding {
+		t.Errorf("Padding of more than a byte was not detected")
+	}
+}
+
+func BenchmarkReverseReader(b *testing.B) {
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+
+	br := ReverseReader{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Init(data)
+		//the fields of a sequence: three reads of up to 16 bits after a reload
+		for !br.Finished() && br.BitsStillInStream() > 0 {
+			br.Reload()
+			br.Read(9)
+			br.Read(16)
+			br.Read(11)
+		}
+	}
+}
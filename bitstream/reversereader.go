@@ -0,0 +1,119 @@
+package bitstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+//ReverseReader reads a stream backwards like Reversebitstream. It keeps the next bits in a 64 bit container that is refilled
+//with one unaligned load, so fields can be read with a few shifts and without branching over partial bytes.
+//
+//Reading further than the stream goes is allowed and yields zeros, like with Reversebitstream.
+type ReverseReader struct {
+	data         []byte
+	ptr          int    //data[ptr:ptr+8] is loaded into container
+	container    uint64 //the next bit to read is the highest one that has not been consumed
+	bitsConsumed uint   //from the top of the container. More than 64 if bits after the end have been read
+}
+
+//NewReverseReader creates a new ReverseReader that starts with the last bit of data
+func NewReverseReader(data []byte) *ReverseReader {
+	br := &ReverseReader{}
+	br.Init(data)
+	return br
+}
+
+//Init resets the reader to the last bit of data
+func (br *ReverseReader) Init(data []byte) {
+	br.data = data
+	br.bitsConsumed = 0
+	if len(data) >= 8 {
+		br.ptr = len(data) - 8
+		br.container = binary.LittleEndian.Uint64(data[br.ptr:])
+		return
+	}
+	//act as if the missing bytes above the stream had already been consumed
+	br.ptr = 0
+	br.container = 0
+	for i, b := range data {
+		br.container |= uint64(b) << uint(8*i)
+	}
+	br.bitsConsumed = uint(8-len(data)) * 8
+}
+
+var ErrBadPadding = errors.New("The padding at the end of the stream was more than a byte. Data is likely corrupted")
+
+//SkipPadding consumes the zeros and the 1 that mark the start of the stream. They have to be in the last byte
+//returns the number of bits consumed
+func (br *ReverseReader) SkipPadding() (int, error) {
+	if len(br.data) == 0 || br.data[len(br.data)-1] == 0 {
+		return 0, ErrBadPadding
+	}
+	n := 9 - bits.Len8(br.data[len(br.data)-1])
+	br.bitsConsumed += uint(n)
+	return n, nil
+}
+
+//Reload moves the container down as far as possible. Afterwards it has at least 57 unconsumed bits or holds all the bits that are left
+func (br *ReverseReader) Reload() {
+	if br.bitsConsumed > 64 || br.ptr == 0 {
+		return
+	}
+	bytes := int(br.bitsConsumed >> 3)
+	if bytes > br.ptr {
+		bytes = br.ptr
+	}
+	br.ptr -= bytes
+	br.bitsConsumed -= uint(bytes) * 8
+	br.container = binary.LittleEndian.Uint64(br.data[br.ptr:])
+}
+
+//Peek returns the next n bits without consuming them. The container has to hold enough bits, n has to be at most 57
+func (br *ReverseReader) Peek(n uint) uint64 {
+	//shifts by 64 or more give 0 in go, so bits after the end of the stream are zeros and n == 0 gives 0
+	return (br.container << br.bitsConsumed) >> (64 - n)
+}
+
+//Consume drops n bits that have been peeked before
+func (br *ReverseReader) Consume(n uint) {
+	br.bitsConsumed += n
+}
+
+//Read returns the next n bits and reloads the container if needed. n has to be at most 57
+func (br *ReverseReader) Read(n uint) uint64 {
+	if br.bitsConsumed+n > 64 {
+		br.Reload()
+	}
+	value := br.Peek(n)
+	br.bitsConsumed += n
+	return value
+}
+
+//BitsStillInStream has the same meaning as for Reversebitstream: the number of bits left minus one.
+//-1 if the stream has been used up exactly, lower if more bits than available have been read
+func (br *ReverseReader) BitsStillInStream() int {
+	return br.ptr*8 + 64 - int(br.bitsConsumed) - 1
+}
+
+//Finished tells if all bits of the stream have been read but not more
+func (br *ReverseReader) Finished() bool {
+	return br.ptr == 0 && br.bitsConsumed == 64
+}
+
+//Position returns where the reader is, so assembly can continue reading the stream. ptr is the index of the 8 bytes in the container
+func (br *ReverseReader) Position() (int, uint) {
+	return br.ptr, br.bitsConsumed
+}
+
+//SetPosition continues reading at a position that was advanced from Position. The stream has to be at least 8 bytes long
+func (br *ReverseReader) SetPosition(ptr int, bitsConsumed uint) {
+	br.ptr = ptr
+	br.bitsConsumed = bitsConsumed
+	br.container = binary.LittleEndian.Uint64(br.data[ptr:])
+}
+
+//Data returns the stream the reader was initialized with
+func (br *ReverseReader) Data() []byte {
+	return br.data
+}
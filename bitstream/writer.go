@@ -0,0 +1,97 @@
+package bitstream
+
+import (
+	"encoding/binary"
+)
+
+//Writer writes a stream forwards, starting with the lowest bit of the first byte, so Bitstream and Reader read the values back
+//in the order they were written. The bits are collected in a 64 bit container that is appended to the output in whole bytes.
+//
+//FSE table descriptions and huffman headers are written this way
+type Writer struct {
+	data      []byte
+	start     int    //length of dst, the stream starts after it
+	container uint64 //the lowest bits bits have not been appended to data yet
+	bits      uint
+}
+
+//NewWriter creates a new Writer that appends to dst
+func NewWriter(dst []byte) *Writer {
+	bw := &Writer{}
+	bw.Reset(dst)
+	return bw
+}
+
+//Reset starts a new stream that is appended to dst
+func (bw *Writer) Reset(dst []byte) {
+	bw.data = dst
+	bw.start = len(dst)
+	bw.container = 0
+	bw.bits = 0
+}
+
+//Write adds the lowest n bits of value to the stream. n has to be at most 57
+func (bw *Writer) Write(value uint64, n uint) {
+	if bw.bits+n > 64 {
+		bw.flush()
+	}
+	bw.container |= (value & (1<<n - 1)) << bw.bits
+	bw.bits += n
+}
+
+//flush appends all full bytes of the container to data. Afterwards less than 8 bits are left in it
+func (bw *Writer) flush() {
+	bytes := bw.bits >> 3
+	bw.data = binary.LittleEndian.AppendUint64(bw.data, bw.container)
+	bw.data = bw.data[:len(bw.data)-8+int(bytes)]
+	bw.container >>= bytes * 8 //shifts by 64 give 0 in go
+	bw.bits -= bytes * 8
+}
+
+//BitsWritten returns the number of bits written to the stream so far
+func (bw *Writer) BitsWritten() int {
+	return (len(bw.data)-bw.start)*8 + int(bw.bits)
+}
+
+//Finish pads the last byte with zeros and returns dst with the stream appended
+func (bw *Writer) Finish() []byte {
+	bw.Write(0, (8-bw.bits%8)%8)
+	bw.flush()
+	return bw.data
+}
+
+//ReverseWriter writes a stream that is read backwards by Reversebitstream and ReverseReader, so the value that was written last
+//is read first. Finish closes the stream with the 1 bit that marks its start for the reader.
+//
+//Huffman streams and the sequences bitstream are written this way
+type ReverseWriter struct {
+	w Writer
+}
+
+//NewReverseWriter creates a new ReverseWriter that appends to dst
+func NewReverseWriter(dst []byte) *ReverseWriter {
+	bw := &ReverseWriter{}
+	bw.Reset(dst)
+	return bw
+}
+
+//Reset starts a new stream that is appended to dst
+func (bw *ReverseWriter) Reset(dst []byte) {
+	bw.w.Reset(dst)
+}
+
+//Write adds the lowest n bits of value to the stream. n has to be at most 57
+func (bw *ReverseWriter) Write(value uint64, n uint) {
+	bw.w.Write(value, n)
+}
+
+//BitsWritten returns the number of bits written to the stream so far, without the closing bit
+func (bw *ReverseWriter) BitsWritten() int {
+	return bw.w.BitsWritten()
+}
+
+//Finish writes the closing 1 bit, pads the last byte with zeros and returns dst with the stream appended
+func (bw *ReverseWriter) Finish() []byte {
+	bw.w.Write(1, 1)
+	return bw.w.Finish()
+}
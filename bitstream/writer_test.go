@@ -0,0 +1,120 @@
+package bitstream
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"testing/quick"
+)
+
+//bitFields turns the random input of quick.Check into values with 0 to 57 bits each
+func bitFields(values []uint64, lengths []uint8) ([]uint64, []uint) {
+	if len(lengths) < len(values) {
+		values = values[:len(lengths)]
+	}
+	ns := make([]uint, len(values))
+	for i := range values {
+		ns[i] = uint(lengths[i]) % 58
+		values[i] &= 1<<ns[i] - 1
+	}
+	return values, ns
+}
+
+func TestWriterProperties(t *testing.T) {
+	property := func(prefix []byte, values []uint64, lengths []uint8) bool {
+		values, ns := bitFields(values, lengths)
+		bw := NewWriter(append([]byte{}, prefix...))
+		bits := 0
+		for i, v := range values {
+			bw.Write(v, ns[i])
+			bits += int(ns[i])
+		}
+		if bw.BitsWritten() != bits {
+			return false
+		}
+		data := bw.Finish()
+		if !bytes.Equal(data[:len(prefix)], prefix) || len(data) != len(prefix)+(bits+7)/8 {
+			return false
+		}
+		data = data[len(prefix):]
+
+		bs := NewBitstream(bufio.NewReader(bytes.NewReader(data)))
+		br := NewReader(data)
+		for i, v := range values {
+			x, err := bs.Read(int(ns[i]))
+			if err != nil || x != v {
+				return false
+			}
+			if br.Read(ns[i]) != v {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestReverseWriterProperties(t *testing.T) {
+	property := func(values []uint64, lengths []uint8) bool {
+		values, ns := bitFields(values, lengths)
+		bw := NewReverseWriter(nil)
+		for i, v := range values {
+			bw.Write(v, ns[i])
+		}
+		data := bw.Finish()
+		if len(data) == 0 || data[len(data)-1] == 0 {
+			return false
+		}
+
+		//the padding is made of zeros and the closing 1
+		rbs := NewReversebitstream(data)
+		for {
+			bit, err := rbs.Read(1)
+			if err != nil || rbs.BitsStillInStream() < -1 {
+				return false
+			}
+			if bit == 1 {
+				break
+			}
+		}
+		br := NewReverseReader(data)
+		_, err := br.SkipPadding()
+		if err != nil {
+			return false
+		}
+
+		for i := len(values) - 1; i >= 0; i-- {
+			x, err := rbs.Read(int(ns[i]))
+			if err != nil || x != values[i] {
+				return false
+			}
+			if br.Read(ns[i]) != values[i] {
+				return false
+			}
+		}
+		return rbs.BitsStillInStream() == -1 && br.Finished()
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	const size = 64 * 1024
+	buf := make([]byte, 0, size+8)
+	bw := Writer{}
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bw.Reset(buf[:0])
+		for bw.BitsWritten() < size*8-36 {
+			bw.Write(0x1AB, 9)
+			bw.Write(0xBEEF, 16)
+			bw.Write(0x5A5, 11)
+		}
+		buf = bw.Finish()
+	}
+}
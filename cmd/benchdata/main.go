@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+//benchmarkFile is a synthetic input that stresses one feature of the decoder
+type benchmarkFile struct {
+	name     string
+	generate func(r *rand.Rand) []byte
+	zstdArgs []string //the options given to zstd besides the in- and output
+
+	//encode is used instead of zstd if it is set
+	encode func(data []byte) []byte
+}
+
+//fileSize is the size of the uncompressed files
+const fileSize = 256 * 1024
+
+var files = []benchmarkFile{
+	//random bytes can not be compressed, zstd stores them in raw blocks
+	{"raw", randomBytes, []string{"-1"}, nil},
+	//runs of one repeated byte. zstd would rather find matches between them, so the RLE blocks are written directly
+	{"rle", runs, nil, rleFrame},
+	//skewed symbols without long repetitions give big huffman compressed literal sections and few sequences
+	{"huffman", skewedSymbols, []string{"-1", "--zstd=mml=7"}, nil},
+	//one random chunk that is repeated with a few changes gives matches that are thousands of bytes long
+	{"longmatches", repeatedChunk, []string{"-3"}, nil},
+	//rows that differ from the row before in a few bytes give matches that use the repeat offsets most of the time
+	{"repeatoffsets", similarRows, []string{"-3"}, nil},
+	//text with a window of 1kb slides the window all the time
+	{"smallwindow", words, []string{"-3", "--zstd=wlog=10"}, nil},
+}
+
+func randomBytes(r *rand.Rand) []byte {
+	data := make([]byte, fileSize)
+	r.Read(data)
+	return data
+}
+
+//runLength is the length of the runs in the rle file and the size of its blocks
+const runLength = 4 * 1024
+
+func runs(r *rand.Rand) []byte {
+	data := make([]byte, fileSize)
+	for start := 0; start < len(data); start += runLength {
+		b := byte(r.Intn(256))
+		for i := start; i < start+runLength && i < len(data); i++ {
+			data[i] = b
+		}
+	}
+	return data
+}
+
+//rleFrame writes a frame with one RLE block for every run
+func rleFrame(data []byte) []byte {
+	frame := []byte{0x28, 0xB5, 0x2F, 0xFD}
+	//single segment with a 4 byte content size
+	frame = append(frame, 0xA0)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	frame = append(frame, size[:]...)
+
+	for start := 0; start < len(data); start += runLength {
+		end := start + runLength
+		if end > len(data) {
+			end = len(data)
+		}
+		header := uint32(end-start)<<3 | 1<<1
+		if end == len(data) {
+			header |= 1
+		}
+		frame = append(frame, byte(header), byte(header>>8), byte(header>>16), data[start])
+	}
+	return frame
+}
+
+func skewedSymbols(r *rand.Rand) []byte {
+	data := make([]byte, fileSize)
+	for i := range data {
+		//geometric distribution over 64 symbols
+		symbol := 0
+		for symbol < 63 && r.Intn(4) != 0 {
+			symbol++
+		}
+		data[i] = byte('0' + symbol)
+	}
+	return data
+}
+
+func repeatedChunk(r *rand.Rand) []byte {
+	chunk := make([]byte, 16*1024)
+	r.Read(chunk)
+	data := make([]byte, 0, fileSize)
+	for len(data) < fileSize {
+		data = append(data, chunk...)
+		chunk[r.Intn(len(chunk))] = byte(r.Intn(256))
+	}
+	return data[:fileSize]
+}
+
+func similarRows(r *rand.Rand) []byte {
+	row := make([]byte, 48)
+	r.Read(row)
+	data := make([]byte, 0, fileSize)
+	for len(data) < fileSize {
+		data = append(data, row...)
+		for i := 0; i < 2; i++ {
+			row[r.Intn(len(row))] = byte(r.Intn(256))
+		}
+	}
+	return data[:fileSize]
+}
+
+func words(r *rand.Rand) []byte {
+	vocabulary := make([][]byte, 2000)
+	for i := range vocabulary {
+		word := make([]byte, 2+r.Intn(8))
+		for j := range word {
+			word[j] = byte('a' + r.Intn(26))
+		}
+		vocabulary[i] = word
+	}
+	data := make([]byte, 0, fileSize)
+	for len(data) < fileSize {
+		data = append(data, vocabulary[r.Intn(len(vocabulary))]...)
+		data = append(data, ' ')
+	}
+	return data[:fileSize]
+}
+
+//benchdata writes the synthetic inputs for the benchmarks and compresses them with the zstd binary from the PATH.
+//The output directory is the first argument, by default benchmark_files
+func main() {
+	dir := "benchmark_files"
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	for idx, file := range files {
+		path := filepath.Join(dir, file.name)
+		//every file has its own seed so they do not change if one of them is changed
+		data := file.generate(rand.New(rand.NewSource(int64(idx))))
+		err := ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		if file.encode != nil {
+			err = ioutil.WriteFile(path+".zst", file.encode(data), 0644)
+			if err != nil {
+				panic(err.Error())
+			}
+			fmt.Printf("Wrote %s and %s.zst\n", path, path)
+			continue
+		}
+
+		args := append([]string{"-q", "-f", "--no-check"}, file.zstdArgs...)
+		args = append(args, path, "-o", path+".zst")
+		cmd := exec.Command("zstd", args...)
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("Wrote %s and %s.zst\n", path, path)
+	}
+}
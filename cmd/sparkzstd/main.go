@@ -30,7 +30,7 @@ func DoDecoding(r io.Reader) int64 {
 	}
 
 	dec := decompression.NewFrameDecompressor(r, outfile)
-	//dec.Verbose = true
+	//dec.Observer = &decompression.DebugObserver{W: os.Stderr}
 
 	err = dec.Decompress()
 	if err != nil {
@@ -55,7 +55,7 @@ func CompareWithFile(original string, compressed *os.File) int64 {
 		panic(err.Error())
 	}
 
-	//comp.PrintStatus = true
+	//comp.SetObserver(&decompression.DebugObserver{W: os.Stderr})
 	err = comp.Reset(compressed)
 	compReader := bufio.NewReader(comp)
 
@@ -0,0 +1,184 @@
+package compression
+
+import (
+	"github.com/killingspark/sparkzstd/fse"
+)
+
+//match is a match the binaryTree found
+type match struct {
+	length int
+	offset int
+}
+
+//binaryTree sorts the positions with the same hash of the next minMatch bytes by the bytes that follow them. Every position is
+//inserted at the root of its tree, on the way down the tree is split into the positions that are smaller and the ones that are
+//bigger. The way down passes the longest matches, so it finds them with far fewer attempts than a hash chain.
+//Ported from ZSTD_insertBt1 and ZSTD_insertBtAndFindBestMatch of https://github.com/facebook/zstd
+type binaryTree struct {
+	hashLog      uint
+	treeMask     int
+	minMatch     int
+	minLength    int //the shortest match that is reported
+	maxAttempts  int
+	base         int
+	nextToUpdate int     //the first position that is not in the tree yet. Positions in the middle of long repetitions are skipped
+	head         []int32 //the index of the root per hash
+	tree         []int32 //the smaller and the bigger child of an index at 2*(index&treeMask)
+
+	matches []match
+}
+
+func newBinaryTree(p parameters) *binaryTree {
+	minLength := 4
+	if p.minMatch < minLength {
+		minLength = p.minMatch
+	}
+	return &binaryTree{
+		hashLog:     uint(p.hashLog),
+		treeMask:    1<<uint(p.chainLog) - 1,
+		minMatch:    p.minMatch,
+		minLength:   minLength,
+		maxAttempts: 1 << uint(p.searchLog),
+		base:        1,
+		head:        make([]int32, 1<<uint(p.hashLog)),
+		tree:        make([]int32, 2<<uint(p.chainLog)),
+	}
+}
+
+func (bt *binaryTree) reset() {
+	for i := range bt.head {
+		bt.head[i] = 0
+	}
+	for i := range bt.tree {
+		bt.tree[i] = 0
+	}
+	bt.base = 1
+	bt.nextToUpdate = 0
+}
+
+func (bt *binaryTree) shift(n int) {
+	bt.base += n
+	bt.nextToUpdate -= n
+	if bt.nextToUpdate < 0 {
+		bt.nextToUpdate = 0
+	}
+	if bt.base > maxBase {
+		//a multiple of the tree size keeps the positions in the tree
+		n := (bt.base - 1) &^ bt.treeMask
+		correctIndexes(bt.head, n)
+		correctIndexes(bt.tree, n)
+		bt.base -= n
+	}
+}
+
+//insert puts ip into the tree. With collect it appends every match that is longer than the ones before to matches.
+//It returns where the earlier copy of the longest match ends. That is after ip only in repetitions
+func (bt *binaryTree) insert(src []byte, ip int, low int, matches []match, collect bool) ([]match, int) {
+	end := len(src)
+	h := hashBytes(load64(src, ip), bt.minMatch, bt.hashLog)
+	current := ip + bt.base
+	index := int(bt.head[h])
+	bt.head[h] = int32(current)
+
+	//the children of indexes below treeLow have been overwritten
+	treeLow := current - bt.treeMask
+	smaller := 2 * (current & bt.treeMask) //where the next smaller index is linked, -1 if nowhere
+	larger := smaller + 1
+	commonSmaller, commonLarger := 0, 0 //the candidates below smaller and larger start with this many bytes of ip
+	bestLength := bt.minLength - 1
+	matchEnd := ip + 8 + 1
+	for attempts := bt.maxAttempts; attempts > 0 && index > 0; attempts-- {
+		candidate := index - bt.base
+		if candidate < low {
+			break
+		}
+		node := 2 * (index & bt.treeMask)
+		length := commonSmaller
+		if commonLarger < length {
+			length = commonLarger
+		}
+		length += matchLength(src, ip+length, candidate+length, end)
+		if length > bestLength {
+			bestLength = length
+			if collect {
+				matches = append(matches, match{length: length, offset: ip - candidate})
+			}
+			if candidate+length > matchEnd {
+				matchEnd = candidate + length
+			}
+			//it is not known if the candidate is smaller or bigger, the rest of the tree is dropped
+			if ip+length == end {
+				break
+			}
+		}
+
+		if src[candidate+length] < src[ip+length] {
+			bt.tree[smaller] = int32(index)
+			commonSmaller = length
+			if index <= treeLow {
+				smaller = -1
+				break
+			}
+			smaller = node + 1
+			index = int(bt.tree[node+1])
+		} else {
+			bt.tree[larger] = int32(index)
+			commonLarger = length
+			if index <= treeLow {
+				larger = -1
+				break
+			}
+			larger = node
+			index = int(bt.tree[node])
+		}
+	}
+	if smaller >= 0 {
+		bt.tree[smaller] = 0
+	}
+	if larger >= 0 {
+		bt.tree[larger] = 0
+	}
+	if bestLength > 384 {
+		skip := bestLength - 384
+		if skip > 192 {
+			skip = 192
+		}
+		return matches, ip + 8 + skip
+	}
+	return matches, ip + 8 + 1
+}
+
+//update inserts the positions before ip. Positions in the middle of long matches are skipped, the tree gets very deep in repetitions
+func (bt *binaryTree) update(src []byte, ip int, low int) {
+	for pos := bt.nextToUpdate; pos < ip; {
+		_, matchEnd := bt.insert(src, pos, low, nil, false)
+		pos = matchEnd - 8
+		if pos > ip {
+			pos = ip
+		}
+		bt.nextToUpdate = pos
+	}
+}
+
+//allMatches appends the matches at ip to matches. Every match is longer than the one before
+func (bt *binaryTree) allMatches(src []byte, ip int, low int, matches []match) []match {
+	bt.update(src, ip, low)
+	if ip < bt.nextToUpdate {
+		return matches
+	}
+	matches, _ = bt.insert(src, ip, low, matches, true)
+	bt.nextToUpdate = ip + 1
+	return matches
+}
+
+//search returns the longest match at ip, unless a match that is a bit shorter has a much smaller offset
+func (bt *binaryTree) search(src []byte, ip int, low int) (int, int) {
+	bt.matches = bt.allMatches(src, ip, low, bt.matches[:0])
+	best := match{}
+	for _, m := range bt.matches {
+		if best.length == 0 || 4*(m.length-best.length) > int(fse.BIT_highbit32(uint32(m.offset+1)))-int(fse.BIT_highbit32(uint32(best.offset+1))) {
+			best = m
+		}
+	}
+	return best.length, best.offset
+}
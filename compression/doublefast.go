@@ -0,0 +1,126 @@
+package compression
+
+import (
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+//doubleFastFinder is a single pass match finder like the fastFinder, but it keeps two tables: one for the hash of the next 8 bytes
+//finds long matches, one for the hash of the next minMatch bytes finds the short ones. A short match is only taken if there is no
+//long match at this or the next position.
+//Ported from ZSTD_compressBlock_doubleFast of https://github.com/facebook/zstd
+type doubleFastFinder struct {
+	windowSize int
+	hashLog    uint //of the long table
+	shortLog   uint
+	minMatch   int
+	long       []int32 //positions in the buffer
+	short      []int32
+}
+
+func newDoubleFastFinder(p parameters) *doubleFastFinder {
+	return &doubleFastFinder{
+		windowSize: 1 << uint(p.windowLog),
+		hashLog:    uint(p.hashLog),
+		shortLog:   uint(p.chainLog),
+		minMatch:   p.minMatch,
+		long:       make([]int32, 1<<uint(p.hashLog)),
+		short:      make([]int32, 1<<uint(p.chainLog)),
+	}
+}
+
+func (f *doubleFastFinder) reset() {
+	for i := range f.long {
+		f.long[i] = 0
+	}
+	for i := range f.short {
+		f.short[i] = 0
+	}
+}
+
+func (f *doubleFastFinder) shift(n int) {
+	shiftTable(f.long, n)
+	shiftTable(f.short, n)
+}
+
+//insert puts pos into both tables
+func (f *doubleFastFinder) insert(src []byte, pos int, limit int) {
+	if pos < limit {
+		value := load64(src, pos)
+		f.long[hashBytes(value, 8, f.hashLog)] = int32(pos)
+		f.short[hashBytes(value, f.minMatch, f.shortLog)] = int32(pos)
+	}
+}
+
+func (f *doubleFastFinder) findSequences(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence {
+	end := len(src)
+	limit := end - 8 //the hashes read 8 bytes
+	anchor := start
+
+	for ip := start; ip < limit; {
+		low := ip - f.windowSize
+		if low < 0 {
+			low = 0
+		}
+
+		value := load64(src, ip)
+		hl := hashBytes(value, 8, f.hashLog)
+		hs := hashBytes(value, f.minMatch, f.shortLog)
+		candidateLong := int(f.long[hl])
+		candidateShort := int(f.short[hs])
+		f.long[hl] = int32(ip)
+		f.short[hs] = int32(ip)
+
+		length, offset := 0, 0
+		matchStart := ip
+		rep := offsets[0]
+		if repStart := ip + 1 - rep; rep <= f.windowSize && repStart >= 0 && load32(src, repStart) == load32(src, ip+1) {
+			length = 4 + matchLength(src, ip+5, repStart+4, end)
+			matchStart, offset = ip+1, rep
+		} else {
+			if candidateLong < ip && candidateLong >= low && load64(src, candidateLong) == value {
+				length = 8 + matchLength(src, ip+8, candidateLong+8, end)
+				offset = ip - candidateLong
+			} else if candidateShort < ip && candidateShort >= low && load32(src, candidateShort) == uint32(value) {
+				//a long match at the next position is better than the short one
+				next := load64(src, ip+1)
+				hl = hashBytes(next, 8, f.hashLog)
+				candidateNext := int(f.long[hl])
+				f.long[hl] = int32(ip + 1)
+				if candidateNext < ip+1 && ip+1-candidateNext <= f.windowSize && load64(src, candidateNext) == next {
+					length = 8 + matchLength(src, ip+9, candidateNext+8, end)
+					matchStart, offset = ip+1, ip+1-candidateNext
+				} else {
+					length = 4 + matchLength(src, ip+4, candidateShort+4, end)
+					offset = ip - candidateShort
+				}
+			} else {
+				ip += 1 + (ip-anchor)>>searchStrength
+				continue
+			}
+			//the match may start before the position that was hashed
+			for matchStart > anchor && matchStart-offset > 0 && src[matchStart-1] == src[matchStart-1-offset] {
+				matchStart--
+				length++
+			}
+		}
+
+		offsets.Encode(offset, matchStart-anchor)
+		sequences = append(sequences, structure.Sequence{LiteralLength: matchStart - anchor, MatchLength: length, Offset: offset})
+		ip = matchStart + length
+		anchor = ip
+		f.insert(src, matchStart+2, limit)
+		f.insert(src, ip-2, limit)
+
+		//the second last offset often matches right after a match, it costs almost nothing without literals
+		for ip < limit && offsets[1] <= ip && offsets[1] <= f.windowSize && load32(src, ip) == load32(src, ip-offsets[1]) {
+			offset = offsets[1]
+			length = 4 + matchLength(src, ip+4, ip-offset+4, end)
+			offsets.Encode(offset, 0)
+			sequences = append(sequences, structure.Sequence{LiteralLength: 0, MatchLength: length, Offset: offset})
+			f.insert(src, ip, limit)
+			ip += length
+			anchor = ip
+		}
+	}
+	return sequences
+}
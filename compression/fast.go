@@ -0,0 +1,139 @@
+package compression
+
+import (
+	"encoding/binary"
+	"github.com/killingspark/sparkzstd/structure"
+	"math/bits"
+)
+
+//fastFinder is a single pass match finder. It remembers one position per hash of the next minMatch bytes and takes the match
+//there if it has at least 4 bytes. It skips ahead faster the longer it finds no match, so data that does not compress is quick to get through.
+//Ported from ZSTD_compressBlock_fast of https://github.com/facebook/zstd
+type fastFinder struct {
+	windowSize int
+	hashLog    uint
+	minMatch   int
+	step       int     //the bytes that are skipped between the searched positions, if there was a match recently
+	table      []int32 //positions in the buffer
+}
+
+//searchStrength decides how fast the finder skips ahead. Every 1<<searchStrength bytes without match the step grows by one
+const searchStrength = 6
+
+//hashPrime spreads the bytes over the hash
+const hashPrime = 0xCF1BBCDCB7A56463
+
+func newFastFinder(p parameters) *fastFinder {
+	return &fastFinder{
+		windowSize: 1 << uint(p.windowLog),
+		hashLog:    uint(p.hashLog),
+		minMatch:   p.minMatch,
+		step:       1 + p.targetLength,
+		table:      make([]int32, 1<<uint(p.hashLog)),
+	}
+}
+
+//hashBytes hashes the lowest n bytes of value to hashLog bits
+func hashBytes(value uint64, n int, hashLog uint) uint32 {
+	return uint32(((value << (64 - 8*uint(n))) * hashPrime) >> (64 - hashLog))
+}
+
+func load32(src []byte, pos int) uint32 {
+	return binary.LittleEndian.Uint32(src[pos:])
+}
+
+func load64(src []byte, pos int) uint64 {
+	return binary.LittleEndian.Uint64(src[pos:])
+}
+
+//matchLength returns how many bytes at a and b are the same. b is before a, the match ends at end
+func matchLength(src []byte, a, b, end int) int {
+	n := 0
+	for a+n+8 <= end {
+		diff := load64(src, a+n) ^ load64(src, b+n)
+		if diff != 0 {
+			return n + bits.TrailingZeros64(diff)/8
+		}
+		n += 8
+	}
+	for a+n < end && src[a+n] == src[b+n] {
+		n++
+	}
+	return n
+}
+
+func (f *fastFinder) reset() {
+	for i := range f.table {
+		f.table[i] = 0
+	}
+}
+
+func (f *fastFinder) shift(n int) {
+	shiftTable(f.table, n)
+}
+
+//shiftTable moves the positions in table n bytes to the front. Positions that are not in the buffer anymore point to its start,
+//the match finders check the bytes anyways
+func shiftTable(table []int32, n int) {
+	for i, pos := range table {
+		pos -= int32(n)
+		if pos < 0 {
+			pos = 0
+		}
+		table[i] = pos
+	}
+}
+
+func (f *fastFinder) findSequences(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence {
+	end := len(src)
+	limit := end - 8 //the hash reads 8 bytes
+	anchor := start
+	rep := offsets[0]
+
+	for ip := start; ip < limit; {
+		low := ip - f.windowSize
+		if low < 0 {
+			low = 0
+		}
+
+		h := hashBytes(load64(src, ip), f.minMatch, f.hashLog)
+		candidate := int(f.table[h])
+		f.table[h] = int32(ip)
+
+		//the last offset is checked one byte ahead, so the match has literals and the offset is written as repeat offset
+		if repStart := ip + 1 - rep; rep <= f.windowSize && repStart >= 0 && load32(src, repStart) == load32(src, ip+1) {
+			length := 4 + matchLength(src, ip+5, repStart+4, end)
+			sequences = append(sequences, structure.Sequence{LiteralLength: ip + 1 - anchor, MatchLength: length, Offset: rep})
+			ip += 1 + length
+			anchor = ip
+			f.insert(src, ip-2, limit)
+			continue
+		}
+
+		if candidate < ip && candidate >= low && load32(src, candidate) == load32(src, ip) {
+			length := 4 + matchLength(src, ip+4, candidate+4, end)
+			//the match may start before the position that was hashed
+			for ip > anchor && candidate > 0 && src[ip-1] == src[candidate-1] {
+				ip--
+				candidate--
+				length++
+			}
+			rep = ip - candidate
+			sequences = append(sequences, structure.Sequence{LiteralLength: ip - anchor, MatchLength: length, Offset: rep})
+			ip += length
+			anchor = ip
+			f.insert(src, ip-2, limit)
+			continue
+		}
+
+		ip += f.step + (ip-anchor)>>searchStrength
+	}
+	return sequences
+}
+
+//insert puts pos into the table, so the data at the end of a match can be found again
+func (f *fastFinder) insert(src []byte, pos int, limit int) {
+	if pos < limit {
+		f.table[hashBytes(load64(src, pos), f.minMatch, f.hashLog)] = int32(pos)
+	}
+}
@@ -0,0 +1,270 @@
+package compression
+
+import (
+	"github.com/killingspark/sparkzstd/fse"
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+//matchSearcher finds the longest match at a position. It has to see every position of the data in order, positions it did not
+//search are added by the next search.
+//
+//The tables of the searchers are indexed by the positions, so they can not be moved with the buffer. They keep indexes instead:
+//the index of a position is pos+base and the base grows when the buffer slides. Index 0 means empty
+type matchSearcher interface {
+	//search returns the longest match at ip that starts at low or later, offset is its distance to ip. length is 0 if there is none
+	search(src []byte, ip int, low int) (length int, offset int)
+	shift(n int)
+	reset()
+}
+
+//maxBase is the base at which the indexes are corrected before they overflow
+const maxBase = 1 << 30
+
+//correctIndexes subtracts n from the indexes in table. Indexes that are lower than n become empty
+func correctIndexes(table []int32, n int) {
+	for i, index := range table {
+		index -= int32(n)
+		if index < 0 {
+			index = 0
+		}
+		table[i] = index
+	}
+}
+
+//hashChain links every position to the last position before it with the same hash of the next minMatch bytes
+//Ported from ZSTD_HcFindBestMatch of https://github.com/facebook/zstd
+type hashChain struct {
+	hashLog      uint
+	chainMask    int
+	minMatch     int
+	maxAttempts  int
+	base         int
+	nextToUpdate int     //the first position that is not in the tables yet
+	head         []int32 //the last index per hash
+	chain        []int32 //the index before an index with the same hash, at index&chainMask
+}
+
+func newHashChain(p parameters) *hashChain {
+	return &hashChain{
+		hashLog:     uint(p.hashLog),
+		chainMask:   1<<uint(p.chainLog) - 1,
+		minMatch:    p.minMatch,
+		maxAttempts: 1 << uint(p.searchLog),
+		base:        1,
+		head:        make([]int32, 1<<uint(p.hashLog)),
+		chain:       make([]int32, 1<<uint(p.chainLog)),
+	}
+}
+
+func (hc *hashChain) reset() {
+	for i := range hc.head {
+		hc.head[i] = 0
+	}
+	for i := range hc.chain {
+		hc.chain[i] = 0
+	}
+	hc.base = 1
+	hc.nextToUpdate = 0
+}
+
+func (hc *hashChain) shift(n int) {
+	hc.base += n
+	hc.nextToUpdate -= n
+	if hc.nextToUpdate < 0 {
+		hc.nextToUpdate = 0
+	}
+	if hc.base > maxBase {
+		//a multiple of the chain size keeps the positions in the chain
+		n := (hc.base - 1) &^ hc.chainMask
+		correctIndexes(hc.head, n)
+		correctIndexes(hc.chain, n)
+		hc.base -= n
+	}
+}
+
+func (hc *hashChain) search(src []byte, ip int, low int) (int, int) {
+	for pos := hc.nextToUpdate; pos <= ip; pos++ {
+		h := hashBytes(load64(src, pos), hc.minMatch, hc.hashLog)
+		index := pos + hc.base
+		hc.chain[index&hc.chainMask] = hc.head[h]
+		hc.head[h] = int32(index)
+	}
+	if ip >= hc.nextToUpdate {
+		hc.nextToUpdate = ip + 1
+	}
+
+	//older entries of the chain have been overwritten
+	if chainLow := ip - hc.chainMask; chainLow > low {
+		low = chainLow
+	}
+	end := len(src)
+	current := ip + hc.base
+	bestLength, bestOffset := 3, 0
+	index := int(hc.chain[current&hc.chainMask])
+	for attempts := hc.maxAttempts; attempts > 0; attempts-- {
+		candidate := index - hc.base
+		if index == 0 || candidate < low {
+			break
+		}
+		//the byte after the best match has to match too, or the candidate can not be longer
+		if src[candidate+bestLength] == src[ip+bestLength] && load32(src, candidate) == load32(src, ip) {
+			length := 4 + matchLength(src, ip+4, candidate+4, end)
+			if length > bestLength {
+				bestLength, bestOffset = length, ip-candidate
+				if ip+length == end {
+					break
+				}
+			}
+		}
+		next := int(hc.chain[index&hc.chainMask])
+		if next >= index {
+			break
+		}
+		index = next
+	}
+	if bestOffset == 0 {
+		return 0, 0
+	}
+	return bestLength, bestOffset
+}
+
+//lazyFinder takes the longest match the searcher finds. With depth 1 or 2 it looks that many positions ahead and starts the
+//match later if the match there is estimated to be worth more.
+//Ported from ZSTD_compressBlock_lazy_generic of https://github.com/facebook/zstd
+type lazyFinder struct {
+	windowSize int
+	depth      int
+	searcher   matchSearcher
+}
+
+func newLazyFinder(p parameters, searcher matchSearcher) *lazyFinder {
+	depth := 0
+	switch p.strategy {
+	case strategyLazy:
+		depth = 1
+	case strategyLazy2, strategyBtLazy2:
+		depth = 2
+	}
+	return &lazyFinder{
+		windowSize: 1 << uint(p.windowLog),
+		depth:      depth,
+		searcher:   searcher,
+	}
+}
+
+func (f *lazyFinder) reset() {
+	f.searcher.reset()
+}
+
+func (f *lazyFinder) shift(n int) {
+	f.searcher.shift(n)
+}
+
+//offsetBits estimates the bits of an offset. Repeat offsets cost almost nothing
+func offsetBits(offset int, offsets *structure.OffsetHistory) int {
+	if offset == offsets[0] {
+		return 0
+	}
+	return int(fse.BIT_highbit32(uint32(offset + 3)))
+}
+
+//repeatMatch returns the length of the match at ip with the last offset, 0 if there is none
+func (f *lazyFinder) repeatMatch(src []byte, ip int, offsets *structure.OffsetHistory) int {
+	rep := offsets[0]
+	if rep > ip || rep > f.windowSize || load32(src, ip) != load32(src, ip-rep) {
+		return 0
+	}
+	return 4 + matchLength(src, ip+4, ip-rep+4, len(src))
+}
+
+//better searches at pos and replaces the match if one there is estimated to be worth more. The lengths of repeat matches are
+//weighted with repWeight, the lengths of the other matches with 4. margin is the price of the literals the match gets later
+func (f *lazyFinder) better(src []byte, pos int, length *int, offset *int, offsets *structure.OffsetHistory, repWeight int, margin int) bool {
+	found := false
+	if *offset != offsets[0] {
+		if repLength := f.repeatMatch(src, pos, offsets); repLength > 0 && repLength*repWeight > *length*repWeight-offsetBits(*offset, offsets)+1 {
+			*length, *offset, found = repLength, offsets[0], true
+		}
+	}
+	low := pos - f.windowSize
+	if low < 0 {
+		low = 0
+	}
+	searched, searchedOffset := f.searcher.search(src, pos, low)
+	if searched > 0 && searched*4-offsetBits(searchedOffset, offsets) > *length*4-offsetBits(*offset, offsets)+margin {
+		*length, *offset, found = searched, searchedOffset, true
+	}
+	return found
+}
+
+func (f *lazyFinder) findSequences(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence {
+	end := len(src)
+	limit := end - 8 //the hashes read 8 bytes
+	anchor := start
+
+	for ip := start; ip < limit; {
+		low := ip - f.windowSize
+		if low < 0 {
+			low = 0
+		}
+
+		length, offset := 0, 0
+		matchStart := ip
+		if ip+1 < limit {
+			if repLength := f.repeatMatch(src, ip+1, &offsets); repLength > 0 {
+				length, offset, matchStart = repLength, offsets[0], ip+1
+			}
+		}
+		if length == 0 || f.depth > 0 {
+			found, foundOffset := f.searcher.search(src, ip, low)
+			if found > length {
+				length, offset, matchStart = found, foundOffset, ip
+			}
+		}
+		if length == 0 {
+			ip += 1 + (ip-anchor)>>searchStrength
+			continue
+		}
+
+		//a match at the next positions is taken instead if its length pays for the literal and the bigger offset
+		for f.depth > 0 && ip+1 < limit {
+			ip++
+			if f.better(src, ip, &length, &offset, &offsets, 3, 4) {
+				matchStart = ip
+				continue
+			}
+			if f.depth == 2 && ip+1 < limit {
+				ip++
+				if f.better(src, ip, &length, &offset, &offsets, 4, 7) {
+					matchStart = ip
+					continue
+				}
+			}
+			break
+		}
+
+		//the match may start before the position that was searched
+		if offset != offsets[0] {
+			for matchStart > anchor && matchStart-offset > 0 && src[matchStart-1] == src[matchStart-1-offset] {
+				matchStart--
+				length++
+			}
+		}
+
+		offsets.Encode(offset, matchStart-anchor)
+		sequences = append(sequences, structure.Sequence{LiteralLength: matchStart - anchor, MatchLength: length, Offset: offset})
+		ip = matchStart + length
+		anchor = ip
+
+		//the second last offset often matches right after a match, it costs almost nothing without literals
+		for ip < limit && offsets[1] <= ip && offsets[1] <= f.windowSize && load32(src, ip) == load32(src, ip-offsets[1]) {
+			offset = offsets[1]
+			length = 4 + matchLength(src, ip+4, ip-offset+4, end)
+			offsets.Encode(offset, 0)
+			sequences = append(sequences, structure.Sequence{LiteralLength: 0, MatchLength: length, Offset: offset})
+			ip += length
+			anchor = ip
+		}
+	}
+	return sequences
+}
@@ -0,0 +1,96 @@
+package compression
+
+import (
+	"errors"
+)
+
+//strategy is the way the matches are searched and chosen. The later strategies find better matches but take longer
+type strategy int
+
+const (
+	strategyFast       strategy = iota //one hash table, the first match that is found is taken
+	strategyDoubleFast                 //one hash table for long and one for short matches
+	strategyGreedy                     //hash chain, the longest match at the current position is taken
+	strategyLazy                       //hash chain, the match is dropped if the next position has a better one
+	strategyLazy2                      //like strategyLazy, but looks two positions ahead
+	strategyBtLazy2                    //like strategyLazy2, but with a binary tree instead of the hash chain
+	strategyBtOpt                      //binary tree, the cheapest path through all matches is searched
+	strategyBtUltra                    //like strategyBtOpt, but with more accurate prices
+	strategyBtUltra2                   //like strategyBtUltra, but the first block is parsed twice to learn its prices
+)
+
+//parameters tune a strategy. They are named like the parameters of the original zstd
+type parameters struct {
+	strategy     strategy
+	windowLog    int //the matches are searched in the last 1<<windowLog bytes
+	hashLog      int //size of the table that finds the first candidates
+	chainLog     int //size of the hash chain or the binary tree. For strategyDoubleFast the size of the table for short matches
+	searchLog    int //the hash chain and the binary tree look at up to 1<<searchLog candidates
+	minMatch     int //number of bytes that are hashed
+	targetLength int //for strategyFast the acceleration: this many more bytes are skipped between the positions that are searched.
+	//For the optimal strategies matches that are longer are taken without searching further
+}
+
+const (
+	MinLevel     = -5
+	MaxLevel     = 19
+	DefaultLevel = 3
+)
+
+var ErrInvalidLevel = errors.New("The compression level is out of range")
+
+//levels are the parameters of the levels 1 to MaxLevel. They are the ones the original zstd uses for big inputs, only the
+//binary tree of the highest level is half as big
+var levels = [MaxLevel + 1]parameters{
+	{strategyFast, 19, 13, 12, 1, 6, 1}, //the base of the negative levels
+	{strategyFast, 19, 14, 13, 1, 6, 0},
+	{strategyFast, 20, 16, 15, 1, 6, 0},
+	{strategyDoubleFast, 21, 17, 16, 1, 5, 0},
+	{strategyDoubleFast, 21, 18, 18, 1, 5, 0},
+	{strategyGreedy, 21, 19, 18, 3, 5, 0},
+	{strategyLazy, 21, 19, 18, 3, 5, 0},
+	{strategyLazy, 21, 20, 19, 4, 5, 0},
+	{strategyLazy2, 21, 20, 19, 4, 5, 0},
+	{strategyLazy2, 22, 21, 20, 4, 5, 0},
+	{strategyLazy2, 22, 22, 21, 5, 5, 0},
+	{strategyLazy2, 22, 22, 21, 6, 5, 0},
+	{strategyLazy2, 22, 23, 22, 7, 5, 0},
+	{strategyBtLazy2, 22, 22, 22, 4, 5, 0},
+	{strategyBtLazy2, 22, 23, 22, 5, 5, 0},
+	{strategyBtLazy2, 22, 23, 23, 6, 5, 0},
+	{strategyBtOpt, 22, 22, 22, 5, 5, 48},
+	{strategyBtOpt, 23, 22, 23, 5, 4, 64},
+	{strategyBtUltra, 23, 22, 23, 6, 3, 64},
+	{strategyBtUltra2, 23, 22, 23, 7, 3, 256},
+}
+
+//levelParameters returns the parameters of a level. 0 is the DefaultLevel, the negative levels are strategyFast with more
+//acceleration the lower they are
+func levelParameters(level int) (parameters, error) {
+	switch {
+	case level < MinLevel || level > MaxLevel:
+		return parameters{}, ErrInvalidLevel
+	case level == 0:
+		return levels[DefaultLevel], nil
+	case level < 0:
+		p := levels[0]
+		p.targetLength = -2*level - 1
+		return p, nil
+	}
+	return levels[level], nil
+}
+
+//newMatchFinder creates the match finder of the strategy
+func newMatchFinder(p parameters) matchFinder {
+	switch p.strategy {
+	case strategyFast:
+		return newFastFinder(p)
+	case strategyDoubleFast:
+		return newDoubleFastFinder(p)
+	case strategyBtLazy2:
+		return newLazyFinder(p, newBinaryTree(p))
+	case strategyBtOpt, strategyBtUltra, strategyBtUltra2:
+		return newOptimalFinder(p)
+	}
+	return newLazyFinder(p, newHashChain(p))
+}
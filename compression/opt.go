@@ -0,0 +1,347 @@
+package compression
+
+import (
+	"github.com/killingspark/sparkzstd/fse"
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+//bitCost is one bit in the prices, they have 8 bits of fraction
+const bitCost = 256
+
+//maxPrice is the price of positions that have not been reached
+const maxPrice = 1 << 30
+
+//optNum is the most positions that are parsed at once
+const optNum = 1 << 12
+
+//minTwoPasses is the smallest first block that is parsed twice by strategyBtUltra2
+const minTwoPasses = 1024
+
+//the frequencies the codes start with in the first block. Short literal lengths and small offsets are common
+var (
+	baseLiteralLengthFreqs = [36]int{4, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	baseOffsetCodeFreqs    = [32]int{6, 2, 1, 1, 2, 3, 4, 4, 4, 3, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+)
+
+//optStats counts the literals and the codes of the sequences that were chosen. They approximate what the huffman and FSE
+//coders will see, a symbol costs about log2(sum/freq) bits.
+//Ported from the ZSTD_optimal_t and the price functions of zstd_opt.c of https://github.com/facebook/zstd
+type optStats struct {
+	accurate bool //prices with fractional bits instead of whole bits
+
+	literals          [256]int
+	literalsSum       int
+	literalLengths    [36]int
+	literalLengthsSum int
+	matchLengths      [53]int
+	matchLengthsSum   int
+	offsetCodes       [32]int
+	offsetCodesSum    int
+}
+
+//weight is about log2(freq+1) in bitCost. Without accurate it is rounded down to whole bits
+func (s *optStats) weight(freq int) int {
+	bits := int(fse.BIT_highbit32(uint32(freq + 1)))
+	if !s.accurate {
+		return bits * bitCost
+	}
+	return bits*bitCost + (freq+1)*bitCost>>uint(bits)
+}
+
+//rescale prepares the stats for the next block. In the first block of a frame the literals are counted in the block,
+//the codes start with the base frequencies. Later blocks keep a part of what was learned before
+func (s *optStats) rescale(block []byte) {
+	if s.literalsSum == 0 {
+		s.literals = [256]int{}
+		for _, b := range block {
+			s.literals[b]++
+		}
+		s.literalsSum = downscale(s.literals[:], 8)
+		s.literalLengths = baseLiteralLengthFreqs
+		s.literalLengthsSum = sum(s.literalLengths[:])
+		for i := range s.matchLengths {
+			s.matchLengths[i] = 1
+		}
+		s.matchLengthsSum = len(s.matchLengths)
+		s.offsetCodes = baseOffsetCodeFreqs
+		s.offsetCodesSum = sum(s.offsetCodes[:])
+		return
+	}
+	s.literalsSum = downscale(s.literals[:], 5)
+	s.literalLengthsSum = downscale(s.literalLengths[:], 4)
+	s.matchLengthsSum = downscale(s.matchLengths[:], 4)
+	s.offsetCodesSum = downscale(s.offsetCodes[:], 4)
+}
+
+//downscale divides the frequencies by 1<<shift and returns their new sum. Every symbol keeps a frequency of at least 1
+func downscale(freqs []int, shift uint) int {
+	for i, freq := range freqs {
+		freqs[i] = 1 + freq>>shift
+	}
+	return sum(freqs)
+}
+
+func sum(freqs []int) int {
+	total := 0
+	for _, freq := range freqs {
+		total += freq
+	}
+	return total
+}
+
+//update counts the literals and the codes of a sequence
+func (s *optStats) update(literals []byte, literalLength int, offsetValue int, matchLength int) {
+	for _, b := range literals {
+		s.literals[b] += 2
+	}
+	s.literalsSum += 2 * len(literals)
+	s.literalLengths[structure.LiteralLengthCode(literalLength)]++
+	s.literalLengthsSum++
+	s.offsetCodes[structure.OffsetCode(offsetValue)]++
+	s.offsetCodesSum++
+	s.matchLengths[structure.MatchLengthCode(matchLength)]++
+	s.matchLengthsSum++
+}
+
+func (s *optStats) literalPrice(b byte) int {
+	return s.weight(s.literalsSum) - s.weight(s.literals[b])
+}
+
+func (s *optStats) literalLengthPrice(literalLength int) int {
+	code := structure.LiteralLengthCode(literalLength)
+	return int(fse.LiteralLengthExtraBits[code])*bitCost + s.weight(s.literalLengthsSum) - s.weight(s.literalLengths[code])
+}
+
+//matchPrice is the price of the offset value and the match length. A fifth bit is added, so fewer sequences are preferred
+func (s *optStats) matchPrice(offsetValue int, matchLength int) int {
+	offsetCode := int(structure.OffsetCode(offsetValue))
+	price := offsetCode*bitCost + s.weight(s.offsetCodesSum) - s.weight(s.offsetCodes[offsetCode])
+	if !s.accurate && offsetCode >= 20 {
+		//long distances are slow to decode
+		price += (offsetCode - 19) * 2 * bitCost
+	}
+	code := structure.MatchLengthCode(matchLength)
+	price += int(fse.MatchLengthsExtraBits[code])*bitCost + s.weight(s.matchLengthsSum) - s.weight(s.matchLengths[code])
+	return price + bitCost/5
+}
+
+//optNode is the cheapest way found to parse the data up to a position
+type optNode struct {
+	price         int
+	matchLength   int //0 if the position is reached by a literal
+	offset        int
+	literalLength int //the literals before the position, if it is reached by a literal
+	offsets       structure.OffsetHistory
+}
+
+//optStep is a match on the cheapest path
+type optStep struct {
+	start int
+	match match
+}
+
+//optimalFinder prices all matches the binary tree finds at every position and searches the cheapest way through them, like
+//the shortest path in a graph. The prices come from the stats of the sequences that were chosen before.
+//With strategyBtOpt the prices are whole bits and positions that do not look promising are not searched.
+//strategyBtUltra prices with fractional bits, strategyBtUltra2 parses the first block twice so it starts with good stats.
+//Ported from ZSTD_compressBlock_opt_generic of https://github.com/facebook/zstd
+type optimalFinder struct {
+	windowSize       int
+	minLength        int
+	sufficientLength int //matches that are longer are taken right away
+	ultra            bool
+	twoPasses        bool
+	tree             *binaryTree
+	stats            optStats
+
+	nodes      []optNode
+	matches    []match
+	candidates []match
+	path       []optStep
+}
+
+func newOptimalFinder(p parameters) *optimalFinder {
+	tree := newBinaryTree(p)
+	sufficientLength := p.targetLength
+	if sufficientLength > optNum-1 {
+		sufficientLength = optNum - 1
+	}
+	ultra := p.strategy == strategyBtUltra || p.strategy == strategyBtUltra2
+	return &optimalFinder{
+		windowSize:       1 << uint(p.windowLog),
+		minLength:        tree.minLength,
+		sufficientLength: sufficientLength,
+		ultra:            ultra,
+		twoPasses:        p.strategy == strategyBtUltra2,
+		tree:             tree,
+		stats:            optStats{accurate: ultra},
+		nodes:            make([]optNode, 0, optNum+1),
+	}
+}
+
+func (f *optimalFinder) reset() {
+	f.tree.reset()
+	f.stats.literalsSum = 0
+}
+
+func (f *optimalFinder) shift(n int) {
+	f.tree.shift(n)
+}
+
+func (f *optimalFinder) findSequences(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence {
+	if f.twoPasses && f.stats.literalsSum == 0 && start == 0 && len(src) >= minTwoPasses {
+		//the first pass only collects the stats
+		f.parse(sequences, src, start, offsets)
+		f.tree.reset()
+	}
+	return f.parse(sequences, src, start, offsets)
+}
+
+//findMatches returns the matches at ip for the given offsets and literals before ip. The repeat offsets are checked first, they
+//are the cheapest. Every match is longer than the one before
+func (f *optimalFinder) findMatches(src []byte, ip int, low int, offsets *structure.OffsetHistory) []match {
+	f.matches = f.matches[:0]
+	best := f.minLength - 1
+	for _, offset := range [4]int{offsets[0], offsets[1], offsets[2], offsets[0] - 1} {
+		if offset <= 0 || ip-offset < low {
+			continue
+		}
+		if length := matchLength(src, ip, ip-offset, len(src)); length > best {
+			f.matches = append(f.matches, match{length: length, offset: offset})
+			best = length
+		}
+	}
+	f.candidates = f.tree.allMatches(src, ip, low, f.candidates[:0])
+	for _, m := range f.candidates {
+		if m.length > best {
+			f.matches = append(f.matches, m)
+			best = m.length
+		}
+	}
+	return f.matches
+}
+
+//emit appends the match at start as sequence and updates the stats
+func (f *optimalFinder) emit(sequences []structure.Sequence, src []byte, anchor *int, start int, m match, offsets *structure.OffsetHistory) []structure.Sequence {
+	literalLength := start - *anchor
+	offsetValue := offsets.Encode(m.offset, literalLength)
+	f.stats.update(src[*anchor:start], literalLength, offsetValue, m.length)
+	*anchor = start + m.length
+	return append(sequences, structure.Sequence{LiteralLength: literalLength, MatchLength: m.length, Offset: m.offset})
+}
+
+//addMatches prices the matches from the node cur and keeps them at the positions they reach, if they are cheaper. It returns
+//the last position that is reached
+func (f *optimalFinder) addMatches(cur int, lastPos int) int {
+	node := f.nodes[cur]
+	literalLength := 0
+	if node.matchLength == 0 {
+		literalLength = node.literalLength
+	}
+	base := node.price + f.stats.literalLengthPrice(0)
+	length := f.minLength
+	for _, m := range f.matches {
+		offsets := node.offsets
+		offsetValue := offsets.Encode(m.offset, literalLength)
+		for ; length <= m.length; length++ {
+			pos := cur + length
+			for lastPos < pos {
+				lastPos++
+				f.nodes = append(f.nodes, optNode{price: maxPrice})
+			}
+			if price := base + f.stats.matchPrice(offsetValue, length); price < f.nodes[pos].price {
+				f.nodes[pos] = optNode{price: price, matchLength: length, offset: m.offset, offsets: offsets}
+			}
+		}
+	}
+	return lastPos
+}
+
+//parse finds the sequences of the block. The cheapest path is searched over up to optNum positions at a time
+func (f *optimalFinder) parse(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence {
+	f.stats.rescale(src[start:])
+	end := len(src)
+	limit := end - 8 //the hashes read 8 bytes
+	anchor := start
+
+	for ip := start; ip < limit; {
+		low := ip - f.windowSize
+		if low < 0 {
+			low = 0
+		}
+		matches := f.findMatches(src, ip, low, &offsets)
+		if len(matches) == 0 {
+			ip++
+			continue
+		}
+		if longest := matches[len(matches)-1]; longest.length > f.sufficientLength {
+			sequences = f.emit(sequences, src, &anchor, ip, longest, &offsets)
+			ip = anchor
+			continue
+		}
+
+		literalLength := ip - anchor
+		f.nodes = append(f.nodes[:0], optNode{price: f.stats.literalLengthPrice(literalLength), literalLength: literalLength, offsets: offsets})
+		lastPos := f.addMatches(0, 0)
+		tail := optStep{start: -1}
+		for cur := 1; cur <= lastPos; cur++ {
+			//reaching the position with a literal
+			prev := f.nodes[cur-1]
+			literalLength := 1
+			if prev.matchLength == 0 {
+				literalLength = prev.literalLength + 1
+			}
+			price := prev.price + f.stats.literalPrice(src[ip+cur-1]) + f.stats.literalLengthPrice(literalLength) - f.stats.literalLengthPrice(literalLength-1)
+			if price <= f.nodes[cur].price {
+				f.nodes[cur] = optNode{price: price, literalLength: literalLength, offsets: prev.offsets}
+			}
+
+			pos := ip + cur
+			if cur == lastPos || pos >= limit {
+				continue
+			}
+			if !f.ultra && f.nodes[cur+1].price <= f.nodes[cur].price+bitCost/2 {
+				continue
+			}
+			low := pos - f.windowSize
+			if low < 0 {
+				low = 0
+			}
+			node := f.nodes[cur]
+			matches := f.findMatches(src, pos, low, &node.offsets)
+			if len(matches) == 0 {
+				continue
+			}
+			if longest := matches[len(matches)-1]; longest.length > f.sufficientLength || cur+longest.length >= optNum {
+				//the path has to end here and take the match
+				tail = optStep{start: pos, match: longest}
+				lastPos = cur
+				break
+			}
+			lastPos = f.addMatches(cur, lastPos)
+		}
+
+		//the cheapest path is followed back from the last position
+		f.path = f.path[:0]
+		if tail.start >= 0 {
+			f.path = append(f.path, tail)
+		}
+		for pos := lastPos; pos > 0; {
+			node := f.nodes[pos]
+			if node.matchLength == 0 {
+				pos--
+				continue
+			}
+			pos -= node.matchLength
+			f.path = append(f.path, optStep{start: ip + pos, match: match{length: node.matchLength, offset: node.offset}})
+		}
+		for i := len(f.path) - 1; i >= 0; i-- {
+			sequences = f.emit(sequences, src, &anchor, f.path[i].start, f.path[i].match, &offsets)
+		}
+		ip += lastPos
+		if anchor > ip {
+			ip = anchor
+		}
+	}
+	return sequences
+}
@@ -0,0 +1,217 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/structure"
+	"github.com/killingspark/sparkzstd/xxhash"
+	"io"
+)
+
+//StoreWriter writes one zstd frame without compressing the data. Every block is stored as raw block, runs of one repeated byte
+//become RLE blocks. It costs next to no CPU and the output can be read by every zstd decoder.
+//
+//The data is split into blocks of 128kb. The frame header is written together with the first block, so content that fits into
+//one block is always written as a single segment frame with its content size.
+type StoreWriter struct {
+	target      io.Writer
+	checksum    bool
+	contentSize int64 //-1 if unknown
+
+	buffer  []byte //content of the next blocks. They are only written when more data follows, so the last block can be marked
+	out     []byte //the encoded blocks before they are written to target
+	started bool   //the frame header has been written
+	written int64  //content bytes given to Write
+	digest  xxhash.Digest
+	closed  bool
+}
+
+//magicNumber starts every frame of the current format
+const magicNumber = 0xFD2FB528
+
+//minRLERun is the shortest run that gets its own RLE block. It has to save more than the 4 bytes of the RLE block and the
+//3 bytes of the header of the raw block that follows
+const minRLERun = 32
+
+var ErrWriterClosed = errors.New("The writer has already been closed")
+var ErrContentSizeMismatch = errors.New("The number of bytes written does not match the content size")
+var ErrFrameStarted = errors.New("The frame options can only be set before the first write")
+
+//NewStoreWriter creates a StoreWriter that writes the frame to target
+func NewStoreWriter(target io.Writer) *StoreWriter {
+	sw := &StoreWriter{
+		buffer: make([]byte, 0, structure.MaxBlockSize),
+	}
+	sw.Reset(target)
+	return sw
+}
+
+//Reset prepares the StoreWriter for a new frame that is written to target. The checksum setting is kept, the content size is unknown again
+func (sw *StoreWriter) Reset(target io.Writer) {
+	sw.target = target
+	sw.contentSize = -1
+	sw.buffer = sw.buffer[:0]
+	sw.out = sw.out[:0]
+	sw.started = false
+	sw.written = 0
+	sw.digest.Reset()
+	sw.closed = false
+}
+
+//SetChecksum adds the XXH64 checksum of the content after the last block. It is kept for the following frames
+func (sw *StoreWriter) SetChecksum(checksum bool) error {
+	if sw.written > 0 || sw.started {
+		return ErrFrameStarted
+	}
+	sw.checksum = checksum
+	return nil
+}
+
+//SetContentSize writes the content size into the frame header, even if the content does not fit into one block.
+//Close fails if a different number of bytes has been written
+func (sw *StoreWriter) SetContentSize(size int64) error {
+	if sw.written > 0 || sw.started {
+		return ErrFrameStarted
+	}
+	sw.contentSize = size
+	return nil
+}
+
+//Write adds data to the frame. Full blocks are written to the target when more data follows
+func (sw *StoreWriter) Write(data []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrWriterClosed
+	}
+	if sw.contentSize >= 0 && sw.written+int64(len(data)) > sw.contentSize {
+		return 0, ErrContentSizeMismatch
+	}
+	if sw.checksum {
+		sw.digest.Write(data)
+	}
+
+	written := 0
+	for written < len(data) {
+		if len(sw.buffer) == cap(sw.buffer) {
+			err := sw.writeBlocks(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		end := len(sw.buffer)
+		n := copy(sw.buffer[end:cap(sw.buffer)], data[written:])
+		sw.buffer = sw.buffer[:end+n]
+		written += n
+		sw.written += int64(n)
+	}
+	return written, nil
+}
+
+//Close writes the last block and the checksum. The target is not closed
+func (sw *StoreWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	if sw.contentSize >= 0 && sw.written != sw.contentSize {
+		return ErrContentSizeMismatch
+	}
+	sw.closed = true
+	return sw.writeBlocks(true)
+}
+
+//writeBlocks writes the buffered content as blocks, preceded by the frame header if it has not been written yet
+func (sw *StoreWriter) writeBlocks(last bool) error {
+	out := sw.out[:0]
+	if !sw.started {
+		var err error
+		out, err = sw.appendFrameHeader(out, last)
+		if err != nil {
+			return err
+		}
+		sw.started = true
+	}
+
+	out = appendBlocks(out, sw.buffer, last)
+	if last && sw.checksum {
+		out = binary.LittleEndian.AppendUint32(out, uint32(sw.digest.Sum64()))
+	}
+	sw.out = out
+	sw.buffer = sw.buffer[:0]
+
+	n, err := sw.target.Write(out)
+	if err == nil && n < len(out) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+//appendFrameHeader appends the magic number and the frame header. If all content is in the buffer its size is known.
+//A window of one block is enough because the blocks never reference older data
+func (sw *StoreWriter) appendFrameHeader(dst []byte, allBuffered bool) ([]byte, error) {
+	contentSize := sw.contentSize
+	if contentSize < 0 && allBuffered {
+		contentSize = int64(len(sw.buffer))
+	}
+	return appendFrameHeader(dst, contentSize, structure.MaxBlockSize, sw.checksum)
+}
+
+//appendFrameHeader appends the magic number and the header of a frame with the given window. A content size of -1 is unknown.
+//Content that fits into the window makes a single segment frame
+func appendFrameHeader(dst []byte, contentSize int64, windowSize uint64, checksum bool) ([]byte, error) {
+	header := structure.FrameHeader{WindowSize: windowSize}
+	contentSizeBytes := byte(0)
+	singleSegment := false
+	if contentSize >= 0 {
+		header.FrameContentSize = uint64(contentSize)
+		singleSegment = header.FrameContentSize <= windowSize
+		contentSizeBytes = structure.ContentSizeBytes(header.FrameContentSize, singleSegment)
+	}
+
+	var err error
+	header.Descriptor, err = structure.NewFrameDescriptor(contentSizeBytes, singleSegment, checksum)
+	if err != nil {
+		return dst, err
+	}
+	dst = binary.LittleEndian.AppendUint32(dst, magicNumber)
+	return header.Encode(dst)
+}
+
+//appendBlocks appends data as raw blocks, with RLE blocks for the runs in it. An empty last block is a raw block of size 0
+func appendBlocks(dst []byte, data []byte, last bool) []byte {
+	start := 0 //data[start:i] has not been appended yet and has no long runs
+	for i := 0; i < len(data); {
+		run := runLength(data[i:])
+		if run < minRLERun {
+			i += run
+			continue
+		}
+		if start < i {
+			dst = appendBlock(dst, structure.BlockTypeRaw, data[start:i], false)
+		}
+		i += run
+		start = i
+		dst = appendBlock(dst, structure.BlockTypeRLE, data[i-run:i], last && i == len(data))
+	}
+	if start < len(data) || (last && len(data) == 0) {
+		dst = appendBlock(dst, structure.BlockTypeRaw, data[start:], last)
+	}
+	return dst
+}
+
+//appendBlock appends the header and the content of one block. RLE blocks only store the first byte of data
+func appendBlock(dst []byte, blockType structure.BlockType, data []byte, last bool) []byte {
+	header := structure.BlockHeader{LastBlock: last, Type: blockType, BlockSize: uint64(len(data))}
+	dst = header.Encode(dst)
+	if blockType == structure.BlockTypeRLE {
+		return append(dst, data[0])
+	}
+	return append(dst, data...)
+}
+
+//runLength returns how often the first byte of data is repeated at its start
+func runLength(data []byte) int {
+	n := 1
+	for n < len(data) && data[n] == data[0] {
+		n++
+	}
+	return n
+}
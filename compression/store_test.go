@@ -0,0 +1,180 @@
+package compression_test
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/compression"
+	"github.com/killingspark/sparkzstd/decompression"
+	"github.com/killingspark/sparkzstd/structure"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+//storeInputs are the contents the store tests write. They cover the block boundaries and runs that become RLE blocks
+func storeInputs() map[string][]byte {
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rand.Read(data)
+		return data
+	}
+	runs := []byte{}
+	for len(runs) < 300*1024 {
+		runs = append(runs, bytes.Repeat([]byte{byte(rand.Intn(4))}, 1+rand.Intn(200))...)
+	}
+	return map[string][]byte{
+		"empty":        {},
+		"one byte":     {42},
+		"one block":    random(structure.MaxBlockSize),
+		"block + 1":    random(structure.MaxBlockSize + 1),
+		"random":       random(1000 * 1000),
+		"zeros":        make([]byte, 3*structure.MaxBlockSize),
+		"runs":         runs,
+		"ends in run":  append(random(1000), make([]byte, 1000)...),
+		"starts w/run": append(make([]byte, 1000), random(1000)...),
+	}
+}
+
+//store writes data in pieces of random size into one frame and closes it
+func store(t *testing.T, sw io.WriteCloser, data []byte) {
+	for len(data) > 0 {
+		n := rand.Intn(3 * structure.MaxBlockSize / 2)
+		if n > len(data) {
+			n = len(data)
+		}
+		written, err := sw.Write(data[:n])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if written != n {
+			t.Fatalf("Wrote %d bytes instead of %d", written, n)
+		}
+		data = data[n:]
+	}
+	err := sw.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestStoreWriter(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	sw := compression.NewStoreWriter(compressed)
+	for _, checksum := range []bool{false, true} {
+		for _, knownSize := range []bool{false, true} {
+			for name, data := range storeInputs() {
+				compressed.Reset()
+				sw.Reset(compressed)
+				err := sw.SetChecksum(checksum)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if knownSize {
+					err = sw.SetContentSize(int64(len(data)))
+					if err != nil {
+						t.Fatal(err.Error())
+					}
+				}
+				store(t, sw, data)
+
+				result := &bytes.Buffer{}
+				metrics := &decompression.Metrics{}
+				fd := decompression.NewFrameDecompressor(bytes.NewReader(compressed.Bytes()), result)
+				fd.SetMetrics(metrics)
+				err = fd.Decompress()
+				if err != nil {
+					t.Fatalf("%s: %s", name, err.Error())
+				}
+				if !bytes.Equal(result.Bytes(), data) {
+					t.Errorf("%s: Wrong content", name)
+				}
+				if m := metrics.Snapshot(); m.CompressedBlocks != 0 {
+					t.Errorf("%s: Wrote compressed blocks", name)
+				}
+
+				//the header tells the content size whenever it is known
+				header := structure.FrameHeader{}
+				header.DecodeFrameDescriptor(compressed.Bytes()[4])
+				size, _ := header.Descriptor.GetContentSizeFlag()
+				if (knownSize || len(data) <= structure.MaxBlockSize) && size == 0 {
+					t.Errorf("%s: Header does not tell the content size", name)
+				}
+				if header.Descriptor.GetContentChecksumFlag() != checksum {
+					t.Errorf("%s: Wrong checksum flag", name)
+				}
+			}
+		}
+	}
+
+	//runs cost a few bytes
+	compressed.Reset()
+	sw.Reset(compressed)
+	store(t, sw, make([]byte, 1000*1000))
+	if compressed.Len() > 100 {
+		t.Errorf("Zeros should be stored as RLE blocks, but got %d bytes", compressed.Len())
+	}
+}
+
+func TestStoreWriterErrors(t *testing.T) {
+	sw := compression.NewStoreWriter(&bytes.Buffer{})
+	err := sw.SetContentSize(10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := sw.Write(make([]byte, 11)); err != compression.ErrContentSizeMismatch {
+		t.Errorf("Wrote more than the content size: %v", err)
+	}
+	sw.Write(make([]byte, 5))
+	if err := sw.SetChecksum(true); err != compression.ErrFrameStarted {
+		t.Errorf("Changed the checksum after the first write: %v", err)
+	}
+	if err := sw.Close(); err != compression.ErrContentSizeMismatch {
+		t.Errorf("Closed with less than the content size: %v", err)
+	}
+	sw.Write(make([]byte, 5))
+	if err := sw.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := sw.Write([]byte{1}); err != compression.ErrWriterClosed {
+		t.Errorf("Wrote after closing: %v", err)
+	}
+}
+
+//TestStoreWriterZstd checks the frames and the checksums with the original zstd, which verifies the checksums. It is skipped
+//if zstd is not in the PATH
+func TestStoreWriterZstd(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd is not in the PATH")
+	}
+
+	dir := t.TempDir()
+	sw := compression.NewStoreWriter(nil)
+	sw.SetChecksum(true)
+	for name, data := range storeInputs() {
+		compressed := &bytes.Buffer{}
+		sw.Reset(compressed)
+		store(t, sw, data)
+		//flip a byte of the content, so the checksum does not match anymore
+		corrupted := append([]byte{}, compressed.Bytes()...)
+		corrupted[len(corrupted)-5] ^= 1
+
+		for i, input := range [][]byte{compressed.Bytes(), corrupted} {
+			path := filepath.Join(dir, "frame.zst")
+			err := ioutil.WriteFile(path, input, 0644)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			out, err := exec.Command(zstd, "-d", "-q", "-c", path).Output()
+			corrupt := i == 1
+			if corrupt && len(data) > 0 && err == nil {
+				t.Errorf("%s: zstd accepted a wrong checksum", name)
+			}
+			if !corrupt && (err != nil || !bytes.Equal(out, data)) {
+				t.Errorf("%s: zstd could not decode the frame: %v", name, err)
+			}
+		}
+	}
+}
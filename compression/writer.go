@@ -0,0 +1,240 @@
+package compression
+
+import (
+	"encoding/binary"
+	"github.com/killingspark/sparkzstd/structure"
+	"github.com/killingspark/sparkzstd/xxhash"
+	"io"
+)
+
+//matchFinder finds the sequences of a block. src holds the window and the block, which starts at src[start]. The offsets of the
+//sequences are the real distances to the matches, the literals after the last match are not part of a sequence. offsets are the
+//last offsets at the start of the block, matches at them are the cheapest
+type matchFinder interface {
+	findSequences(sequences []structure.Sequence, src []byte, start int, offsets structure.OffsetHistory) []structure.Sequence
+	//shift is called after the data in the buffer moved n bytes to the front
+	shift(n int)
+	//reset forgets all data before a new frame
+	reset()
+}
+
+//Writer compresses the data written to it into one zstd frame. The data is split into blocks of 128kb, the matches are searched
+//in a window of 512kb to 8mb, depending on the level. Blocks that do not get smaller are stored like the StoreWriter does.
+//
+//The frame header is written together with the first block, so content that fits into one block is written as a single
+//segment frame with its content size
+type Writer struct {
+	target      io.Writer
+	checksum    bool
+	contentSize int64 //-1 if unknown
+	windowSize  int
+	finder      matchFinder
+
+	history   []byte //the window followed by the data that has not been compressed yet
+	processed int    //history[:processed] has been compressed
+	maxBuffer int    //the size history may grow to before it is slid
+
+	literals         *structure.LiteralsEncoder
+	sequencesEncoder *structure.SequencesEncoder
+	offsets          structure.OffsetHistory //the offsets the decoder has after the last block
+	sequences        []structure.Sequence
+	literalsBuffer   []byte
+	body             []byte //literals and sequences sections of the current block
+
+	out     []byte //the encoded blocks before they are written to target
+	started bool   //the frame header has been written
+	written int64  //content bytes given to Write
+	digest  xxhash.Digest
+	closed  bool
+}
+
+//NewWriter creates a Writer with the DefaultLevel that writes the frame to target
+func NewWriter(target io.Writer) *Writer {
+	w, _ := NewWriterLevel(target, DefaultLevel)
+	return w
+}
+
+//NewWriterLevel creates a Writer that compresses with the level. Like with the original zstd the levels 1 to MaxLevel trade
+//speed for ratio, the negative levels are even faster
+func NewWriterLevel(target io.Writer, level int) (*Writer, error) {
+	p, err := levelParameters(level)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		windowSize:       1 << uint(p.windowLog),
+		finder:           newMatchFinder(p),
+		literals:         structure.NewLiteralsEncoder(),
+		sequencesEncoder: structure.NewSequencesEncoder(),
+		literalsBuffer:   make([]byte, 0, structure.MaxBlockSize),
+		body:             make([]byte, 0, structure.MaxBlockSize),
+	}
+	w.maxBuffer = w.windowSize + 2*structure.MaxBlockSize
+	w.Reset(target)
+	return w, nil
+}
+
+//Reset prepares the Writer for a new frame that is written to target. The checksum setting is kept, the content size is unknown again
+func (w *Writer) Reset(target io.Writer) {
+	w.target = target
+	w.contentSize = -1
+	w.history = w.history[:0]
+	w.processed = 0
+	w.finder.reset()
+	w.literals.Reset()
+	w.sequencesEncoder.Reset()
+	w.offsets = structure.NewOffsetHistory()
+	w.out = w.out[:0]
+	w.started = false
+	w.written = 0
+	w.digest.Reset()
+	w.closed = false
+}
+
+//SetChecksum adds the XXH64 checksum of the content after the last block. It is kept for the following frames
+func (w *Writer) SetChecksum(checksum bool) error {
+	if w.written > 0 || w.started {
+		return ErrFrameStarted
+	}
+	w.checksum = checksum
+	return nil
+}
+
+//SetContentSize writes the content size into the frame header, even if the content does not fit into one block.
+//Close fails if a different number of bytes has been written
+func (w *Writer) SetContentSize(size int64) error {
+	if w.written > 0 || w.started {
+		return ErrFrameStarted
+	}
+	w.contentSize = size
+	return nil
+}
+
+//Write adds data to the frame. Full blocks are compressed and written to the target when more data follows
+func (w *Writer) Write(data []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if w.contentSize >= 0 && w.written+int64(len(data)) > w.contentSize {
+		return 0, ErrContentSizeMismatch
+	}
+	if w.checksum {
+		w.digest.Write(data)
+	}
+
+	written := 0
+	for written < len(data) {
+		if len(w.history)-w.processed == structure.MaxBlockSize {
+			err := w.writeBlock(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		if len(w.history) == w.maxBuffer {
+			w.slide()
+		}
+		n := len(data) - written
+		if room := structure.MaxBlockSize - (len(w.history) - w.processed); n > room {
+			n = room
+		}
+		if room := w.maxBuffer - len(w.history); n > room {
+			n = room
+		}
+		w.history = append(w.history, data[written:written+n]...)
+		written += n
+		w.written += int64(n)
+	}
+	return written, nil
+}
+
+//Close compresses and writes the last block and the checksum. The target is not closed
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if w.contentSize >= 0 && w.written != w.contentSize {
+		return ErrContentSizeMismatch
+	}
+	w.closed = true
+	return w.writeBlock(true)
+}
+
+//slide drops the data that is older than the window, so there is room for the next block
+func (w *Writer) slide() {
+	n := w.processed - w.windowSize
+	if n <= 0 {
+		return
+	}
+	copy(w.history, w.history[n:])
+	w.history = w.history[:len(w.history)-n]
+	w.processed -= n
+	w.finder.shift(n)
+}
+
+//writeBlock compresses the data that has not been compressed yet into one block, preceded by the frame header if it has not been written yet
+func (w *Writer) writeBlock(last bool) error {
+	out := w.out[:0]
+	if !w.started {
+		contentSize := w.contentSize
+		if contentSize < 0 && last {
+			contentSize = int64(len(w.history))
+		}
+		var err error
+		out, err = appendFrameHeader(out, contentSize, uint64(w.windowSize), w.checksum)
+		if err != nil {
+			return err
+		}
+		w.started = true
+	}
+
+	out = w.appendBlock(out, last)
+	if last && w.checksum {
+		out = binary.LittleEndian.AppendUint32(out, uint32(w.digest.Sum64()))
+	}
+	w.out = out
+	w.processed = len(w.history)
+
+	n, err := w.target.Write(out)
+	if err == nil && n < len(out) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+//appendBlock appends the block as compressed block. If that is not smaller than the data, the data is stored in raw and RLE blocks
+//and the encoders forget the tables and offsets of the block, because the decoder never sees them
+func (w *Writer) appendBlock(dst []byte, last bool) []byte {
+	block := w.history[w.processed:]
+	if len(block) == 0 {
+		return appendBlocks(dst, block, last)
+	}
+
+	offsets := w.offsets
+	w.sequences = w.finder.findSequences(w.sequences[:0], w.history, w.processed, offsets)
+	literals := w.literalsBuffer[:0]
+	pos := w.processed
+	for i := range w.sequences {
+		seq := &w.sequences[i]
+		literals = append(literals, w.history[pos:pos+seq.LiteralLength]...)
+		pos += seq.LiteralLength + seq.MatchLength
+		seq.Offset = offsets.Encode(seq.Offset, seq.LiteralLength)
+	}
+	literals = append(literals, w.history[pos:]...)
+	w.literalsBuffer = literals
+
+	body, err := w.literals.AppendLiteralsSection(w.body[:0], literals)
+	if err == nil {
+		body, err = w.sequencesEncoder.AppendSequencesSection(body, w.sequences)
+	}
+	w.body = body
+	if err != nil || len(body) >= len(block) {
+		return appendBlocks(dst, block, last)
+	}
+
+	w.literals.Commit()
+	w.sequencesEncoder.Commit()
+	w.offsets = offsets
+	header := structure.BlockHeader{LastBlock: last, Type: structure.BlockTypeCompressed, BlockSize: uint64(len(body))}
+	dst = header.Encode(dst)
+	return append(dst, body...)
+}
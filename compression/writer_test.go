@@ -0,0 +1,269 @@
+package compression_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/killingspark/sparkzstd/compression"
+	"github.com/killingspark/sparkzstd/decompression"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//corpus returns the uncompressed files of the corpus and the benchmark files
+func corpus(t testing.TB) map[string][]byte {
+	files := map[string][]byte{}
+	for _, pattern := range []string{"../decodecorpus_files/*.zst", "../benchmark_files/*.zst", "../decompression/testdata/*.zst"} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, path := range paths {
+			path = strings.TrimSuffix(path, ".zst")
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			files[filepath.Base(path)] = data
+		}
+	}
+	if len(files) == 0 {
+		t.Fatal("Found no files")
+	}
+	return files
+}
+
+//decompress decodes one frame and checks that it holds data
+func decompress(t *testing.T, name string, compressed []byte, data []byte) decompression.Metrics {
+	result := &bytes.Buffer{}
+	metrics := &decompression.Metrics{}
+	fd := decompression.NewFrameDecompressor(bytes.NewReader(compressed), result)
+	fd.SetMetrics(metrics)
+	err := fd.Decompress()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err.Error())
+	}
+	if !bytes.Equal(result.Bytes(), data) {
+		t.Fatalf("%s: Wrong content", name)
+	}
+	return metrics.Snapshot()
+}
+
+func TestWriterCorpus(t *testing.T) {
+	files := storeInputs()
+	if !testing.Short() {
+		files = corpus(t)
+	}
+	compressed := &bytes.Buffer{}
+	w := compression.NewWriter(compressed)
+	total, totalCompressed := 0, 0
+	for name, data := range files {
+		compressed.Reset()
+		w.Reset(compressed)
+		store(t, w, data)
+		decompress(t, name, compressed.Bytes(), data)
+		total += len(data)
+		totalCompressed += compressed.Len()
+	}
+	t.Logf("Compressed %d bytes to %d", total, totalCompressed)
+}
+
+func TestWriter(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	w := compression.NewWriter(compressed)
+	for _, checksum := range []bool{false, true} {
+		for _, knownSize := range []bool{false, true} {
+			for name, data := range storeInputs() {
+				compressed.Reset()
+				w.Reset(compressed)
+				err := w.SetChecksum(checksum)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if knownSize {
+					err = w.SetContentSize(int64(len(data)))
+					if err != nil {
+						t.Fatal(err.Error())
+					}
+				}
+				store(t, w, data)
+				decompress(t, name, compressed.Bytes(), data)
+			}
+		}
+	}
+
+	//every strategy has to handle the block boundaries and the runs. In short mode one level per kind of match finder
+	levels := []int{compression.MinLevel, 3, 8, 16}
+	if !testing.Short() {
+		levels = levels[:0]
+		for level := compression.MinLevel; level <= compression.MaxLevel; level++ {
+			levels = append(levels, level)
+		}
+	}
+	for _, level := range levels {
+		w, err := compression.NewWriterLevel(nil, level)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for name, data := range storeInputs() {
+			compressed.Reset()
+			w.Reset(compressed)
+			store(t, w, data)
+			decompress(t, name, compressed.Bytes(), data)
+		}
+	}
+
+	//text with many matches has to be compressed into real compressed blocks, even over the window of many blocks
+	text := []byte{}
+	words := strings.Fields("the quick brown fox jumps over the lazy dog while a zstd frame holds literals and sequences")
+	for len(text) < 2*1024*1024 {
+		text = append(text, words[rand.Intn(len(words))]...)
+		text = append(text, ' ')
+	}
+	compressed.Reset()
+	w.Reset(compressed)
+	store(t, w, text)
+	metrics := decompress(t, "text", compressed.Bytes(), text)
+	if metrics.CompressedBlocks == 0 || metrics.Sequences == 0 || metrics.CompressedLiterals == 0 {
+		t.Errorf("Text was not compressed: %+v", metrics)
+	}
+	if compressed.Len() > len(text)/3 {
+		t.Errorf("Text was compressed to %d of %d bytes", compressed.Len(), len(text))
+	}
+	if metrics.FSECompressed == 0 || metrics.FSERepeat+metrics.FSEPredefined+metrics.FSERLE == 0 {
+		t.Errorf("Not all table modes were used: %+v", metrics)
+	}
+
+	//incompressible data is stored
+	random := make([]byte, 1000*1000)
+	rand.Read(random)
+	compressed.Reset()
+	w.Reset(compressed)
+	store(t, w, random)
+	decompress(t, "random", compressed.Bytes(), random)
+	if compressed.Len() > len(random)+100 {
+		t.Errorf("Random data grew to %d bytes", compressed.Len())
+	}
+}
+
+//TestWriterZstd checks the compressed frames with the original zstd. It is skipped if zstd is not in the PATH
+func TestWriterZstd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipped in short mode")
+	}
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd is not in the PATH")
+	}
+
+	dir := t.TempDir()
+	files := corpus(t)
+	//one level per kind of match finder
+	for _, level := range []int{compression.MinLevel, 3, 8, 13, compression.MaxLevel} {
+		w, err := compression.NewWriterLevel(nil, level)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w.SetChecksum(true)
+		for name, data := range files {
+			compressed := &bytes.Buffer{}
+			w.Reset(compressed)
+			store(t, w, data)
+			path := filepath.Join(dir, "frame.zst")
+			err := ioutil.WriteFile(path, compressed.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			out, err := exec.Command(zstd, "-d", "-q", "-c", path).Output()
+			if err != nil || !bytes.Equal(out, data) {
+				t.Fatalf("Level %d, %s: zstd could not decode the frame: %v", level, name, err)
+			}
+		}
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	data := []byte{}
+	for _, content := range corpus(b) {
+		data = append(data, content...)
+	}
+	w := compression.NewWriter(io.Discard)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(io.Discard)
+		w.Write(data)
+		w.Close()
+	}
+}
+
+//BenchmarkLevels reports the speed and the ratio of every level over the corpus
+func BenchmarkLevels(b *testing.B) {
+	data := []byte{}
+	for _, content := range corpus(b) {
+		data = append(data, content...)
+	}
+	for level := compression.MinLevel; level <= compression.MaxLevel; level++ {
+		if level == 0 {
+			continue
+		}
+		b.Run(fmt.Sprintf("level%d", level), func(b *testing.B) {
+			w, err := compression.NewWriterLevel(nil, level)
+			if err != nil {
+				b.Fatal(err.Error())
+			}
+			compressed := &bytes.Buffer{}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				compressed.Reset()
+				w.Reset(compressed)
+				w.Write(data)
+				w.Close()
+			}
+			b.ReportMetric(float64(len(data))/float64(compressed.Len()), "ratio")
+		})
+	}
+}
+
+func TestWriterLevels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipped in short mode")
+	}
+	files := corpus(t)
+	previous := 0
+	for level := compression.MinLevel; level <= compression.MaxLevel; level++ {
+		if level == 0 {
+			continue
+		}
+		w, err := compression.NewWriterLevel(nil, level)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		totalCompressed := 0
+		for name, data := range files {
+			compressed := &bytes.Buffer{}
+			w.Reset(compressed)
+			store(t, w, data)
+			decompress(t, name, compressed.Bytes(), data)
+			totalCompressed += compressed.Len()
+		}
+		t.Logf("Level %d: %d bytes", level, totalCompressed)
+		//every level has to compress better than the one before
+		if level > compression.MinLevel && totalCompressed >= previous {
+			t.Errorf("Level %d compressed to %d bytes, more than the %d bytes of the level before", level, totalCompressed, previous)
+		}
+		previous = totalCompressed
+	}
+
+	for _, level := range []int{compression.MinLevel - 1, compression.MaxLevel + 1} {
+		if _, err := compression.NewWriterLevel(nil, level); err != compression.ErrInvalidLevel {
+			t.Errorf("Level %d: %v", level, err)
+		}
+	}
+}
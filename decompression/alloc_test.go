@@ -0,0 +1,78 @@
+package decompression
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//readCorpus reads all files of the decodecorpus
+func readCorpus(t *testing.T) map[string][]byte {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	corpus := make(map[string][]byte)
+	for _, file := range files {
+		compressed, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		corpus[file] = compressed
+	}
+	return corpus
+}
+
+func TestFrameReaderAllocs(t *testing.T) {
+	fr, err := NewFrameReader(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	source := bytes.NewReader(nil)
+	buf := make([]byte, 32*1024)
+
+	for file, compressed := range readCorpus(t) {
+		decode := func() {
+			source.Reset(compressed)
+			err := fr.Reset(source)
+			for err == nil {
+				_, err = fr.Read(buf)
+			}
+			if err != io.EOF {
+				t.Fatalf("%s: %s", file, err.Error())
+			}
+		}
+
+		//the first run sizes the buffers
+		decode()
+		if allocs := testing.AllocsPerRun(3, decode); allocs != 0 {
+			t.Errorf("%s: %v allocations per run", file, allocs)
+		}
+	}
+}
+
+func TestDecompressAllocs(t *testing.T) {
+	for _, twoPhase := range []bool{false, true} {
+		fd := NewFrameDecompressor(nil, nil)
+		fd.SetTwoPhaseSequences(twoPhase)
+		source := bytes.NewReader(nil)
+
+		for file, compressed := range readCorpus(t) {
+			decode := func() {
+				source.Reset(compressed)
+				fd.Reset(source, ioutil.Discard)
+				err := fd.Decompress()
+				if err != nil {
+					t.Fatalf("%s: %s", file, err.Error())
+				}
+			}
+
+			decode()
+			if allocs := testing.AllocsPerRun(3, decode); allocs != 0 {
+				t.Errorf("%s, two phase %v: %v allocations per run", file, twoPhase, allocs)
+			}
+		}
+	}
+}
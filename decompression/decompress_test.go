@@ -2,8 +2,11 @@ package decompression_test
 
 import (
 	"bytes"
-	"github.com/killingspark/sparkzsdt/decompression"
+	"github.com/killingspark/sparkzstd/decompression"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -13,20 +16,158 @@ func (nw *nullWriter) Write(data []byte) (int, error) {
 	return len(data), nil
 }
 
-func BenchmarkDecompress(b *testing.B) {
-	f, err := ioutil.ReadFile("../cmd/testingdata/ubuntu.zst")
+//corpusFile is a compressed file together with the size of its original
+type corpusFile struct {
+	name       string
+	compressed []byte
+	size       int64
+}
+
+//loadCorpus reads all .zst files matching pattern. The originals have the same path without .zst
+func loadCorpus(tb testing.TB, pattern string) []corpusFile {
+	files, err := filepath.Glob(pattern)
 	if err != nil {
-		panic(err.Error())
+		tb.Fatal(err.Error())
+	}
+	if len(files) == 0 {
+		tb.Fatalf("No files match %s", pattern)
+	}
+	var corpus []corpusFile
+	for _, file := range files {
+		f, err := ioutil.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err.Error())
+		}
+		info, err := os.Stat(strings.TrimSuffix(file, ".zst"))
+		if err != nil {
+			tb.Fatal(err.Error())
+		}
+		corpus = append(corpus, corpusFile{name: filepath.Base(file), compressed: f, size: info.Size()})
 	}
-	content := bytes.NewBuffer(f)
+	return corpus
+}
 
-	outfile := &nullWriter{}
+//benchmarkFiles runs one sub-benchmark for every file that reports MB/s of decompressed data and the allocations
+func benchmarkFiles(b *testing.B, pattern string) {
+	dec := decompression.NewFrameDecompressor(nil, &nullWriter{})
+	source := bytes.NewReader(nil)
+	for _, file := range loadCorpus(b, pattern) {
+		b.Run(strings.TrimSuffix(file.name, ".zst"), func(b *testing.B) {
+			b.SetBytes(file.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				source.Reset(file.compressed)
+				dec.Reset(source, &nullWriter{})
+				err := dec.Decompress()
+				if err != nil {
+					b.Fatal(err.Error())
+				}
+			}
+		})
+	}
+}
 
-	//outfile, err := os.OpenFile("/dev/null", os.O_WRONLY, 777)
-	if err != nil {
-		panic(err.Error())
+//BenchmarkDecodecorpusFiles benchmarks every file of the decodecorpus on its own
+func BenchmarkDecodecorpusFiles(b *testing.B) {
+	benchmarkFiles(b, "../decodecorpus_files/*.zst")
+}
+
+//BenchmarkSynthetic benchmarks the files in benchmark_files that each stress one feature. See cmd/benchdata
+func BenchmarkSynthetic(b *testing.B) {
+	benchmarkFiles(b, "../benchmark_files/*.zst")
+}
+
+//TestSyntheticFiles makes sure the synthetic files decode correctly and still stress what they were made for
+func TestSyntheticFiles(t *testing.T) {
+	expectations := map[string]func(m decompression.Metrics) bool{
+		"raw.zst": func(m decompression.Metrics) bool {
+			return m.RawBlocks > 0 && m.CompressedBlocks == 0
+		},
+		"rle.zst": func(m decompression.Metrics) bool {
+			return m.RLEBlocks > 0 && m.CompressedBlocks == 0
+		},
+		"huffman.zst": func(m decompression.Metrics) bool {
+			return m.CompressedLiterals+m.TreelessLiterals == m.CompressedBlocks && m.Sequences < m.BytesOut/100
+		},
+		"longmatches.zst": func(m decompression.Metrics) bool {
+			return m.Sequences > 0 && m.BytesOut/m.Sequences > 1000
+		},
+		"repeatoffsets.zst": func(m decompression.Metrics) bool {
+			return m.Sequences > m.BytesOut/100
+		},
+		"smallwindow.zst": func(m decompression.Metrics) bool {
+			return m.Sequences > 0
+		},
 	}
 
-	dec := decompression.NewFrameDecompressor(content, outfile)
-	err = dec.Decompress()
+	for _, file := range loadCorpus(t, "../benchmark_files/*.zst") {
+		expected, ok := expectations[file.name]
+		if !ok {
+			t.Errorf("%s: No expectations for this file", file.name)
+			continue
+		}
+		original, err := ioutil.ReadFile(strings.TrimSuffix(filepath.Join("../benchmark_files", file.name), ".zst"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		metrics := &decompression.Metrics{}
+		result := &bytes.Buffer{}
+		dec := decompression.NewFrameDecompressor(bytes.NewReader(file.compressed), result)
+		dec.SetMetrics(metrics)
+		err = dec.Decompress()
+		if err != nil {
+			t.Fatalf("%s: %s", file.name, err.Error())
+		}
+		if !bytes.Equal(result.Bytes(), original) {
+			t.Errorf("%s: Wrong output", file.name)
+		}
+		if !expected(metrics.Snapshot()) {
+			t.Errorf("%s: Does not stress its feature anymore: %+v", file.name, metrics.Snapshot())
+		}
+	}
+}
+
+func BenchmarkDecodecorpus(b *testing.B) {
+	benchmarkDecoding(b, "../decodecorpus_files/*.zst", false)
+}
+
+func BenchmarkDecodecorpusPipelined(b *testing.B) {
+	benchmarkDecoding(b, "../decodecorpus_files/*.zst", true)
+}
+
+//BenchmarkPipeline compares sequential and pipelined decoding of the big frames in benchmark_files
+func BenchmarkPipeline(b *testing.B) {
+	b.Run("sequential", func(b *testing.B) {
+		benchmarkDecoding(b, "../benchmark_files/*.zst", false)
+	})
+	b.Run("pipelined", func(b *testing.B) {
+		benchmarkDecoding(b, "../benchmark_files/*.zst", true)
+	})
+}
+
+func benchmarkDecoding(b *testing.B, pattern string, pipelined bool) {
+	corpus := loadCorpus(b, pattern)
+	size := int64(0)
+	for _, file := range corpus {
+		size += file.size
+	}
+
+	dec := decompression.NewFrameDecompressor(nil, &nullWriter{})
+	dec.SetPipelined(pipelined)
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, file := range corpus {
+			dec.Reset(bytes.NewReader(file.compressed), &nullWriter{})
+			err := dec.Decompress()
+			if err != nil {
+				b.Fatal(err.Error())
+			}
+			if dec.UsesPipeline() != pipelined {
+				b.Fatalf("%s: Pipeline used: %v", file.name, dec.UsesPipeline())
+			}
+		}
+	}
 }
@@ -3,11 +3,12 @@ package decompression
 import (
 	"bufio"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
+	"github.com/killingspark/sparkzstd/legacy"
 	"github.com/killingspark/sparkzstd/structure"
 	"io"
-	"strconv"
+	"math"
+	"time"
 )
 
 //FrameDecompressor is the Struct that holds all info and funcs for decompressing a zstd frame
@@ -16,19 +17,37 @@ type FrameDecompressor struct {
 	source *bufio.Reader
 	target io.Writer
 
-	//will be limited to the CurrentBlocks size and given to the decoding functions
-	limitedSource *io.LimitedReader
+	sourceCounter countingReader //counts the bytes read from source. Used for the offsets in the Observer events
 
-	decodebuffer  *Ringbuffer //must be at least frame.Header.WindowSize long. Will be used in decoding the CurrentBlock
-	offsetHistory [3]int64
+	//will be limited to the CurrentBlocks size and given to the decoding functions through blockSource
+	limitedSource io.LimitedReader
+	blockSource   *bufio.Reader
 
-	//used in sequence execution
-	literalsCopyBuf [128 * 1024]byte //can only be max 128kb. Allocate here once instead of for every block
+	decodebuffer  *Window //holds at least frame.Header.WindowSize bytes of history. Will be used in decoding the CurrentBlock
+	offsetHistory [3]int64
 
-	//used while decoding/decompressing literals and sequences sections
-	literalsDataBuf           [128 * 1024]byte //can only be max 128kb. Allocate here once instead of for every block
-	literalsCompressedDataBuf [128 * 1024]byte //can only be max 128kb. Allocate here once instead of for every block
-	sequencesDataBuf          [128 * 1024]byte //can only be max 128kb. Allocate here once instead of for every block
+	//used while decoding/decompressing literals and sequences sections. Sized by allocateBlockBuffers when a frame header is decoded
+	literalsDataBuf           []byte
+	literalsCompressedDataBuf []byte
+	sequencesDataBuf          []byte
+	sequencesBuf              []structure.Sequence //only used if the sequences are decoded before they are executed
+	tables                    *structure.TableBuffers
+	maxBlockSize              int //min(window size, 128kb) for the current frame
+	memoryProfile             MemoryProfile
+	historyCache              int    //see SetFileBackedHistory
+	historyStart              int64  //where the output of the current frame starts in the HistoryFile
+	maxWindowSize             uint64 //see SetMaxWindowSize. 0 is DefaultMaxWindowSize
+
+	//decode all sequences of a block into CurrentBlock.Sequences.Sequences before executing them. Off by default, then the
+	//sequences are decoded in small batches into sequenceBatch and executed right after
+	twoPhaseSequences bool
+	sequenceBatch     [64]structure.Sequence
+
+	//decode the blocks ahead in a second goroutine while they are executed. See SetPipelined
+	pipelined       bool
+	pipelineRunning bool
+	pipelineOffset  int64            //compressed offset after the block that was executed last
+	pipelineBlocks  []*pipelineBlock //the first one shares the block buffers above
 
 	CurrentBlock  structure.Block
 	PreviousBlock structure.Block
@@ -36,81 +55,133 @@ type FrameDecompressor struct {
 
 	headerbuffer [14]byte //just used to temporarly hold frameheader or blockheader data while decoding these
 
-	Verbose bool
+	//only used for frames of the legacy versions v0.5 to v0.7. legacyVersion is 0 for all other frames
+	legacyVersion     legacy.Version
+	legacyFrameHeader legacy.FrameHeader
+	legacyBlocks      *legacy.BlockDecoder
+
+	//Observer receives events about the decoding progress. It is nil by default. With an Observer SetPipelined has no effect
+	Observer Observer
+	progress progressTracker
+
+	metrics    *Metrics
+	metricsIn  int64 //compressed offset that was counted last
+	metricsOut int64 //decompressed offset that was counted last
+
+	frameOffset   int64         //compressed offset of the current frames magic number
+	frameDuration time.Duration //time spent decoding the current frame. Only measured if there is an Observer
 }
 
+//Reset prepares the FrameDecompressor for a new source. The Observer and the ProgressConfig are kept
 func (fd *FrameDecompressor) Reset(newsource io.Reader, newtarget io.Writer) {
-	fd.source = bufio.NewReader(newsource)
+	fd.sourceCounter = countingReader{r: newsource}
+	fd.source.Reset(&fd.sourceCounter)
 	fd.target = newtarget
+	fd.progress.reset()
+	fd.metricsIn = 0
+	fd.resetFrame()
+	fd.historyStart = 0
+}
 
+//resetFrame prepares the decoding of the next frame from the same source. Its output follows the output of the frame before
+func (fd *FrameDecompressor) resetFrame() {
+	fd.historyStart += fd.decompressedOffset()
 	fd.frame = structure.Frame{}
-	fd.limitedSource = nil
+	fd.limitedSource = io.LimitedReader{}
 	fd.offsetHistory = [3]int64{1, 4, 8}
 	fd.CurrentBlock = structure.Block{}
 	fd.PreviousBlock = structure.Block{}
 	fd.BlockCounter = 0
+	fd.legacyVersion = 0
+	fd.metricsOut = 0
 }
 
 //NewFrameDecompressor makes a new FrameDecompressor that reads compressed data from s and writes decompressed data to t
 func NewFrameDecompressor(s io.Reader, t io.Writer) *FrameDecompressor {
-	return &FrameDecompressor{
-		source:        bufio.NewReader(s),
+	fd := &FrameDecompressor{
 		target:        t,
 		offsetHistory: [3]int64{1, 4, 8},
 	}
-}
-
-func (fd *FrameDecompressor) printStatus() error {
-	println("##############################")
-	println("Frame Decoder Status")
-	println("##############################")
-
-	fheader, err := json.MarshalIndent(fd.frame.Header, "\t", "   ")
-	bheader, err := json.MarshalIndent(fd.CurrentBlock, "\t", "   ")
-
-	status := "FrameHeader: \n\t" + string(fheader)
-	status += "\n\nSingleSegment: " + strconv.FormatBool(fd.frame.Header.Descriptor.GetSingleSegmentFlag())
-	status += "\n\nDecodebuffer Size: " + strconv.Itoa(fd.decodebuffer.Len)
-	status += "\n\nCurrentBlock: \n\t" + string(bheader)
-	status += "\n"
-
-	if err != nil {
-		return err
-	}
-
-	println(status)
-
-	println("##############################")
-	println("Frame Decoder Status Finished")
-	println("##############################")
-
-	return err
+	fd.sourceCounter = countingReader{r: s}
+	fd.source = bufio.NewReaderSize(&fd.sourceCounter, sourceBufferSize)
+	fd.blockSource = bufio.NewReaderSize(&fd.limitedSource, sourceBufferSize)
+	return fd
 }
 
 var ErrCorruptSizes = errors.New("The sizes of literal and sequence section did not add up to blocksize")
 
 //DecodeNextBlockContent decodes the literal and sequence section of the current block
 func (fd *FrameDecompressor) DecodeNextBlockContent() error {
-	bufsrc := bufio.NewReader(fd.limitedSource)
-	err := fd.CurrentBlock.Literals.DecodeNextLiteralsSection(bufsrc, &fd.PreviousBlock)
-	if fd.Verbose {
-		fd.printCurrentBlockLiterals()
+	//the content is read through bufsrc, so the offset of the block has to be calculated beforehand
+	blockOffset := fd.sourceOffset()
+	var start time.Time
+	if fd.Observer != nil || fd.metrics != nil {
+		start = time.Now()
 	}
+
+	bufsrc := fd.blockSource
+	bufsrc.Reset(&fd.limitedSource)
+	err := fd.CurrentBlock.Literals.DecodeNextLiteralsSection(bufsrc, &fd.PreviousBlock)
 	if err != nil {
 		return err
 	}
 
-	bytesUsedByLiterals := uint64(fd.CurrentBlock.Literals.Header.CompressedSize + fd.CurrentBlock.Literals.Header.BytesUsedByHeader + fd.CurrentBlock.Literals.BytesUsedByTree)
+	literals := &fd.CurrentBlock.Literals
+	bytesUsedByLiterals := uint64(literals.Header.CompressedSize + literals.Header.BytesUsedByHeader + literals.BytesUsedByTree)
 	bytesLeft := fd.CurrentBlock.Header.BlockSize - bytesUsedByLiterals
 
-	err = fd.CurrentBlock.Sequences.DecodeNextSequenceSection(bufsrc, int(bytesLeft), &fd.PreviousBlock)
-	if fd.Verbose {
-		fd.printCurrentBlockSequences()
+	if fd.metrics != nil {
+		fd.metrics.countLiterals(literals.Header.Type)
+		addDuration(&fd.metrics.LiteralsTime, start)
+	}
+	if fd.Observer != nil {
+		fd.Observer.LiteralsDecoded(LiteralsEvent{
+			BlockIndex:       fd.BlockCounter,
+			Type:             literals.Header.Type,
+			RegeneratedSize:  literals.Header.RegeneratedSize,
+			CompressedSize:   literals.Header.CompressedSize,
+			NumberOfStreams:  literals.Header.NumberOfStreams,
+			HeaderSize:       literals.Header.BytesUsedByHeader,
+			TreeSize:         literals.BytesUsedByTree,
+			CompressedOffset: blockOffset,
+			Duration:         time.Since(start),
+		})
+	}
+	if fd.Observer != nil || fd.metrics != nil {
+		start = time.Now()
+	}
+
+	if fd.twoPhaseSequences || fd.pipelineRunning {
+		err = fd.CurrentBlock.Sequences.DecodeNextSequenceSection(bufsrc, int(bytesLeft), &fd.PreviousBlock)
+		//keep the slice if it had to grow
+		if cap(fd.CurrentBlock.Sequences.Sequences) > cap(fd.sequencesBuf) {
+			fd.sequencesBuf = fd.CurrentBlock.Sequences.Sequences[:0]
+		}
+	} else {
+		//the sequences are decoded while they are executed
+		err = fd.CurrentBlock.Sequences.ReadNextSequenceSection(bufsrc, int(bytesLeft), &fd.PreviousBlock)
 	}
 	if err != nil {
 		return err
 	}
 
+	if fd.metrics != nil {
+		fd.metrics.countSequences(&fd.CurrentBlock.Sequences.Header)
+		addDuration(&fd.metrics.SequencesTime, start)
+	}
+	if fd.Observer != nil {
+		header := &fd.CurrentBlock.Sequences.Header
+		fd.Observer.SequencesDecoded(SequencesEvent{
+			BlockIndex:         fd.BlockCounter,
+			NumberOfSequences:  header.NumberOfSequences,
+			LiteralsLengthMode: header.LiteralsLengthMode,
+			OffsetsMode:        header.OffsetsMode,
+			MatchLengthsMode:   header.MatchLengthsMode,
+			CompressedOffset:   blockOffset + int64(bytesUsedByLiterals),
+			Duration:           time.Since(start),
+		})
+	}
+
 	bytesUsedWhileDecoding := int(bytesUsedByLiterals) + len(fd.CurrentBlock.Sequences.Data) + fd.CurrentBlock.Sequences.Header.BytesUsedByHeader
 	if uint64(bytesUsedWhileDecoding) != fd.CurrentBlock.Header.BlockSize {
 		return ErrCorruptSizes
@@ -127,30 +198,38 @@ func (fd *FrameDecompressor) DecodeNextBlockContent() error {
 
 var ErrWrongMagicnumber = errors.New("Magicnum is not correct")
 
+//CheckMagicnum reads the magic number at the beginning of the frame. Besides the current format the legacy
+//formats v0.5 to v0.7 are accepted. DecodeFrameHeader and DecodeNextBlock then decode the frame in that format
 func (fd *FrameDecompressor) CheckMagicnum() error {
 	//read the magicnumber at the beginning of the file
-	var magicnum [4]byte
-	n := 0
-	for n < 4 {
-		x, err := fd.source.Read(magicnum[:])
-		n += x
-		if err != nil {
-			return err
-		}
+	magicnum := fd.headerbuffer[:4]
+	fd.frameOffset = fd.compressedOffset()
+	fd.frameDuration = 0
+	_, err := io.ReadFull(fd.source, magicnum)
+	if err != nil {
+		return err
 	}
-	var magicnumshould [4]byte
-	binary.LittleEndian.PutUint32(magicnumshould[:], 0xFD2FB528)
 
-	for idx := range magicnum {
-		if magicnum[idx] != magicnumshould[idx] {
-			return ErrWrongMagicnumber
-		}
+	fd.legacyVersion = 0
+	num := binary.LittleEndian.Uint32(magicnum)
+	if num == 0xFD2FB528 {
+		return nil
 	}
+
+	version, ok := legacy.VersionFromMagicnum(num)
+	if !ok {
+		return ErrWrongMagicnumber
+	}
+	fd.legacyVersion = version
 	return nil
 }
 
-//Decompress just decompresses the whole frame and writes the whole output to the target
+//Decompress just decompresses the whole frame and writes the whole output to the target. If the source holds more frames
+//the next call decompresses the next one
 func (fd *FrameDecompressor) Decompress() error {
+	if fd.CurrentBlock.Header.LastBlock {
+		fd.resetFrame()
+	}
 	err := fd.CheckMagicnum()
 	if err != nil {
 		return err
@@ -169,81 +248,136 @@ func (fd *FrameDecompressor) Decompress() error {
 	return nil
 }
 
-func (fd *FrameDecompressor) printCurrentBlockHeader() {
-	println("##############################")
-	print("Next Block: ")
-	println(fd.BlockCounter)
-	println("##############################")
-
-	msh, _ := json.MarshalIndent(fd.CurrentBlock.Header, "\t", "   ")
-	println("\t" + string(msh))
-	if fd.CurrentBlock.Header.Type == structure.BlockTypeRaw {
-		println("\tRaw Block, just copy blocksize bytes.\n")
-	}
-}
-
-func (fd *FrameDecompressor) printCurrentBlockLiterals() {
-	println("Literals")
-	msh, _ := json.MarshalIndent(fd.CurrentBlock.Literals, "\t", "   ")
-	println("\t" + string(msh))
-}
-func (fd *FrameDecompressor) printCurrentBlockSequences() {
-	println("Sequences")
-	msh, _ := json.MarshalIndent(fd.CurrentBlock.Sequences, "\t", "   ")
-	println("\t" + string(msh))
-}
-
 var ErrOutOfBlocks = errors.New("No blocks left in frame")
+var ErrBlockTooLarge = errors.New("Block is larger than the window of the frame")
 
 func (fd *FrameDecompressor) DecodeNextBlock() error {
+	if fd.legacyVersion != 0 {
+		return fd.decodeNextLegacyBlock()
+	}
 	if fd.CurrentBlock.Header.LastBlock {
 		return ErrOutOfBlocks
 	}
-	err := fd.DecodeNextBlockHeader()
-	if fd.Verbose {
-		fd.printCurrentBlockHeader()
+
+	var start time.Time
+	headerOffset := int64(0)
+	if fd.Observer != nil {
+		start = time.Now()
+		headerOffset = fd.compressedOffset()
 	}
+
+	err := fd.DecodeNextBlockHeader()
 	if err != nil {
 		return err
 	}
+	if fd.CurrentBlock.Header.BlockSize > uint64(fd.maxBlockSize) {
+		return ErrBlockTooLarge
+	}
+	if fd.metrics != nil {
+		fd.metrics.countBlock(fd.CurrentBlock.Header.Type)
+	}
+
+	if fd.Observer != nil {
+		fd.Observer.BlockHeaderDecoded(BlockHeaderEvent{
+			BlockIndex:         fd.BlockCounter,
+			Header:             fd.CurrentBlock.Header,
+			CompressedOffset:   headerOffset,
+			DecompressedOffset: fd.decompressedOffset(),
+			Duration:           time.Since(start),
+		})
+	}
 
 	switch fd.CurrentBlock.Header.Type {
 	case structure.BlockTypeRaw:
-		_, err := io.CopyN(fd.decodebuffer, fd.source, int64(fd.CurrentBlock.Header.BlockSize))
+		err := fd.decodebuffer.PushFrom(fd.source, int(fd.CurrentBlock.Header.BlockSize))
 		if err != nil {
 			return err
 		}
 
 	case structure.BlockTypeCompressed:
-		fd.limitedSource = &io.LimitedReader{R: fd.source, N: int64(fd.CurrentBlock.Header.BlockSize)}
+		fd.limitedSource = io.LimitedReader{R: fd.source, N: int64(fd.CurrentBlock.Header.BlockSize)}
 		err = fd.DecodeNextBlockContent()
 
 		if err != nil {
 			return err
 		}
 
-		err = fd.ExecuteSequences()
+		var executionStart time.Time
+		if fd.metrics != nil {
+			executionStart = time.Now()
+		}
+		if fd.twoPhaseSequences {
+			err = fd.ExecuteSequences()
+		} else {
+			err = fd.decodeAndExecuteSequences()
+		}
 		if err != nil {
 			return err
 		}
+		if fd.metrics != nil {
+			addDuration(&fd.metrics.ExecutionTime, executionStart)
+		}
 	default:
-		//TODO implement RLE Blocks
-		var b [1]byte
-		b[0], err = fd.source.ReadByte()
+		b, err := fd.source.ReadByte()
+		if err != nil {
+			return err
+		}
+		err = fd.decodebuffer.PushRLE(b, int(fd.CurrentBlock.Header.BlockSize))
 		if err != nil {
 			return err
 		}
-		for i := uint64(0); i < fd.CurrentBlock.Header.BlockSize; i++ {
-			err = fd.decodebuffer.Push(b[:])
-			if err != nil {
-				return err
-			}
+	}
+
+	return fd.blockDone(start)
+}
+
+//blockDone is called after every decoded block. It consumes the checksum after the last block and reports the progress
+func (fd *FrameDecompressor) blockDone(start time.Time) error {
+	blocks := fd.BlockCounter + 1
+	if fd.legacyVersion != 0 {
+		//the end block is not counted
+		blocks = fd.BlockCounter
+	} else {
+		err := fd.skipChecksum()
+		if err != nil {
+			return err
+		}
+	}
+
+	if fd.Observer != nil {
+		fd.frameDuration += time.Since(start)
+		if fd.CurrentBlock.Header.LastBlock {
+			fd.finishFrame(blocks)
+		}
+	}
+	if fd.metrics != nil {
+		fd.countBytes(fd.CurrentBlock.Header.LastBlock)
+	}
+	fd.reportProgress(fd.CurrentBlock.Header.LastBlock)
+	return nil
+}
+
+//skipChecksum reads the checksum after the last block of a frame in the current format
+func (fd *FrameDecompressor) skipChecksum() error {
+	if fd.CurrentBlock.Header.LastBlock && fd.frame.Header.Descriptor.GetContentChecksumFlag() {
+		//the checksum is not verified yet but it has to be read so the next frame can be found
+		_, err := io.ReadFull(fd.source, fd.headerbuffer[:4])
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 func (fd *FrameDecompressor) decodeAllBlocks() error {
+	if fd.UsesPipeline() {
+		err := fd.decodeAllBlocksPipelined()
+		if err != nil {
+			return err
+		}
+		return fd.decodebuffer.Flush()
+	}
+
 	for !fd.CurrentBlock.Header.LastBlock {
 		err := fd.DecodeNextBlock()
 		if err != nil {
@@ -252,18 +386,8 @@ func (fd *FrameDecompressor) decodeAllBlocks() error {
 
 		fd.BlockCounter++
 	}
-	if fd.Verbose {
-		println("##############################")
-		println("Blocks Done")
-		println("##############################")
-	}
 
-	fd.decodebuffer.Flush()
-	if fd.Verbose {
-		print("Total written: ")
-		println(fd.decodebuffer.dumped)
-	}
-	return nil
+	return fd.decodebuffer.Flush()
 }
 
 //DecodeNextBlockHeader reads the next blockheader and swaps out previous block with currentblock
@@ -294,9 +418,12 @@ func (fd *FrameDecompressor) DecodeNextBlockHeader() error {
 	}
 
 	//carry over buffers for reuse
-	newBlock.Literals.CompressedData = fd.literalsCompressedDataBuf[:]
-	newBlock.Literals.Data = fd.literalsDataBuf[:]
-	newBlock.Sequences.Data = fd.sequencesDataBuf[:]
+	newBlock.Literals.CompressedData = fd.literalsCompressedDataBuf
+	newBlock.Literals.Data = fd.literalsDataBuf
+	newBlock.Sequences.Data = fd.sequencesDataBuf
+	newBlock.Sequences.Sequences = fd.sequencesBuf[:0]
+	newBlock.Literals.Tables = fd.tables
+	newBlock.Sequences.Tables = fd.tables
 
 	fd.CurrentBlock = newBlock
 	return err
@@ -304,6 +431,19 @@ func (fd *FrameDecompressor) DecodeNextBlockHeader() error {
 
 //DecodeFrameHeader before starting to read the blocks
 func (fd *FrameDecompressor) DecodeFrameHeader() error {
+	var start time.Time
+	if fd.Observer != nil {
+		start = time.Now()
+	}
+
+	if fd.legacyVersion != 0 {
+		err := fd.decodeLegacyFrameHeader()
+		if err != nil {
+			return err
+		}
+		fd.frameHeaderDecoded(start)
+		return nil
+	}
 
 	n := 0
 	var err error
@@ -360,15 +500,105 @@ func (fd *FrameDecompressor) DecodeFrameHeader() error {
 		}
 	}
 
-	if fd.decodebuffer == nil {
-		fd.decodebuffer = NewRingbuffer(int(fd.frame.Header.WindowSize), fd.target)
-	} else {
-		fd.decodebuffer.Reset(int(fd.frame.Header.WindowSize), fd.target)
+	err = fd.resetWindow(fd.frame.Header.WindowSize, fd.frame.Header.Descriptor.GetSingleSegmentFlag())
+	if err != nil {
+		return err
 	}
+	fd.allocateBlockBuffers(blockBufferSize(int(fd.frame.Header.WindowSize), fd.memoryProfile))
 
-	if fd.Verbose {
-		fd.printStatus()
+	fd.frameHeaderDecoded(start)
+	return nil
+}
+
+var ErrWindowTooBig = errors.New("The window of the frame is too big to be decoded on this platform")
+var ErrWindowOverLimit = errors.New("The window of the frame is bigger than the limit set with SetMaxWindowSize")
+
+//DefaultMaxWindowSize is the biggest window that is decoded unless SetMaxWindowSize allows more. It is the default windowLogMax
+//of the original zstd
+const DefaultMaxWindowSize = 1 << 27
+
+//resetWindow prepares the Window for a frame with a window of size bytes. The window is checked before anything is allocated,
+//the size comes straight from the frame header. It has to fit into an int and the buffer, which holds two windows (or two caches
+//for a file-backed history, or the content of a single segment frame once), too. On 32-bit platforms that limits windows to
+//less than 1GB, or 2GB with a file-backed history. The part of the window that is held in memory also has to be within the
+//limit of SetMaxWindowSize
+func (fd *FrameDecompressor) resetWindow(size uint64, singleSegment bool) error {
+	file, ok := fd.target.(HistoryFile)
+	history := fd.historyCache > 0 && ok
+	//the output is read back from the file only if the window does not fit into the cache
+	fileBacked := history && size > uint64(fd.historyCache)
+
+	if size > math.MaxInt {
+		return ErrWindowTooBig
+	}
+	buffered := size
+	if fileBacked && buffered > uint64(fd.historyCache) {
+		buffered = uint64(fd.historyCache)
 	}
+	singleSegment = singleSegment && !fileBacked
+	if !singleSegment && buffered > math.MaxInt/2 {
+		return ErrWindowTooBig
+	}
+	if buffered > fd.windowLimit() {
+		return ErrWindowOverLimit
+	}
+	n := int(size)
 
+	if fd.decodebuffer == nil {
+		fd.decodebuffer = &Window{}
+	}
+	dump := fd.target
+	if history {
+		//all frames are written with WriteAt, so the frames that are read back and the others do not overwrite each other
+		dump = io.NewOffsetWriter(file, fd.historyStart)
+	}
+	switch {
+	case fileBacked:
+		fd.decodebuffer.ResetFileBacked(n, fd.historyCache, file, fd.historyStart)
+	case singleSegment:
+		fd.decodebuffer.ResetSingleSegment(n, dump)
+	default:
+		fd.decodebuffer.Reset(n, dump)
+	}
 	return nil
 }
+
+//SetMaxWindowSize sets the biggest window that is decoded. Frames with bigger windows are rejected with ErrWindowOverLimit
+//before their buffers are allocated, so a corrupt or malicious frame header can not make the decoder allocate gigabytes. With
+//SetFileBackedHistory only the cache counts. Zero restores DefaultMaxWindowSize. It takes effect with the next frame header
+func (fd *FrameDecompressor) SetMaxWindowSize(n uint64) {
+	fd.maxWindowSize = n
+}
+
+//windowLimit is the limit of SetMaxWindowSize
+func (fd *FrameDecompressor) windowLimit() uint64 {
+	if fd.maxWindowSize == 0 {
+		return DefaultMaxWindowSize
+	}
+	return fd.maxWindowSize
+}
+
+//SetFileBackedHistory lets frames whose window is bigger than cache keep only the newest cache bytes of it in memory, if the target
+//is a HistoryFile like an *os.File that was opened for reading and writing. Matches that reach further back read their source back
+//from the target. This way frames with huge windows (eg. from zstd --long=31) can be decoded with little memory.
+//The output is then written with WriteAt, starting at offset 0 of the target, the frames of the source one after another.
+//Zero disables it, which is the default. It takes effect with the next frame header
+func (fd *FrameDecompressor) SetFileBackedHistory(cache int) {
+	fd.historyCache = cache
+}
+
+func (fd *FrameDecompressor) frameHeaderDecoded(start time.Time) {
+	if fd.Observer == nil {
+		return
+	}
+	duration := time.Since(start)
+	fd.frameDuration += duration
+	offset := fd.compressedOffset()
+	fd.Observer.FrameHeaderDecoded(FrameHeaderEvent{
+		Header:           fd.frame.Header,
+		LegacyVersion:    fd.legacyVersion,
+		CompressedOffset: fd.frameOffset,
+		HeaderSize:       offset - fd.frameOffset,
+		Duration:         duration,
+	})
+}
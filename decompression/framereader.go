@@ -2,29 +2,27 @@ package decompression
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 )
 
-//FrameReader wraps a FrameDecompressor and probides the io.Reader interface
+//FrameReader wraps a FrameDecompressor and probides the io.Reader interface. If the source contains multiple frames
+//they are read one after another, skippable frames are skipped
 type FrameReader struct {
-	fd          *FrameDecompressor
-	buffer      bytes.Buffer
-	PrintStatus bool
-	readTotal   int64
+	fd *FrameDecompressor
+
+	//the window only writes to buffer if it has to make room in the middle of a block, so it holds at most one block
+	buffer  bytes.Buffer
+	pending []byte //the part of the chunk from Next that Read has not returned yet
 }
 
 //NewFrameReader creates the necessary buffers and the FrameDecompressor
 func NewFrameReader(source io.Reader) (*FrameReader, error) {
 	fr := &FrameReader{}
 	fr.fd = NewFrameDecompressor(source, &fr.buffer)
-	//fr.fd.Verbose = true
 
 	if source != nil {
-		err := fr.fd.CheckMagicnum()
-		if err != nil {
-			return nil, err
-		}
-		err = fr.fd.DecodeFrameHeader()
+		err := fr.startFrame()
 		if err != nil {
 			return nil, err
 		}
@@ -34,76 +32,132 @@ func NewFrameReader(source io.Reader) (*FrameReader, error) {
 
 func (fr *FrameReader) Reset(source io.Reader) error {
 	fr.buffer.Reset()
+	fr.pending = nil
 	fr.fd.Reset(source, &fr.buffer)
 	if source != nil {
-		err := fr.fd.CheckMagicnum()
-		if err != nil {
-			return err
-		}
-		err = fr.fd.DecodeFrameHeader()
-		if err != nil {
-			return err
-		}
+		return fr.startFrame()
 	}
 	return nil
 }
 
+//SetMaxWindowSize sets the biggest window the underlying FrameDecompressor decodes. A frame header that was already decoded
+//by NewFrameReader was checked against DefaultMaxWindowSize
+func (fr *FrameReader) SetMaxWindowSize(n uint64) {
+	fr.fd.SetMaxWindowSize(n)
+}
+
+//SetMetrics sets the Metrics that are updated by the underlying FrameDecompressor
+func (fr *FrameReader) SetMetrics(m *Metrics) {
+	fr.fd.SetMetrics(m)
+}
+
+//SetProgress sets the callback for progress reports of the underlying FrameDecompressor
+func (fr *FrameReader) SetProgress(config ProgressConfig) {
+	fr.fd.SetProgress(config)
+}
+
+//SetObserver sets the Observer of the underlying FrameDecompressor. With an Observer a FrameDecompressor does not use its pipeline. The events of a frame header that was already
+//decoded by NewFrameReader are not repeated. To get all events create the FrameReader with a nil source and call Reset
+func (fr *FrameReader) SetObserver(o Observer) {
+	fr.fd.Observer = o
+}
+
 func (fr *FrameReader) Read(target []byte) (int, error) {
-	read, err := fr.readFromBuffer(target)
-	newTotal := fr.readTotal + int64(read)
-	if fr.PrintStatus {
-		if fr.fd.frame.Header.FrameContentSize > 0 {
-			oldprcnt := uint64(fr.readTotal*100) / fr.fd.frame.Header.FrameContentSize
-			newprcnt := uint64(newTotal*100) / fr.fd.frame.Header.FrameContentSize
-			if oldprcnt != newprcnt {
-				print("Read bytes: ")
-				print(newTotal)
-				print(": ")
-				print(newprcnt)
-				println("%")
-			}
-		} else {
-			print("Read bytes: ")
-			println(fr.readTotal)
+	if len(fr.pending) == 0 {
+		chunk, err := fr.Next()
+		if err != nil {
+			return 0, err
 		}
+		fr.pending = chunk
 	}
-	fr.readTotal += int64(read)
-	return read, err
+	n := copy(target, fr.pending)
+	fr.pending = fr.pending[n:]
+	return n, nil
 }
 
-func (fr *FrameReader) readFromBuffer(target []byte) (int, error) {
-	if fr.fd.CurrentBlock.Header.LastBlock {
-		fr.fd.decodebuffer.Flush()
+//Next returns the next chunk of decoded data without copying it. The slice points into the window of the decoder and is only
+//valid until the next call to Next, Read or Reset. A chunk is at most the output of one block. Returns io.EOF if there are
+//no more frames
+func (fr *FrameReader) Next() ([]byte, error) {
+	if len(fr.pending) > 0 {
+		chunk := fr.pending
+		fr.pending = nil
+		return chunk, nil
 	}
+	for {
+		//the output the window had to write while decoding the last block comes before what is still in the window
+		if fr.buffer.Len() > 0 {
+			return fr.buffer.Next(fr.buffer.Len()), nil
+		}
+		if fr.fd.decodebuffer != nil {
+			chunk := fr.fd.decodebuffer.Drain()
+			if len(chunk) > 0 {
+				return chunk, nil
+			}
+		}
 
-	if fr.buffer.Len() >= len(target) {
-		copy(target, fr.buffer.Next(len(target)))
-		return len(target), nil
+		if fr.fd.CurrentBlock.Header.LastBlock {
+			err := fr.nextFrame()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := fr.fd.DecodeNextBlock()
+		if err != nil {
+			return nil, err
+		}
+		fr.fd.BlockCounter++
 	}
+}
 
-	if fr.fd.CurrentBlock.Header.LastBlock {
-		fr.fd.decodebuffer.Flush()
-
-		if fr.buffer.Len() > 0 {
-			bs := fr.buffer.Bytes()
-			fr.buffer.Reset()
-			copy(target, bs)
-			return len(bs), nil
-		}
-		return 0, io.EOF
+//nextFrame starts decoding the next frame in the source. Returns io.EOF if there are no more frames
+func (fr *FrameReader) nextFrame() error {
+	err := fr.skipSkippableFrames()
+	if err != nil {
+		return err
+	}
+	_, err = fr.fd.source.Peek(1)
+	if err != nil {
+		return err
 	}
 
-	oldSize := fr.buffer.Len()
+	fr.fd.resetFrame()
+	return fr.startFrame()
+}
 
-	//decode until the first time the amount of output changes
-	for fr.buffer.Len() == oldSize && !fr.fd.CurrentBlock.Header.LastBlock {
-		err := fr.fd.DecodeNextBlock()
+//startFrame skips the skippable frames and decodes the header of the frame after them
+func (fr *FrameReader) startFrame() error {
+	err := fr.skipSkippableFrames()
+	if err != nil {
+		return err
+	}
+	err = fr.fd.CheckMagicnum()
+	if err != nil {
+		return err
+	}
+	return fr.fd.DecodeFrameHeader()
+}
+
+//skipSkippableFrames discards the skippable frames at the current position of the source, like the ParallelDecoder does.
+//Anything that is not a skippable frame is left for CheckMagicnum
+func (fr *FrameReader) skipSkippableFrames() error {
+	for {
+		header, err := fr.fd.source.Peek(8)
+		if len(header) < 4 || binary.LittleEndian.Uint32(header)&skippableMagicnumMask != skippableMagicnum {
+			return nil
+		}
 		if err != nil {
-			return 0, err
+			return io.ErrUnexpectedEOF
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		_, err = fr.fd.source.Discard(8)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(io.Discard, fr.fd.source, size)
+		if err != nil {
+			return io.ErrUnexpectedEOF
 		}
-		fr.fd.BlockCounter++
 	}
-	buf := fr.buffer.Next(len(target))
-	copy(target, buf)
-	return len(buf), nil
 }
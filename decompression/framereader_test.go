@@ -0,0 +1,123 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFrameReaderNext(t *testing.T) {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	//all files and some legacy frames one after another
+	var compressed, original []byte
+	for i, file := range files {
+		c, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o, err := ioutil.ReadFile(file[:len(file)-4])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		compressed = append(compressed, c...)
+		original = append(original, o...)
+		if i%10 == 3 {
+			tf := legacyTestFrames[i%len(legacyTestFrames)]
+			frame, _ := hex.DecodeString(tf.frame)
+			compressed = append(compressed, frame...)
+			original = append(original, tf.content...)
+		}
+	}
+
+	for _, mixed := range []bool{false, true} {
+		fr, err := NewFrameReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		result := &bytes.Buffer{}
+		small := make([]byte, 1000)
+		for i := 0; ; i++ {
+			if mixed && i%2 == 0 {
+				n, err := fr.Read(small)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				result.Write(small[:n])
+				continue
+			}
+
+			chunk, err := fr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(chunk) == 0 || len(chunk) > MaxBlockSize {
+				t.Fatalf("Got a chunk of %d bytes", len(chunk))
+			}
+			if fr.buffer.Len() > MaxBlockSize {
+				t.Fatalf("%d bytes are buffered", fr.buffer.Len())
+			}
+			result.Write(chunk)
+		}
+
+		if !bytes.Equal(result.Bytes(), original) {
+			t.Errorf("Mixed with Read %v: Wrong output", mixed)
+		}
+	}
+}
+
+func TestFrameReaderSkippable(t *testing.T) {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(files) < 2 {
+		t.Skip("Need two files of the decodecorpus")
+	}
+
+	//skippable frames before, between and after two frames
+	compressed := skippableFrame(0x184D2A5F, []byte("in front"))
+	var original []byte
+	for _, file := range files[:2] {
+		c, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o, err := ioutil.ReadFile(file[:len(file)-4])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		compressed = append(compressed, c...)
+		compressed = append(compressed, skippableFrame(0x184D2A50, []byte("between"))...)
+		original = append(original, o...)
+	}
+
+	fr, err := NewFrameReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := ioutil.ReadAll(fr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(result, original) {
+		t.Error("Wrong output")
+	}
+
+	_, err = NewFrameReader(bytes.NewReader(compressed[:5]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("A cut skippable frame returned %v", err)
+	}
+}
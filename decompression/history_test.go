@@ -0,0 +1,127 @@
+package decompression_test
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/decompression"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//TestFileBackedHistory decodes into a file with a history cache that is a lot smaller than most windows, so the matches
+//read back from the file. testdata/longwindow.zst has a window of 2GB (zstd --long=31), which is too big for 32-bit platforms
+func TestFileBackedHistory(t *testing.T) {
+	var corpus []corpusFile
+	dirs := map[string]string{}
+	for _, dir := range []string{"../decodecorpus_files", "../benchmark_files", "testdata"} {
+		for _, file := range loadCorpus(t, filepath.Join(dir, "*.zst")) {
+			corpus = append(corpus, file)
+			dirs[file.name] = dir
+		}
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer out.Close()
+
+	dec := decompression.NewFrameDecompressor(nil, nil)
+	dec.SetFileBackedHistory(4 * 1024)
+	for _, file := range corpus {
+		original, err := ioutil.ReadFile(strings.TrimSuffix(filepath.Join(dirs[file.name], file.name), ".zst"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = out.Truncate(0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		dec.Reset(bytes.NewReader(file.compressed), out)
+		err = dec.Decompress()
+		if file.name == "longwindow.zst" && strconv.IntSize == 32 {
+			if err != decompression.ErrWindowTooBig {
+				t.Errorf("%s: Should not be decoded on 32-bit platforms, got: %v", file.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", file.name, err.Error())
+			continue
+		}
+		result, err := ioutil.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(result, original) {
+			t.Errorf("%s: Wrong output", file.name)
+		}
+		//the block buffers stay the same, but the window buffer must not grow with the window
+		if mem := dec.BufferMemory(); mem > 3*decompression.MaxBlockSize+64*1024 {
+			t.Errorf("%s: Holds %d bytes in its buffers", file.name, mem)
+		}
+	}
+}
+
+//countingFile counts how often the history is read back
+type countingFile struct {
+	*os.File
+	reads int
+}
+
+func (cf *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	cf.reads++
+	return cf.File.ReadAt(p, off)
+}
+
+//TestFileBackedHistoryFrames decodes several frames of one source into the same file. z000002 has a window of 3kb, which fits
+//into the cache, z000004 one of 640kb
+func TestFileBackedHistoryFrames(t *testing.T) {
+	var compressed, original []byte
+	for _, name := range []string{"z000004", "z000002", "z000004"} {
+		c, err := ioutil.ReadFile("../decodecorpus_files/" + name + ".zst")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o, err := ioutil.ReadFile("../decodecorpus_files/" + name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		compressed = append(compressed, c...)
+		original = append(original, o...)
+	}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer file.Close()
+	out := &countingFile{File: file}
+
+	dec := decompression.NewFrameDecompressor(bytes.NewReader(compressed), out)
+	dec.SetFileBackedHistory(4 * 1024)
+	for i := 0; i < 3; i++ {
+		reads := out.reads
+		err = dec.Decompress()
+		if err != nil {
+			t.Fatalf("Frame %d: %s", i, err.Error())
+		}
+		if i == 1 && out.reads != reads {
+			t.Errorf("The history of a window that fits into the cache was read back from the file")
+		}
+	}
+	result, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(result, original) {
+		t.Errorf("Wrong output. Got %d bytes, should be %d bytes", len(result), len(original))
+	}
+	if out.reads == 0 {
+		t.Errorf("The history of the big windows was not read back from the file")
+	}
+}
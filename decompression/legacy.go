@@ -0,0 +1,178 @@
+package decompression
+
+import (
+	"errors"
+	"github.com/killingspark/sparkzstd/legacy"
+	"github.com/killingspark/sparkzstd/structure"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+//decodeLegacyFrameHeader is used instead of DecodeFrameHeader if CheckMagicnum found a legacy magic number
+func (fd *FrameDecompressor) decodeLegacyFrameHeader() error {
+	err := fd.legacyFrameHeader.DecodeFrameHeader(fd.legacyVersion, fd.source)
+	if err != nil {
+		return err
+	}
+
+	if fd.legacyBlocks == nil {
+		fd.legacyBlocks = legacy.NewBlockDecoder(fd.legacyVersion)
+	} else {
+		fd.legacyBlocks.Reset(fd.legacyVersion)
+	}
+
+	//keep the frame header up to date so the status output works the same for all frames
+	fd.frame.Header.WindowSize = fd.legacyFrameHeader.WindowSize
+	fd.frame.Header.FrameContentSize = fd.legacyFrameHeader.FrameContentSize
+
+	windowSize := fd.legacyFrameHeader.WindowSize
+	if windowSize < legacy.MinWindowSize {
+		windowSize = legacy.MinWindowSize
+	}
+
+	err = fd.resetWindow(windowSize, false)
+	if err != nil {
+		return err
+	}
+	//legacy blocks can always be 128kb, no matter how small the window is
+	fd.allocateBlockBuffers(legacy.MaxBlockSize)
+	return nil
+}
+
+//decodeNextLegacyBlock is used instead of DecodeNextBlock for legacy frames. The end of the frame is marked by
+//a separate end block. After it has been read CurrentBlock.Header.LastBlock is set
+func (fd *FrameDecompressor) decodeNextLegacyBlock() error {
+	if fd.CurrentBlock.Header.LastBlock {
+		return ErrOutOfBlocks
+	}
+
+	var start time.Time
+	headerOffset := int64(0)
+	if fd.Observer != nil {
+		start = time.Now()
+		headerOffset = fd.compressedOffset()
+	}
+
+	buf := fd.headerbuffer[:3]
+	_, err := io.ReadFull(fd.source, buf)
+	if err != nil {
+		return err
+	}
+
+	header := legacy.BlockHeader{}
+	err = header.DecodeHeader(fd.legacyVersion, buf)
+	if err != nil {
+		return err
+	}
+
+	if fd.metrics != nil && header.Type != legacy.BlockTypeEnd {
+		fd.metrics.countBlock(legacyToBlockHeader(header).Type)
+	}
+
+	//the end block has no content and is not reported as a block
+	if fd.Observer != nil && header.Type != legacy.BlockTypeEnd {
+		fd.Observer.BlockHeaderDecoded(BlockHeaderEvent{
+			BlockIndex:         fd.BlockCounter,
+			Header:             legacyToBlockHeader(header),
+			CompressedOffset:   headerOffset,
+			DecompressedOffset: fd.decompressedOffset(),
+			Duration:           time.Since(start),
+		})
+	}
+
+	switch header.Type {
+	case legacy.BlockTypeEnd:
+		fd.CurrentBlock.Header.LastBlock = true
+
+	case legacy.BlockTypeRaw:
+		_, err := io.CopyN(fd.decodebuffer, fd.source, int64(header.BlockSize))
+		if err != nil {
+			return err
+		}
+
+	case legacy.BlockTypeRLE:
+		b, err := fd.source.ReadByte()
+		if err != nil {
+			return err
+		}
+		err = fd.decodebuffer.PushRLE(b, header.BlockSize)
+		if err != nil {
+			return err
+		}
+
+	case legacy.BlockTypeCompressed:
+		//the sequences buffer is not used otherwise for legacy frames
+		data := fd.sequencesDataBuf[:header.BlockSize]
+		_, err := io.ReadFull(fd.source, data)
+		if err != nil {
+			return err
+		}
+
+		err = fd.legacyBlocks.DecodeBlock(data)
+		if err != nil {
+			return err
+		}
+
+		var executionStart time.Time
+		if fd.metrics != nil {
+			atomic.AddInt64(&fd.metrics.Sequences, int64(len(fd.legacyBlocks.Sequences)))
+			executionStart = time.Now()
+		}
+		err = fd.executeLegacySequences()
+		if err != nil {
+			return err
+		}
+		if fd.metrics != nil {
+			addDuration(&fd.metrics.ExecutionTime, executionStart)
+		}
+	}
+
+	return fd.blockDone(start)
+}
+
+//legacyToBlockHeader translates the header for the Observer. The block types have the same meaning in both formats
+func legacyToBlockHeader(header legacy.BlockHeader) structure.BlockHeader {
+	bh := structure.BlockHeader{BlockSize: uint64(header.BlockSize)}
+	switch header.Type {
+	case legacy.BlockTypeRaw:
+		bh.Type = structure.BlockTypeRaw
+	case legacy.BlockTypeRLE:
+		bh.Type = structure.BlockTypeRLE
+	case legacy.BlockTypeCompressed:
+		bh.Type = structure.BlockTypeCompressed
+	}
+	return bh
+}
+
+var ErrOffsetOutOfWindow = errors.New("Offset points before the decoded data or outside of the window")
+
+//executeLegacySequences works like ExecuteSequences, but the offsets have already been resolved by the legacy decoder
+func (fd *FrameDecompressor) executeLegacySequences() error {
+	literals := fd.legacyBlocks.Literals
+
+	for _, seq := range fd.legacyBlocks.Sequences {
+		//literals copy
+		if seq.LiteralLength > len(literals) {
+			return ErrDidntCopyAllLiteralBytes
+		}
+		err := fd.decodebuffer.Push(literals[:seq.LiteralLength])
+		if err != nil {
+			return err
+		}
+		literals = literals[seq.LiteralLength:]
+
+		//offset & match
+		if seq.MatchLength > 0 {
+			if seq.Offset <= 0 || int64(seq.Offset) > fd.decodebuffer.VirtualIndex+1 || seq.Offset > fd.decodebuffer.Len {
+				return ErrOffsetOutOfWindow
+			}
+			err := fd.decodebuffer.Repeat(seq.MatchLength, seq.Offset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return fd.decodebuffer.Push(literals)
+}
@@ -0,0 +1,136 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+//frames in the legacy formats. The zstd cli (which still supports these versions) decodes them to content
+var legacyTestFrames = []struct {
+	name    string
+	frame   string
+	content string
+}{
+	{
+		name:    "v0.5 raw",
+		frame:   "25b52ffd06" + "400005" + hex.EncodeToString([]byte("hello")) + "c00000",
+		content: "hello",
+	},
+	{
+		name:    "v0.6 raw",
+		frame:   "26b52ffd05" + "400005" + hex.EncodeToString([]byte("hello")) + "c00000",
+		content: "hello",
+	},
+	{
+		name:    "v0.7 raw",
+		frame:   "27b52ffd2005" + "400005" + hex.EncodeToString([]byte("hello")) + "c00000",
+		content: "hello",
+	},
+	{
+		name: "v0.5",
+		frame: "25b52ffd0600013704f10224816d8276807447b49d27eac587a7d07a82bfbebebebebeedc54667edeceefafafafa380e" +
+		"3100310030008e3abcdd42ee7d7ae2b5ae493863c6d7b18ece5c3f39b2c75478a5d3be56949fbcf53b5f117a6423f6ac" +
+		"9d6bd4a7c755019ba3dc319a7cd46d117b3b0dd617c377a563364bddb5a473a89fcd1855f50dec773863c52cebb5cdc9" +
+		"bb53befa1575a5d7af1575dfdd677c8d2c9c2c0f67cb57a66deb330dc067c74af97a53396b87a72b9d5fbc9a56af7c2d" +
+		"8e6c3f35be630c056ff2b5955a554f4a4e528c20c4ef71dc8b4b0bcb8a4a0aca894909c9884808c8874707c7864606c6" +
+		"854505c5844404c4834303c3824202c28141418660402000e08d63ea020e00033d5c190000740f0022060ca9330024fe" +
+		"c03000c0b95bff390810440780d30d8e9302842300400b204e02d0a01d70fe00001a8720616e6761696e030c001068f1" +
+		"cfca3e00c0016588845a5486c00000",
+		content: "pack my box with five dozen liquor jugs, then see how vexingly quick daft zebras jump over" +
+		" a lazy sleeping dog. sphinx of black quartz, judge my vow. the five boxing wizards jump q" +
+		"uickly, amazingly few discotheques provide jukeboxes. version 0.5 stores long lengths in a" +
+		" dumps area. 0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuversion" +
+		" 0.5 stores long lengths in a dumps area. abcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcd" +
+		"abcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdabcdab" +
+		"cdabcdabcdabcdabcdabcd the end of version 0.5 and the end of version 0.5 again, the end of" +
+		" version 0.5",
+	},
+	{
+		name: "v0.6",
+		frame: "26b52ffd85f2000000e52028c097d9000000000000000000000000000000006000000000003030100001100000000005" +
+		"3355434413534533454444331900190019000fba600357cc12b44ffa38af0d999f85dc151ebe1c37084402e8207d0139" +
+		"1ad5a0efcb6022967cd9222145db1d9fabf65901c984e673f533142e9b0a9fe0b86991c15b0a59b2c30d5f4901bbe834" +
+		"0901092f20003c316aa5cd0872997bb1f54065de1a1c000040c190f12a530401255cd853064a5f3766018c003102177d" +
+		"005b8abba38a2c141730bd1e5ce8210e070e447800a600206000c0802b82cc211841c12843b0a202de1000b602661900" +
+		"007f10a846d9000000000000000000000000000000003000000000001010000000010000000003101311123022232034" +
+		"3302027bdc8301c2b933be58357c0786e4571d089741b4d8d3111912c0c1a11510d203006037847d1280faa56701cb03" +
+		"04868032fa7a68b14e0bf608525a001a0bb8ece0374282b54f1bd869c093019304400003726177000003c77100c00000",
+		content: "PACK MY BOX WITH FIVE DOZEN LIQUOR JUGS, THEN SEE HOW VEXINGLY QUICK DAFT ZEBRAS JUMP. SPH" +
+		"INX OF BLACK QUARTZ, JUDGE MY VOW. THE FIVE BOXING WIZARDS JUMP QUICKLY. SPARKZSTD DECODES" +
+		" ZSTD FRAMES. SPARKZSTD ALSO DECODES OLD ZSTD FRAMES, LIKE FRAMES OF VERSION 0.6 AND FRAME" +
+		"S OF VERSION 0.5. OLD FRAMES, NEW FRAMES, ALL FRAMES.JACKDAWS LOVE MY BIG SPHINX OF QUARTZ" +
+		", QUICK ZEPHYRS BLOW AND VEX DAFT JIM. FRAMES OF VERSION 0.6 USE OTHER OFFSETS THAN FRAMES" +
+		" OF VERSION 0.7, BUT FRAMES ARE FRAMESrawqqqqqqq",
+	},
+	{
+		name: "v0.7",
+		frame: "27b52ffd00380000f402f08e19318bd06d5337a56a49d54271fbd1562912ab7ae746aeb81c071b001b001c0057edc31e" +
+		"b469015fdc8c454f1a995cdd961fb6ef070f87c58940aa4a15f5614cf4fc52c75745e71a349e9524db8c4364d21eeafe" +
+		"f8047f5a6216f90022b232ee131e3b3524886c6533d0f0c23fd21ed5174c17d2a621292014ed796f802e4f8fd7d0aa84" +
+		"9275bcd45b9bc1815e12283020444008d47c0000ba8c3965fc031b1da15c506d016f40bf13b001d9189c0c7001b0230e" +
+		"8809d502b31d882cc4cc4d04a12e3099035c077c00600a20f1c05118beb9013ccb6b81060130002608a36068c0f00785" +
+		"556001b811300d15b82ba0e80100002c100c0705f11bea630a340ea80018cd604200be3ab85b30b31c184c46682f8c1e" +
+		"f80f2807c046c0b0805b082040000b3c72617720626c6f636b3e00000a83616263015403020606c00000",
+		content: "pack my box with five dozen liquor jugs, then see how vexingly quick daft zebras jump over" +
+		" a lazy sleeping dog. sphinx of black quartz, judge my vow. the five boxing wizards jump q" +
+		"uickly, amazingly few discotheques provide jukeboxes. the quick brown fox jumps over the l" +
+		"azy dog. the quick brown fox jumps over the lazy dog! a lazy dog sleeps while the quick fo" +
+		"x keeps jumping over the brown dog, over and over again. the lazy brown fox, over and over" +
+		" the dog jumps quick. quick, quick! the dog and the fox again. <raw block>abcabcabcabc",
+	},
+}
+
+func TestLegacyFrames(t *testing.T) {
+	for _, tf := range legacyTestFrames {
+		frame, err := hex.DecodeString(tf.frame)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		result := &bytes.Buffer{}
+		fd := NewFrameDecompressor(bytes.NewReader(frame), result)
+		err = fd.Decompress()
+		if err != nil {
+			t.Errorf("%s: %s", tf.name, err.Error())
+			continue
+		}
+		if result.String() != tf.content {
+			t.Errorf("%s: Wrong content: %s, should be: %s", tf.name, result.String(), tf.content)
+		}
+	}
+}
+
+func TestLegacyFrameReader(t *testing.T) {
+	for _, tf := range legacyTestFrames {
+		frame, err := hex.DecodeString(tf.frame)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		fr, err := NewFrameReader(bytes.NewReader(frame))
+		if err != nil {
+			t.Errorf("%s: %s", tf.name, err.Error())
+			continue
+		}
+		result, err := ioutil.ReadAll(fr)
+		if err != nil {
+			t.Errorf("%s: %s", tf.name, err.Error())
+			continue
+		}
+		if string(result) != tf.content {
+			t.Errorf("%s: Wrong content: %s, should be: %s", tf.name, string(result), tf.content)
+		}
+	}
+}
+
+func TestLegacyCorruptedFrame(t *testing.T) {
+	//the v0.7 raw frame with a block size that is bigger than the rest of the frame
+	frame, _ := hex.DecodeString("27b52ffd2005" + "400009" + hex.EncodeToString([]byte("hello")) + "c00000")
+	fd := NewFrameDecompressor(bytes.NewReader(frame), &bytes.Buffer{})
+	err := fd.Decompress()
+	if err == nil {
+		t.Error("Decoded a corrupted legacy frame without an error")
+	}
+}
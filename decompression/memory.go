@@ -0,0 +1,103 @@
+package decompression
+
+import (
+	"github.com/killingspark/sparkzstd/structure"
+	"math"
+)
+
+//MemoryProfile controls how the buffers of a FrameDecompressor are sized
+type MemoryProfile int
+
+const (
+	//MemoryProfileDefault allocates the block buffers with the maximum block size of 128kb once and keeps them for all frames
+	MemoryProfileDefault MemoryProfile = iota
+
+	//MemoryProfileSmall sizes the block buffers to min(window size, 128kb) for every frame. Frames with small windows need a lot less
+	//memory this way, at the cost of reallocating when the window size changes between frames
+	MemoryProfileSmall
+)
+
+//MaxBlockSize is the maximum size of a block in the current format. Blocks are never bigger than the window of their frame either
+const MaxBlockSize = structure.MaxBlockSize
+
+//decodingTablesMemory is a rough upper bound for the huffman and fse decoding tables. They are built into the same buffers
+//for all blocks. A huffman table has up to 4096 entries of 2 bytes, the four fse tables have up to 1344 entries of 16 bytes
+//and 256 values of 8 bytes each
+const decodingTablesMemory = 4096*2 + 1344*16 + 4*256*8
+
+//sourceBufferSize is the size of the bufio.Readers around the source and around the current block
+const sourceBufferSize = 4096
+
+//EstimateMemory returns how many bytes a FrameDecompressor with the given profile needs to decode a frame with this header,
+//similar to ZSTD_estimateDCtxSize. Small allocations that do not depend on the frame are not counted.
+//Legacy frames always use block buffers of 128kb. The window is counted as if it is held in memory, with SetFileBackedHistory
+//the FrameDecompressor needs less.
+//Frames whose window is bigger than DefaultMaxWindowSize are rejected by a FrameDecompressor with the default limit and give
+//math.MaxInt
+func EstimateMemory(header structure.FrameHeader, profile MemoryProfile) int {
+	if header.WindowSize > DefaultMaxWindowSize {
+		return math.MaxInt
+	}
+	window := int(header.WindowSize)
+	return windowBufferSize(window, header.Descriptor.GetSingleSegmentFlag()) + 3*blockBufferSize(window, profile) + decodingTablesMemory + 2*sourceBufferSize
+}
+
+//blockBufferSize is the size of each of the three block buffers for frames with this window size
+func blockBufferSize(window int, profile MemoryProfile) int {
+	if profile == MemoryProfileSmall && window < MaxBlockSize {
+		return window
+	}
+	return MaxBlockSize
+}
+
+//SetMemoryProfile sets how the buffers are sized. It takes effect with the next frame header
+func (fd *FrameDecompressor) SetMemoryProfile(p MemoryProfile) {
+	fd.memoryProfile = p
+}
+
+//BufferMemory returns the number of bytes currently held in the buffers of the FrameDecompressor. The decoding tables
+//are not counted, so after decoding a frame this is EstimateMemory without the estimate for the tables.
+//In the pipelined mode the block buffers exist pipelineDepth times
+func (fd *FrameDecompressor) BufferMemory() int {
+	n := cap(fd.literalsDataBuf) + cap(fd.literalsCompressedDataBuf) + cap(fd.sequencesDataBuf)
+	if fd.decodebuffer != nil {
+		n += cap(fd.decodebuffer.data)
+	}
+	if len(fd.pipelineBlocks) > 1 {
+		//the first set shares the buffers above
+		for _, pb := range fd.pipelineBlocks[1:] {
+			n += cap(pb.literalsDataBuf) + cap(pb.literalsCompressedDataBuf) + cap(pb.sequencesDataBuf)
+		}
+	}
+	if fd.legacyBlocks != nil {
+		n += fd.legacyBlocks.BufferMemory()
+	}
+	if fd.source != nil {
+		n += fd.source.Size() + fd.blockSource.Size()
+	}
+	return n
+}
+
+//allocateBlockBuffers makes sure the block buffers have exactly the given size. Buffers that are big enough are kept in the
+//default profile, so switching between frames never allocates there
+func (fd *FrameDecompressor) allocateBlockBuffers(size int) {
+	fd.maxBlockSize = size
+	if fd.tables == nil {
+		fd.tables = structure.NewTableBuffers()
+	}
+	if cap(fd.sequencesDataBuf) == size || (fd.memoryProfile == MemoryProfileDefault && cap(fd.sequencesDataBuf) >= size) {
+		fd.literalsDataBuf = fd.literalsDataBuf[:size]
+		fd.literalsCompressedDataBuf = fd.literalsCompressedDataBuf[:size]
+		fd.sequencesDataBuf = fd.sequencesDataBuf[:size]
+		return
+	}
+	fd.literalsDataBuf = make([]byte, size)
+	fd.literalsCompressedDataBuf = make([]byte, size)
+	fd.sequencesDataBuf = make([]byte, size)
+}
+
+//SetMemoryProfile sets the MemoryProfile of the underlying FrameDecompressor. A frame header that was already decoded
+//by NewFrameReader used the default profile
+func (fr *FrameReader) SetMemoryProfile(p MemoryProfile) {
+	fr.fd.SetMemoryProfile(p)
+}
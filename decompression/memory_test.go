@@ -0,0 +1,146 @@
+package decompression
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/structure"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMemoryEstimate(t *testing.T) {
+	//z000002 has a window of 3kb, z000004 one of 640kb
+	for _, name := range []string{"z000002", "z000004"} {
+		compressed, err := ioutil.ReadFile("../decodecorpus_files/" + name + ".zst")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		original, err := ioutil.ReadFile("../decodecorpus_files/" + name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for _, profile := range []MemoryProfile{MemoryProfileDefault, MemoryProfileSmall} {
+			result := &bytes.Buffer{}
+			fd := NewFrameDecompressor(bytes.NewReader(compressed), result)
+			fd.SetMemoryProfile(profile)
+			err = fd.Decompress()
+			if err != nil {
+				t.Fatalf("%s, profile %d: %s", name, profile, err.Error())
+			}
+			if !bytes.Equal(result.Bytes(), original) {
+				t.Fatalf("%s, profile %d: Wrong content", name, profile)
+			}
+
+			estimate := EstimateMemory(fd.frame.Header, profile)
+			if used := fd.BufferMemory(); used != estimate-decodingTablesMemory {
+				t.Errorf("%s, profile %d: Buffers use %d bytes, estimate without tables is %d", name, profile, used, estimate-decodingTablesMemory)
+			}
+		}
+	}
+
+	header := structure.FrameHeader{WindowSize: 3072}
+	small := EstimateMemory(header, MemoryProfileSmall)
+	if def := EstimateMemory(header, MemoryProfileDefault); small >= def/3 {
+		t.Errorf("Small profile should need a lot less memory for small windows: %d vs %d", small, def)
+	}
+}
+
+func TestMaxWindowSize(t *testing.T) {
+	//z000004 has a window of 640kb
+	compressed, err := ioutil.ReadFile("../decodecorpus_files/z000004.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fd := NewFrameDecompressor(bytes.NewReader(compressed), &bytes.Buffer{})
+	fd.SetMaxWindowSize(512 * 1024)
+	if err := fd.Decompress(); err != ErrWindowOverLimit {
+		t.Errorf("Decoded a window over the limit: %v", err)
+	}
+	if fd.decodebuffer != nil {
+		t.Errorf("Allocated the window before checking the limit")
+	}
+
+	fd.SetMaxWindowSize(0)
+	fd.Reset(bytes.NewReader(compressed), &bytes.Buffer{})
+	if err := fd.Decompress(); err != nil {
+		t.Errorf("Could not decode with the default limit: %s", err.Error())
+	}
+}
+
+func TestResetWindowTooBig(t *testing.T) {
+	fd := NewFrameDecompressor(nil, &bytes.Buffer{})
+	fd.SetMaxWindowSize(math.MaxUint64)
+	for _, singleSegment := range []bool{false, true} {
+		if err := fd.resetWindow(uint64(math.MaxInt)+1, singleSegment); err != ErrWindowTooBig {
+			t.Errorf("Single segment %v: Reset a window bigger than the biggest int: %v", singleSegment, err)
+		}
+	}
+	//the buffer holds two windows
+	if err := fd.resetWindow(uint64(math.MaxInt/2)+1, false); err != ErrWindowTooBig {
+		t.Errorf("Reset a window whose buffer is bigger than the biggest int: %v", err)
+	}
+	if fd.decodebuffer != nil {
+		t.Errorf("Allocated a window that is too big")
+	}
+
+	header := structure.FrameHeader{WindowSize: uint64(math.MaxInt) + 1}
+	if EstimateMemory(header, MemoryProfileDefault) != math.MaxInt {
+		t.Errorf("Estimate for a window bigger than the biggest int should be the biggest int")
+	}
+}
+
+func TestWindowTooBig(t *testing.T) {
+	//single segment frame with a content size of 2^64-1, so the window is that big too
+	huge := []byte{0x28, 0xB5, 0x2F, 0xFD, 0xE0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	fd := NewFrameDecompressor(bytes.NewReader(huge), &bytes.Buffer{})
+	if err := fd.Decompress(); err != ErrWindowTooBig {
+		t.Errorf("Decoded a window of 2^64-1 bytes: %v", err)
+	}
+	if EstimateMemory(fd.frame.Header, MemoryProfileDefault) != math.MaxInt {
+		t.Errorf("Estimate for a window of 2^64-1 bytes should be the biggest int")
+	}
+
+	//a single segment frame with a content size of 3.5GB. It is rejected before the buffer is allocated
+	bomb := []byte{0x28, 0xB5, 0x2F, 0xFD, 0xA0, 0x0C, 0x0C, 0x0C, 0xD4, 0x01, 0x00, 0x00}
+	expected := ErrWindowOverLimit
+	if strconv.IntSize == 32 {
+		expected = ErrWindowTooBig
+	}
+	fd.Reset(bytes.NewReader(bomb), &bytes.Buffer{})
+	if err := fd.Decompress(); err != expected {
+		t.Errorf("Decoded a window of 3.5GB: %v", err)
+	}
+	if EstimateMemory(fd.frame.Header, MemoryProfileDefault) != math.MaxInt {
+		t.Errorf("Estimate for a window over the limit should be the biggest int")
+	}
+
+	if strconv.IntSize != 32 {
+		return
+	}
+	//a window of 1GB needs a buffer of 2GB, which does not fit into an int. Only the cache of a file-backed history does
+	gigabyte := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00, 20 << 3}
+	out, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer out.Close()
+	for _, cache := range []int{0, 1024 * 1024} {
+		fd.Reset(bytes.NewReader(gigabyte), out)
+		fd.SetFileBackedHistory(cache)
+		err := fd.CheckMagicnum()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = fd.DecodeFrameHeader()
+		if cache == 0 && err != ErrWindowTooBig {
+			t.Errorf("Decoded a window of 1GB without a file-backed history: %v", err)
+		}
+		if cache != 0 && err != nil {
+			t.Errorf("Could not decode a window of 1GB with a file-backed history: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package decompression
+
+import (
+	"encoding/json"
+	"github.com/killingspark/sparkzstd/structure"
+	"sync/atomic"
+	"time"
+)
+
+//Metrics counts what the decoders it is set on have decoded. It implements expvar.Var so it can be published with expvar.Publish.
+//All fields are updated atomically, so use Snapshot to read them while decoders are running. One Metrics can be shared by many decoders.
+//Literals, FSE table modes and the time spent decoding literals and sequences are only counted for frames in the current format.
+type Metrics struct {
+	BytesIn  int64 //compressed bytes consumed
+	BytesOut int64 //decompressed bytes produced
+	Frames   int64 //finished frames
+
+	RawBlocks        int64
+	RLEBlocks        int64
+	CompressedBlocks int64
+
+	RawLiterals        int64
+	RLELiterals        int64
+	CompressedLiterals int64
+	TreelessLiterals   int64 //compressed with the huffman table of a previous block
+
+	//counted per table, so every sequences section counts three times
+	FSEPredefined int64
+	FSERLE        int64
+	FSERepeat     int64
+	FSECompressed int64
+
+	Sequences int64
+
+	LiteralsTime  time.Duration
+	SequencesTime time.Duration
+	ExecutionTime time.Duration
+}
+
+//Snapshot returns a copy of the current values
+func (m *Metrics) Snapshot() Metrics {
+	return Metrics{
+		BytesIn:            atomic.LoadInt64(&m.BytesIn),
+		BytesOut:           atomic.LoadInt64(&m.BytesOut),
+		Frames:             atomic.LoadInt64(&m.Frames),
+		RawBlocks:          atomic.LoadInt64(&m.RawBlocks),
+		RLEBlocks:          atomic.LoadInt64(&m.RLEBlocks),
+		CompressedBlocks:   atomic.LoadInt64(&m.CompressedBlocks),
+		RawLiterals:        atomic.LoadInt64(&m.RawLiterals),
+		RLELiterals:        atomic.LoadInt64(&m.RLELiterals),
+		CompressedLiterals: atomic.LoadInt64(&m.CompressedLiterals),
+		TreelessLiterals:   atomic.LoadInt64(&m.TreelessLiterals),
+		FSEPredefined:      atomic.LoadInt64(&m.FSEPredefined),
+		FSERLE:             atomic.LoadInt64(&m.FSERLE),
+		FSERepeat:          atomic.LoadInt64(&m.FSERepeat),
+		FSECompressed:      atomic.LoadInt64(&m.FSECompressed),
+		Sequences:          atomic.LoadInt64(&m.Sequences),
+		LiteralsTime:       time.Duration(atomic.LoadInt64((*int64)(&m.LiteralsTime))),
+		SequencesTime:      time.Duration(atomic.LoadInt64((*int64)(&m.SequencesTime))),
+		ExecutionTime:      time.Duration(atomic.LoadInt64((*int64)(&m.ExecutionTime))),
+	}
+}
+
+//String returns the values as JSON, as needed by expvar.Var. Times are in nanoseconds
+func (m *Metrics) String() string {
+	snapshot := m.Snapshot()
+	msh, _ := json.Marshal(&snapshot)
+	return string(msh)
+}
+
+func (m *Metrics) countBlock(t structure.BlockType) {
+	switch t {
+	case structure.BlockTypeRaw:
+		atomic.AddInt64(&m.RawBlocks, 1)
+	case structure.BlockTypeRLE:
+		atomic.AddInt64(&m.RLEBlocks, 1)
+	case structure.BlockTypeCompressed:
+		atomic.AddInt64(&m.CompressedBlocks, 1)
+	}
+}
+
+func (m *Metrics) countLiterals(t structure.LiteralsBlockType) {
+	switch t {
+	case structure.LiteralsBlockTypeRaw:
+		atomic.AddInt64(&m.RawLiterals, 1)
+	case structure.LiteralsBlockTypeRLE:
+		atomic.AddInt64(&m.RLELiterals, 1)
+	case structure.LiteralsBlockTypeCompressed:
+		atomic.AddInt64(&m.CompressedLiterals, 1)
+	case structure.LiteralsBlockTypeTreeless:
+		atomic.AddInt64(&m.TreelessLiterals, 1)
+	}
+}
+
+func (m *Metrics) countSequences(header *structure.SequencesSectionHeader) {
+	atomic.AddInt64(&m.Sequences, int64(header.NumberOfSequences))
+	if header.NumberOfSequences == 0 {
+		//there are no tables without sequences
+		return
+	}
+	for _, mode := range [3]structure.SymbolCompressionMode{header.LiteralsLengthMode, header.OffsetsMode, header.MatchLengthsMode} {
+		switch mode {
+		case structure.SymbolCompressionModePredefined:
+			atomic.AddInt64(&m.FSEPredefined, 1)
+		case structure.SymbolCompressionModeRLE:
+			atomic.AddInt64(&m.FSERLE, 1)
+		case structure.SymbolCompressionModeRepeat:
+			atomic.AddInt64(&m.FSERepeat, 1)
+		case structure.SymbolCompressionModeCompressed:
+			atomic.AddInt64(&m.FSECompressed, 1)
+		}
+	}
+}
+
+func addDuration(d *time.Duration, start time.Time) {
+	atomic.AddInt64((*int64)(d), int64(time.Since(start)))
+}
+
+//SetMetrics sets the Metrics that are updated while decoding. nil disables the counting
+func (fd *FrameDecompressor) SetMetrics(m *Metrics) {
+	fd.metrics = m
+	fd.metricsIn = fd.compressedOffset()
+	fd.metricsOut = fd.decompressedOffset()
+}
+
+//countBytes adds the bytes consumed and produced since the last call. Called after every block
+func (fd *FrameDecompressor) countBytes(lastBlock bool) {
+	in := fd.compressedOffset()
+	out := fd.decompressedOffset()
+	atomic.AddInt64(&fd.metrics.BytesIn, in-fd.metricsIn)
+	atomic.AddInt64(&fd.metrics.BytesOut, out-fd.metricsOut)
+	fd.metricsIn = in
+	fd.metricsOut = out
+	if lastBlock {
+		atomic.AddInt64(&fd.metrics.Frames, 1)
+		//the decompressed offset starts at 0 again in the next frame
+		fd.metricsOut = 0
+	}
+}
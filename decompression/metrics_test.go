@@ -0,0 +1,92 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"github.com/killingspark/sparkzstd/structure"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	compressed, err := ioutil.ReadFile("../decodecorpus_files/z000033.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	original, err := ioutil.ReadFile("../decodecorpus_files/z000033")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tf := legacyTestFrames[len(legacyTestFrames)-1]
+	frame, _ := hex.DecodeString(tf.frame)
+	compressed = append(compressed, frame...)
+	original = append(original, tf.content...)
+
+	m := &Metrics{}
+
+	ro := &recordingObserver{}
+	fr, _ := NewFrameReader(nil)
+	fr.SetMetrics(m)
+	fr.SetObserver(ro)
+	err = fr.Reset(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := ioutil.ReadAll(fr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(result, original) {
+		t.Fatalf("Wrong content. Got %d bytes, should be %d bytes", len(result), len(original))
+	}
+
+	s := m.Snapshot()
+	if s.BytesIn != int64(len(compressed)) || s.BytesOut != int64(len(original)) || s.Frames != 2 {
+		t.Errorf("Wrong totals: %d in, %d out, %d frames", s.BytesIn, s.BytesOut, s.Frames)
+	}
+
+	blocks := map[structure.BlockType]int64{}
+	for _, b := range ro.blocks {
+		blocks[b.Header.Type]++
+	}
+	if s.RawBlocks != blocks[structure.BlockTypeRaw] || s.RLEBlocks != blocks[structure.BlockTypeRLE] || s.CompressedBlocks != blocks[structure.BlockTypeCompressed] {
+		t.Errorf("Wrong block counts: %d/%d/%d, should be: %v", s.RawBlocks, s.RLEBlocks, s.CompressedBlocks, blocks)
+	}
+
+	literals := s.RawLiterals + s.RLELiterals + s.CompressedLiterals + s.TreelessLiterals
+	if literals != int64(len(ro.literals)) {
+		t.Errorf("Wrong number of literals sections: %d, should be: %d", literals, len(ro.literals))
+	}
+
+	sequences := int64(0)
+	tables := int64(0)
+	for _, e := range ro.sequences {
+		sequences += int64(e.NumberOfSequences)
+		if e.NumberOfSequences > 0 {
+			tables += 3
+		}
+	}
+	if s.FSEPredefined+s.FSERLE+s.FSERepeat+s.FSECompressed != tables {
+		t.Errorf("Wrong number of tables: %d, should be: %d", s.FSEPredefined+s.FSERLE+s.FSERepeat+s.FSECompressed, tables)
+	}
+	//the legacy frame has sequences too, but no events for them
+	if s.Sequences <= sequences {
+		t.Errorf("Wrong number of sequences: %d, should be more than: %d", s.Sequences, sequences)
+	}
+	if s.LiteralsTime <= 0 || s.SequencesTime <= 0 || s.ExecutionTime <= 0 {
+		t.Errorf("Times were not measured: %v", s)
+	}
+
+	//Metrics can be published with expvar. Publishing twice panics, so the test only checks the JSON it would publish
+	var v expvar.Var = m
+	published := Metrics{}
+	err = json.Unmarshal([]byte(v.String()), &published)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if published != s {
+		t.Errorf("Published metrics differ: %v, should be: %v", published, s)
+	}
+}
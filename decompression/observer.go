@@ -0,0 +1,150 @@
+package decompression
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/killingspark/sparkzstd/legacy"
+	"github.com/killingspark/sparkzstd/structure"
+	"io"
+	"time"
+)
+
+//Observer receives events while a FrameDecompressor decodes. The methods are called synchronously from the
+//decoding goroutine, so they should return fast. Literals and sequences events are only sent for frames in the current format.
+//
+//All offsets are counted in bytes. Compressed offsets are relative to the start of the source, decompressed offsets
+//are relative to the start of the current frame.
+type Observer interface {
+	FrameHeaderDecoded(FrameHeaderEvent)
+	BlockHeaderDecoded(BlockHeaderEvent)
+	LiteralsDecoded(LiteralsEvent)
+	SequencesDecoded(SequencesEvent)
+	FrameFinished(FrameFinishedEvent)
+}
+
+//FrameHeaderEvent is sent after the frame header was decoded
+type FrameHeaderEvent struct {
+	Header        structure.FrameHeader
+	LegacyVersion legacy.Version //0 for frames in the current format
+
+	CompressedOffset int64 //start of the frame (the magic number)
+	HeaderSize       int64 //including the magic number
+	Duration         time.Duration
+}
+
+//BlockHeaderEvent is sent after a block header was decoded and before the content of the block is decoded
+type BlockHeaderEvent struct {
+	BlockIndex int
+	Header     structure.BlockHeader
+
+	CompressedOffset   int64 //start of the block header
+	DecompressedOffset int64 //where the output of the block will start
+	Duration           time.Duration
+}
+
+//LiteralsEvent is sent after the literals section of a compressed block was decoded
+type LiteralsEvent struct {
+	BlockIndex      int
+	Type            structure.LiteralsBlockType
+	RegeneratedSize int
+	CompressedSize  int
+	NumberOfStreams int
+	HeaderSize      int
+	TreeSize        int //0 if no huffman tree was part of the section
+
+	CompressedOffset int64 //start of the literals section
+	Duration         time.Duration
+}
+
+//SequencesEvent is sent after the sequences section of a compressed block was decoded
+type SequencesEvent struct {
+	BlockIndex         int
+	NumberOfSequences  int
+	LiteralsLengthMode structure.SymbolCompressionMode
+	OffsetsMode        structure.SymbolCompressionMode
+	MatchLengthsMode   structure.SymbolCompressionMode
+
+	CompressedOffset int64 //start of the sequences section
+	Duration         time.Duration
+}
+
+//FrameFinishedEvent is sent after the last block of a frame was decoded
+type FrameFinishedEvent struct {
+	Blocks           int
+	CompressedOffset int64 //start of the frame
+	CompressedSize   int64
+	DecompressedSize int64
+
+	//time spent decoding this frame. Time spent outside of the FrameDecompressor (eg. between two FrameReader.Read calls) is not counted
+	Duration time.Duration
+}
+
+//NopObserver ignores all events. It can be embedded to implement only some of the methods of Observer
+type NopObserver struct{}
+
+func (NopObserver) FrameHeaderDecoded(FrameHeaderEvent) {}
+func (NopObserver) BlockHeaderDecoded(BlockHeaderEvent) {}
+func (NopObserver) LiteralsDecoded(LiteralsEvent)       {}
+func (NopObserver) SequencesDecoded(SequencesEvent)     {}
+func (NopObserver) FrameFinished(FrameFinishedEvent)    {}
+
+//DebugObserver writes all events as indented JSON to W
+type DebugObserver struct {
+	W io.Writer
+}
+
+func (do *DebugObserver) FrameHeaderDecoded(e FrameHeaderEvent) { do.write("FrameHeader", e) }
+func (do *DebugObserver) BlockHeaderDecoded(e BlockHeaderEvent) { do.write("BlockHeader", e) }
+func (do *DebugObserver) LiteralsDecoded(e LiteralsEvent)       { do.write("Literals", e) }
+func (do *DebugObserver) SequencesDecoded(e SequencesEvent)     { do.write("Sequences", e) }
+func (do *DebugObserver) FrameFinished(e FrameFinishedEvent)    { do.write("FrameFinished", e) }
+
+func (do *DebugObserver) write(name string, event interface{}) {
+	msh, _ := json.MarshalIndent(event, "\t", "   ")
+	fmt.Fprintf(do.W, "%s:\n\t%s\n", name, msh)
+}
+
+//countingReader counts the bytes read from the source of a FrameDecompressor
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+//compressedOffset is the number of bytes that were consumed from the source. Bytes that sit in the bufio.Reader are not counted.
+//While the blocks are decoded in a pipeline it is the offset after the block that was executed last
+func (fd *FrameDecompressor) compressedOffset() int64 {
+	if fd.pipelineRunning {
+		return fd.pipelineOffset
+	}
+	return fd.sourceOffset()
+}
+
+//sourceOffset is the number of bytes that were actually consumed from the source
+func (fd *FrameDecompressor) sourceOffset() int64 {
+	return fd.sourceCounter.n - int64(fd.source.Buffered())
+}
+
+//decompressedOffset is the number of bytes decoded in the current frame
+func (fd *FrameDecompressor) decompressedOffset() int64 {
+	if fd.decodebuffer == nil {
+		return 0
+	}
+	return fd.decodebuffer.VirtualIndex + 1
+}
+
+//finishFrame sends the FrameFinished event. Must be called after the last block has been decoded
+func (fd *FrameDecompressor) finishFrame(blocks int) {
+	fd.Observer.FrameFinished(FrameFinishedEvent{
+		Blocks:           blocks,
+		CompressedOffset: fd.frameOffset,
+		CompressedSize:   fd.compressedOffset() - fd.frameOffset,
+		DecompressedSize: fd.decompressedOffset(),
+		Duration:         fd.frameDuration,
+	})
+}
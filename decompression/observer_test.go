@@ -0,0 +1,107 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/killingspark/sparkzstd/structure"
+	"io/ioutil"
+	"testing"
+)
+
+type recordingObserver struct {
+	headers   []FrameHeaderEvent
+	blocks    []BlockHeaderEvent
+	literals  []LiteralsEvent
+	sequences []SequencesEvent
+	finished  []FrameFinishedEvent
+}
+
+func (ro *recordingObserver) FrameHeaderDecoded(e FrameHeaderEvent) { ro.headers = append(ro.headers, e) }
+func (ro *recordingObserver) BlockHeaderDecoded(e BlockHeaderEvent) { ro.blocks = append(ro.blocks, e) }
+func (ro *recordingObserver) LiteralsDecoded(e LiteralsEvent)       { ro.literals = append(ro.literals, e) }
+func (ro *recordingObserver) SequencesDecoded(e SequencesEvent)     { ro.sequences = append(ro.sequences, e) }
+func (ro *recordingObserver) FrameFinished(e FrameFinishedEvent)    { ro.finished = append(ro.finished, e) }
+
+//checkEvents checks that the events describe the whole frame without gaps
+func checkEvents(t *testing.T, ro *recordingObserver, compressedSize, decompressedSize int64) {
+	if len(ro.headers) != 1 || len(ro.finished) != 1 {
+		t.Fatalf("Wrong number of frame events: %d headers, %d finished", len(ro.headers), len(ro.finished))
+	}
+	finished := ro.finished[0]
+	if finished.CompressedSize != compressedSize || finished.DecompressedSize != decompressedSize {
+		t.Errorf("Wrong sizes: %d/%d, should be: %d/%d", finished.CompressedSize, finished.DecompressedSize, compressedSize, decompressedSize)
+	}
+	if finished.Blocks != len(ro.blocks) {
+		t.Errorf("Wrong number of blocks: %d, should be: %d", finished.Blocks, len(ro.blocks))
+	}
+
+	//every block starts where the previous one ended
+	offset := ro.headers[0].HeaderSize
+	literals := ro.literals
+	for i, block := range ro.blocks {
+		if block.BlockIndex != i || block.CompressedOffset != offset {
+			t.Errorf("Block %d: Wrong index or offset: %d/%d, should be: %d/%d", i, block.BlockIndex, block.CompressedOffset, i, offset)
+		}
+		offset += 3
+		if block.Header.Type == structure.BlockTypeCompressed && len(literals) > 0 {
+			if literals[0].BlockIndex != i || literals[0].CompressedOffset != offset {
+				t.Errorf("Literals %d: Wrong index or offset: %d/%d, should be: %d/%d", i, literals[0].BlockIndex, literals[0].CompressedOffset, i, offset)
+			}
+			literals = literals[1:]
+		}
+		if block.Header.Type == structure.BlockTypeRLE {
+			offset++
+		} else {
+			offset += int64(block.Header.BlockSize)
+		}
+	}
+	if len(ro.literals) != len(ro.sequences) {
+		t.Errorf("Got %d literals events but %d sequences events", len(ro.literals), len(ro.sequences))
+	}
+}
+
+func TestObserver(t *testing.T) {
+	compressed, err := ioutil.ReadFile("../decodecorpus_files/z000033.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	original, err := ioutil.ReadFile("../decodecorpus_files/z000033")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ro := &recordingObserver{}
+	fd := NewFrameDecompressor(bytes.NewReader(compressed), &bytes.Buffer{})
+	fd.Observer = ro
+	err = fd.Decompress()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(ro.literals) == 0 {
+		t.Error("Got no literals events")
+	}
+	checkEvents(t, ro, int64(len(compressed)), int64(len(original)))
+}
+
+func TestObserverLegacy(t *testing.T) {
+	tf := legacyTestFrames[len(legacyTestFrames)-1]
+	frame, _ := hex.DecodeString(tf.frame)
+
+	ro := &recordingObserver{}
+	fr, _ := NewFrameReader(nil)
+	fr.SetObserver(ro)
+	err := fr.Reset(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = ioutil.ReadAll(fr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if ro.headers[0].LegacyVersion != 7 {
+		t.Errorf("Wrong legacy version: %d", ro.headers[0].LegacyVersion)
+	}
+	checkEvents(t, ro, int64(len(frame)), int64(len(tf.content)))
+}
@@ -0,0 +1,536 @@
+package decompression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/legacy"
+	"github.com/killingspark/sparkzstd/structure"
+	"io"
+	"runtime"
+)
+
+//ParallelDecoder decodes the frames of a multi-frame input, like the output of pzstd or a stream that was compressed in chunks,
+//on a pool of workers. The frames are located by walking their block headers, or directly with the sizes in the skippable
+//frames pzstd writes in front of every frame. The output is reassembled in the order of the frames.
+//
+//At most SetMaxFramesInFlight frames are held in memory at the same time, compressed and decompressed. Frames that are decoded
+//with DecodeAt and whose offset in the output is known from the content sizes of the frames before are written directly
+//and are not held in memory.
+//
+//A frame is only held in memory if it and its content are not bigger than SetMaxFrameSize. Bigger frames are streamed: they are
+//decoded in order, directly into the target, with the memory of one FrameDecompressor. They are not decoded in parallel.
+type ParallelDecoder struct {
+	workers      int
+	maxFrames    int
+	maxFrameSize int
+}
+
+const (
+	skippableMagicnum     = 0x184D2A50 //the lowest 4 bits can be anything
+	skippableMagicnumMask = 0xFFFFFFF0
+	currentMagicnum       = 0xFD2FB528
+)
+
+var ErrFrameSizeMismatch = errors.New("The frame did not use exactly the bytes that were located for it")
+var ErrContentSizeMismatch = errors.New("The frame did not decode to the content size in its header")
+
+//DefaultMaxFrameSize is the default of SetMaxFrameSize. It holds the frames pzstd writes with its default settings
+const DefaultMaxFrameSize = 32 << 20
+
+//errOutputOverLimit is returned by a worker if the content of a frame gets bigger than the frame size limit
+var errOutputOverLimit = errors.New("The content of the frame is bigger than the frame size limit")
+
+//NewParallelDecoder creates a ParallelDecoder with the given number of workers. Zero or less uses one worker per CPU
+func NewParallelDecoder(workers int) *ParallelDecoder {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &ParallelDecoder{workers: workers, maxFrames: 2 * workers, maxFrameSize: DefaultMaxFrameSize}
+}
+
+//SetMaxFramesInFlight limits how many frames are held in memory at the same time. It has to be at least the number of workers
+//to keep all of them busy. The default is twice the number of workers
+func (pd *ParallelDecoder) SetMaxFramesInFlight(n int) {
+	if n < 1 {
+		n = 1
+	}
+	pd.maxFrames = n
+}
+
+//SetMaxFrameSize limits how big a frame and its content can be to be held in memory. Bigger frames are streamed in order instead
+//of being decoded by the workers, so at most about SetMaxFramesInFlight times twice this size is held in memory. Zero or less
+//restores DefaultMaxFrameSize
+func (pd *ParallelDecoder) SetMaxFrameSize(n int) {
+	if n < 1 {
+		n = DefaultMaxFrameSize
+	}
+	pd.maxFrameSize = n
+}
+
+//Decode decodes all frames from source and writes their content in order to target
+func (pd *ParallelDecoder) Decode(source io.Reader, target io.Writer) (int64, error) {
+	return pd.decode(source, target, nil)
+}
+
+//DecodeAt decodes all frames from source and writes their content to target, starting at offset 0.
+//Frames whose offset is known are written by the workers directly
+func (pd *ParallelDecoder) DecodeAt(source io.Reader, target io.WriterAt) (int64, error) {
+	return pd.decode(source, nil, target)
+}
+
+//frameJob is one frame on its way through the ParallelDecoder
+type frameJob struct {
+	compressed []byte
+	output     bytes.Buffer
+	stream     *io.PipeReader //set if the frame is too big to be held in memory. The splitter writes it into the pipe
+
+	offset      int64 //offset in the output if it is known before decoding, -1 otherwise
+	contentSize int64 //-1 if the frame header does not tell it
+	outputSize  int64
+	err        error
+	done       chan struct{}
+}
+
+func (pd *ParallelDecoder) decode(source io.Reader, target io.Writer, targetAt io.WriterAt) (int64, error) {
+	free := make(chan *frameJob, pd.maxFrames)
+	for i := 0; i < pd.maxFrames; i++ {
+		free <- &frameJob{done: make(chan struct{}, 1)}
+	}
+	work := make(chan *frameJob, pd.maxFrames)
+	ordered := make(chan *frameJob, pd.maxFrames)
+	stop := make(chan struct{})
+
+	go pd.splitFrames(bufio.NewReader(source), targetAt != nil, free, work, ordered, stop)
+	for i := 0; i < pd.workers; i++ {
+		go decodeFrames(work, targetAt, pd.maxFrameSize)
+	}
+
+	//collect the frames in order. After an error the rest is only drained, so all goroutines finish
+	var streamed *FrameDecompressor
+	written := int64(0)
+	var err error
+	for job := range ordered {
+		<-job.done
+		if err == nil && (job.stream != nil || job.err == errOutputOverLimit) {
+			//the frames before have been written, so the frame can be decoded directly into the target
+			if streamed == nil {
+				streamed = NewFrameDecompressor(nil, nil)
+			}
+			err = streamFrame(streamed, job, target, targetAt, written)
+			if err != nil {
+				close(stop)
+			}
+			written += job.outputSize
+		} else if err == nil {
+			err = job.err
+			if err == nil && job.offset < 0 {
+				//the content has not been written by the worker
+				if targetAt != nil {
+					_, err = targetAt.WriteAt(job.output.Bytes(), written)
+				} else {
+					_, err = WriteFull(target, job.output.Bytes())
+				}
+			}
+			if err != nil {
+				close(stop)
+			}
+			written += job.outputSize
+		}
+		if job.stream != nil {
+			//lets the splitter stop if the frame was not read to the end
+			job.stream.CloseWithError(err)
+		}
+		free <- job
+	}
+	return written, err
+}
+
+//streamFrame decodes a frame that is too big to be held in memory in order, directly into the target at offset written.
+//The frame is either streamed through job.stream or only its content was too big
+func streamFrame(fd *FrameDecompressor, job *frameJob, target io.Writer, targetAt io.WriterAt, written int64) error {
+	if targetAt != nil {
+		target = io.NewOffsetWriter(targetAt, written)
+	}
+	var source io.Reader = bytes.NewReader(job.compressed)
+	if job.stream != nil {
+		source = job.stream
+	}
+	fd.Reset(source, target)
+	err := fd.Decompress()
+	job.outputSize = fd.decompressedOffset()
+	if err != nil {
+		return err
+	}
+
+	if job.stream != nil {
+		//the splitter writes exactly the frame into the pipe. The content size is known once it is closed
+		rest, err := io.Copy(io.Discard, job.stream)
+		if err != nil {
+			return err
+		}
+		if rest > 0 || fd.source.Buffered() > 0 {
+			return ErrFrameSizeMismatch
+		}
+	} else if fd.sourceOffset() != int64(len(job.compressed)) {
+		return ErrFrameSizeMismatch
+	}
+	if job.contentSize >= 0 && job.outputSize != job.contentSize {
+		return ErrContentSizeMismatch
+	}
+	return nil
+}
+
+//splitFrames reads the frames from the source and hands them to the workers and the collector in order
+func (pd *ParallelDecoder) splitFrames(source *bufio.Reader, direct bool, free <-chan *frameJob, work, ordered chan<- *frameJob, stop <-chan struct{}) {
+	defer close(ordered)
+	defer close(work)
+
+	fs := frameSplitter{source: source, nextSize: -1, limit: pd.maxFrameSize}
+	offset := int64(0)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var job *frameJob
+		select {
+		case job = <-free:
+		case <-stop:
+			return
+		}
+
+		//a frame that gets too big is handed to the collector right away and written into a pipe while it is read
+		job.stream = nil
+		var stream *io.PipeWriter
+		fs.spill = func(data []byte) error {
+			if stream == nil {
+				job.stream, stream = io.Pipe()
+				job.done <- struct{}{}
+				ordered <- job
+			}
+			_, err := stream.Write(data)
+			return err
+		}
+
+		job.compressed, job.contentSize, job.err = fs.readFrame(job.compressed[:0])
+		if stream != nil {
+			if job.err == nil {
+				_, job.err = stream.Write(job.compressed)
+			}
+			stream.CloseWithError(job.err)
+			if job.err != nil {
+				return
+			}
+		} else if job.err == io.EOF {
+			return
+		}
+		if stream == nil && job.err != nil {
+			//the collector reports the error once all frames before have been written
+			job.done <- struct{}{}
+			ordered <- job
+			return
+		}
+
+		job.offset = -1
+		if direct && offset >= 0 {
+			job.offset = offset
+		}
+		if offset >= 0 && job.contentSize >= 0 {
+			offset += job.contentSize
+		} else {
+			offset = -1
+		}
+		if stream == nil {
+			ordered <- job
+			work <- job
+		}
+	}
+}
+
+//decodeFrames is one worker. It has its own FrameDecompressor that is reused for all frames
+func decodeFrames(work <-chan *frameJob, targetAt io.WriterAt, limit int) {
+	fd := NewFrameDecompressor(nil, nil)
+	for job := range work {
+		job.output.Reset()
+		var target io.Writer = &limitedWriter{w: &job.output, n: limit}
+		if job.offset >= 0 {
+			target = io.NewOffsetWriter(targetAt, job.offset)
+		}
+
+		fd.Reset(bytes.NewReader(job.compressed), target)
+		job.err = fd.Decompress()
+		job.outputSize = fd.decompressedOffset()
+		if job.err == nil && fd.sourceOffset() != int64(len(job.compressed)) {
+			job.err = ErrFrameSizeMismatch
+		}
+		if job.err == nil && job.contentSize >= 0 && job.outputSize != job.contentSize {
+			job.err = ErrContentSizeMismatch
+		}
+		job.done <- struct{}{}
+	}
+}
+
+//limitedWriter writes at most n bytes to w
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > lw.n {
+		return 0, errOutputOverLimit
+	}
+	lw.n -= len(p)
+	return lw.w.Write(p)
+}
+
+//frameSplitter finds the boundaries of the frames in the source
+type frameSplitter struct {
+	source *bufio.Reader
+
+	//size of the next frame from a pzstd skippable frame. -1 if unknown
+	nextSize int64
+
+	//once more than limit bytes of a frame have been read they are handed to spill and dropped from the buffer
+	limit int
+	spill func(data []byte) error
+}
+
+//appendReader appends everything that is read through it to buf. If buf gets bigger than limit it is handed to spill
+type appendReader struct {
+	r     io.Reader
+	buf   []byte
+	limit int
+	spill func(data []byte) error
+}
+
+func (ar *appendReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	ar.buf = append(ar.buf, p[:n]...)
+	if err == nil {
+		err = ar.flush()
+	}
+	return n, err
+}
+
+//flush hands buf to spill if it got bigger than limit
+func (ar *appendReader) flush() error {
+	if ar.spill == nil || len(ar.buf) <= ar.limit {
+		return nil
+	}
+	err := ar.spill(ar.buf)
+	ar.buf = ar.buf[:0]
+	return err
+}
+
+//readFrame appends the next frame to buf. Skippable frames are skipped. Returns io.EOF if there are no frames left.
+//contentSize is -1 if the frame header does not tell it
+func (fs *frameSplitter) readFrame(buf []byte) ([]byte, int64, error) {
+	var magic [4]byte
+	for {
+		_, err := io.ReadFull(fs.source, magic[:])
+		if err != nil {
+			return buf, 0, err
+		}
+		num := binary.LittleEndian.Uint32(magic[:])
+		if num&skippableMagicnumMask != skippableMagicnum {
+			break
+		}
+
+		//pzstd writes the compressed size of the next frame in a skippable frame with 4 bytes of content
+		var size [4]byte
+		_, err = io.ReadFull(fs.source, size[:])
+		if err != nil {
+			return buf, 0, io.ErrUnexpectedEOF
+		}
+		skip := int64(binary.LittleEndian.Uint32(size[:]))
+		if num == skippableMagicnum && skip == 4 {
+			_, err = io.ReadFull(fs.source, size[:])
+			fs.nextSize = int64(binary.LittleEndian.Uint32(size[:]))
+		} else {
+			_, err = io.CopyN(io.Discard, fs.source, skip)
+		}
+		if err != nil {
+			return buf, 0, io.ErrUnexpectedEOF
+		}
+	}
+
+	ar := appendReader{r: fs.source, buf: append(buf, magic[:]...), limit: fs.limit, spill: fs.spill}
+	num := binary.LittleEndian.Uint32(magic[:])
+
+	size := fs.nextSize
+	fs.nextSize = -1
+	if size >= 4 {
+		//the size is known, the header only has to be read for the content size
+		counter := countingReader{r: &ar}
+		header, err := readFrameHeader(num, &counter)
+		if err != nil {
+			return ar.buf, 0, err
+		}
+		rest := size - 4 - counter.n
+		if rest < 0 {
+			return ar.buf, 0, ErrFrameSizeMismatch
+		}
+		for err == nil && rest > 0 {
+			n := int64(structure.MaxBlockSize)
+			if n > rest {
+				n = rest
+			}
+			err = readBytes(&ar, int(n))
+			rest -= n
+		}
+		return ar.buf, header.contentSize, err
+	}
+
+	header, err := readFrameHeader(num, &ar)
+	if err != nil {
+		return ar.buf, 0, err
+	}
+	if header.version != 0 {
+		err = skipLegacyBlocks(header.version, &ar)
+	} else {
+		err = skipBlocks(header.checksum, &ar)
+	}
+	return ar.buf, header.contentSize, err
+}
+
+//locatedHeader is what the frameSplitter needs to know from a frame header
+type locatedHeader struct {
+	contentSize int64          //-1 if the header does not tell it
+	version     legacy.Version //0 for the current format
+	checksum    bool           //only for the current format, the checksum of v0.7 is part of the end block
+}
+
+//readFrameHeader reads the header of the frame after the magic number
+func readFrameHeader(num uint32, source io.Reader) (locatedHeader, error) {
+	located := locatedHeader{contentSize: -1}
+	if num != currentMagicnum {
+		version, ok := legacy.VersionFromMagicnum(num)
+		if !ok {
+			return located, ErrWrongMagicnumber
+		}
+		header := legacy.FrameHeader{}
+		err := header.DecodeFrameHeader(version, source)
+		if err != nil {
+			return located, err
+		}
+		located.version = version
+		if header.FrameContentSize != 0 {
+			located.contentSize = int64(header.FrameContentSize)
+		}
+		return located, nil
+	}
+
+	var buf [14]byte
+	_, err := io.ReadFull(source, buf[:1])
+	if err != nil {
+		return located, err
+	}
+	header := structure.FrameHeader{}
+	header.DecodeFrameDescriptor(buf[0])
+	located.checksum = header.Descriptor.GetContentChecksumFlag()
+
+	windowdescriptorsize := 0
+	if !header.Descriptor.GetSingleSegmentFlag() {
+		windowdescriptorsize = 1
+	}
+	dictIDsize, err := header.Descriptor.GetDictionaryFlag()
+	if err != nil {
+		return located, err
+	}
+	framecontentsize, err := header.Descriptor.GetContentSizeFlag()
+	if err != nil {
+		return located, err
+	}
+
+	headersize := windowdescriptorsize + int(dictIDsize) + int(framecontentsize)
+	_, err = io.ReadFull(source, buf[:headersize])
+	if err != nil {
+		return located, err
+	}
+	if framecontentsize > 0 {
+		header.DecodeFrameContentSize(buf[headersize-int(framecontentsize) : headersize])
+		located.contentSize = int64(header.FrameContentSize)
+	}
+	return located, nil
+}
+
+//skipBlocks walks the block headers of a frame in the current format up to the checksum
+func skipBlocks(checksum bool, ar *appendReader) error {
+	var raw [3]byte
+	block := structure.Block{}
+	for !block.Header.LastBlock {
+		_, err := io.ReadFull(ar, raw[:])
+		if err != nil {
+			return err
+		}
+		err = block.DecodeHeader(raw[:])
+		if err != nil {
+			return err
+		}
+		size := int(block.Header.BlockSize)
+		if block.Header.Type == structure.BlockTypeRLE {
+			size = 1
+		}
+		err = readBytes(ar, size)
+		if err != nil {
+			return err
+		}
+	}
+	if checksum {
+		return readBytes(ar, 4)
+	}
+	return nil
+}
+
+//skipLegacyBlocks walks the block headers of a legacy frame up to the end block
+func skipLegacyBlocks(version legacy.Version, ar *appendReader) error {
+	var raw [3]byte
+	for {
+		_, err := io.ReadFull(ar, raw[:])
+		if err != nil {
+			return err
+		}
+		header := legacy.BlockHeader{}
+		err = header.DecodeHeader(version, raw[:])
+		if err != nil {
+			return err
+		}
+		if header.Type == legacy.BlockTypeEnd {
+			return nil
+		}
+		size := header.BlockSize
+		if header.Type == legacy.BlockTypeRLE {
+			size = 1
+		}
+		err = readBytes(ar, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//readBytes reads n bytes through the appendReader. The buffer is handed to spill afterwards if it got too big
+func readBytes(ar *appendReader, n int) error {
+	start := len(ar.buf)
+	for len(ar.buf) < start+n {
+		if cap(ar.buf) < start+n {
+			grown := make([]byte, len(ar.buf), 2*cap(ar.buf)+n)
+			copy(grown, ar.buf)
+			ar.buf = grown
+		}
+		m, err := ar.r.Read(ar.buf[len(ar.buf) : start+n])
+		ar.buf = ar.buf[:len(ar.buf)+m]
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return ar.flush()
+}
@@ -0,0 +1,110 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//writerAtBuffer is an io.WriterAt that grows like a file
+type writerAtBuffer struct {
+	data []byte
+}
+
+func (wb *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(wb.data) {
+		wb.data = append(wb.data, make([]byte, end-len(wb.data))...)
+	}
+	return copy(wb.data[off:], p), nil
+}
+
+//skippableFrame builds a skippable frame like pzstd writes it in front of every frame
+func skippableFrame(magic uint32, content []byte) []byte {
+	frame := make([]byte, 8, 8+len(content))
+	binary.LittleEndian.PutUint32(frame, magic)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(content)))
+	return append(frame, content...)
+}
+
+func TestParallelDecoder(t *testing.T) {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var compressed, pzstd, original []byte
+	for i, file := range files {
+		c, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o, err := ioutil.ReadFile(file[:len(file)-4])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if i%10 == 3 {
+			//some legacy frames in between
+			tf := legacyTestFrames[i%len(legacyTestFrames)]
+			frame, _ := hex.DecodeString(tf.frame)
+			c = append(frame, c...)
+			o = append([]byte(tf.content), o...)
+		}
+		compressed = append(compressed, c...)
+		original = append(original, o...)
+
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(c)))
+		if i%10 == 3 {
+			//the size would only cover the legacy frame
+			pzstd = append(pzstd, skippableFrame(0x184D2A5E, []byte("some other skippable frame"))...)
+		} else {
+			pzstd = append(pzstd, skippableFrame(0x184D2A50, size[:])...)
+		}
+		pzstd = append(pzstd, c...)
+	}
+
+	//with a frame size of 1000 most frames are streamed, some because only their content is too big
+	for _, maxFrameSize := range []int{0, 1000} {
+		for _, workers := range []int{1, 4} {
+			for name, input := range map[string][]byte{"walked": compressed, "pzstd": pzstd} {
+				pd := NewParallelDecoder(workers)
+				pd.SetMaxFrameSize(maxFrameSize)
+				result := &bytes.Buffer{}
+				n, err := pd.Decode(bytes.NewReader(input), result)
+				if err != nil {
+					t.Fatalf("%s, %d workers, frame size %d: %s", name, workers, maxFrameSize, err.Error())
+				}
+				if n != int64(len(original)) || !bytes.Equal(result.Bytes(), original) {
+					t.Errorf("%s, %d workers, frame size %d: Wrong content. Got %d bytes, should be %d bytes", name, workers, maxFrameSize, n, len(original))
+				}
+
+				pd.SetMaxFramesInFlight(1)
+				resultAt := &writerAtBuffer{}
+				n, err = pd.DecodeAt(bytes.NewReader(input), resultAt)
+				if err != nil {
+					t.Fatalf("%s, %d workers, frame size %d: %s", name, workers, maxFrameSize, err.Error())
+				}
+				if n != int64(len(original)) || !bytes.Equal(resultAt.data, original) {
+					t.Errorf("%s, %d workers, frame size %d: Wrong content written at offsets", name, workers, maxFrameSize)
+				}
+			}
+		}
+	}
+
+	//a truncated frame is reported after all frames before it have been written, also if it is streamed
+	for _, maxFrameSize := range []int{0, 1000} {
+		pd := NewParallelDecoder(4)
+		pd.SetMaxFrameSize(maxFrameSize)
+		result := &bytes.Buffer{}
+		_, err = pd.Decode(bytes.NewReader(compressed[:len(compressed)-10]), result)
+		if err == nil {
+			t.Errorf("Frame size %d: Truncated input was not detected", maxFrameSize)
+		}
+		if result.Len() == 0 || !bytes.HasPrefix(original, result.Bytes()) {
+			t.Errorf("Frame size %d: Frames before the error were not written in order", maxFrameSize)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package decompression
+
+import (
+	"github.com/killingspark/sparkzstd/structure"
+	"io"
+	"time"
+)
+
+//pipelineDepth is the number of blocks in the pipeline. One is executed while the others can be decoded ahead
+const pipelineDepth = 3
+
+//pipelineBlock is a block that has been decoded ahead together with the buffers it was decoded into
+type pipelineBlock struct {
+	block structure.Block
+
+	literalsDataBuf           []byte //also holds the content of raw blocks
+	literalsCompressedDataBuf []byte
+	sequencesDataBuf          []byte
+	sequencesBuf              []structure.Sequence
+
+	rleByte          byte
+	compressedOffset int64 //offset after the block
+	err              error
+}
+
+//SetPipelined enables decoding the blocks ahead in a second goroutine. The huffman and fse decoding of the next blocks then
+//overlaps with executing the sequences of the current block, which speeds up big frames on multicore machines.
+//It is only used by Decompress for frames in the current format and not if an Observer is set, the events of the two goroutines
+//could not be delivered in order. Those frames are decoded sequentially, UsesPipeline tells which way the current frame is
+//decoded. It needs the block buffers pipelineDepth times and the sequences are always decoded before they are executed
+func (fd *FrameDecompressor) SetPipelined(enabled bool) {
+	fd.pipelined = enabled
+}
+
+//UsesPipeline reports whether Decompress decodes the current frame in the pipeline. It is false if SetPipelined is off, an
+//Observer is set or the frame is a legacy frame
+func (fd *FrameDecompressor) UsesPipeline() bool {
+	return fd.pipelined && fd.legacyVersion == 0 && fd.Observer == nil
+}
+
+//preparePipeline makes sure there are enough buffer sets for the block size of the current frame
+func (fd *FrameDecompressor) preparePipeline() {
+	for len(fd.pipelineBlocks) < pipelineDepth {
+		fd.pipelineBlocks = append(fd.pipelineBlocks, &pipelineBlock{})
+	}
+	first := fd.pipelineBlocks[0]
+	first.literalsDataBuf = fd.literalsDataBuf
+	first.literalsCompressedDataBuf = fd.literalsCompressedDataBuf
+	first.sequencesDataBuf = fd.sequencesDataBuf
+	first.sequencesBuf = fd.sequencesBuf
+
+	size := fd.maxBlockSize
+	for _, pb := range fd.pipelineBlocks[1:] {
+		if cap(pb.sequencesDataBuf) == size || (fd.memoryProfile == MemoryProfileDefault && cap(pb.sequencesDataBuf) >= size) {
+			pb.literalsDataBuf = pb.literalsDataBuf[:size]
+			pb.literalsCompressedDataBuf = pb.literalsCompressedDataBuf[:size]
+			pb.sequencesDataBuf = pb.sequencesDataBuf[:size]
+			continue
+		}
+		pb.literalsDataBuf = make([]byte, size)
+		pb.literalsCompressedDataBuf = make([]byte, size)
+		pb.sequencesDataBuf = make([]byte, size)
+	}
+}
+
+//decodeAllBlocksPipelined decodes the blocks of the frame in a second goroutine and executes them in this one
+func (fd *FrameDecompressor) decodeAllBlocksPipelined() error {
+	fd.preparePipeline()
+
+	free := make(chan *pipelineBlock, pipelineDepth)
+	decoded := make(chan *pipelineBlock, pipelineDepth)
+	stop := make(chan struct{})
+	for _, pb := range fd.pipelineBlocks {
+		free <- pb
+	}
+
+	fd.pipelineOffset = fd.sourceOffset()
+	fd.pipelineRunning = true
+	go fd.decodeBlocksAhead(free, decoded, stop)
+
+	//the decoding goroutine owns the source, CurrentBlock and PreviousBlock until decoded is closed
+	var err error
+	for pb := range decoded {
+		if err == nil {
+			err = pb.err
+			if err == nil {
+				err = fd.executePipelineBlock(pb)
+			}
+			if err != nil {
+				close(stop)
+			}
+		}
+		free <- pb
+	}
+	fd.pipelineRunning = false
+
+	//keep the first buffer set as the buffers of the FrameDecompressor
+	first := fd.pipelineBlocks[0]
+	fd.literalsDataBuf = first.literalsDataBuf
+	fd.literalsCompressedDataBuf = first.literalsCompressedDataBuf
+	fd.sequencesDataBuf = first.sequencesDataBuf
+	fd.sequencesBuf = first.sequencesBuf
+	return err
+}
+
+//decodeBlocksAhead is the decoding stage of the pipeline. It stops after the last block, an error, or if stop is closed
+func (fd *FrameDecompressor) decodeBlocksAhead(free <-chan *pipelineBlock, decoded chan<- *pipelineBlock, stop <-chan struct{}) {
+	defer close(decoded)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var pb *pipelineBlock
+		select {
+		case pb = <-free:
+		case <-stop:
+			return
+		}
+
+		pb.err = fd.decodeBlockAhead(pb)
+		decoded <- pb
+		if pb.err != nil || pb.block.Header.LastBlock {
+			return
+		}
+	}
+}
+
+//decodeBlockAhead reads the next block into the buffers of pb and decodes literals and sequences
+func (fd *FrameDecompressor) decodeBlockAhead(pb *pipelineBlock) error {
+	fd.literalsDataBuf = pb.literalsDataBuf
+	fd.literalsCompressedDataBuf = pb.literalsCompressedDataBuf
+	fd.sequencesDataBuf = pb.sequencesDataBuf
+	fd.sequencesBuf = pb.sequencesBuf
+
+	err := fd.DecodeNextBlockHeader()
+	if err != nil {
+		return err
+	}
+	size := fd.CurrentBlock.Header.BlockSize
+	if size > uint64(fd.maxBlockSize) {
+		return ErrBlockTooLarge
+	}
+	if fd.metrics != nil {
+		fd.metrics.countBlock(fd.CurrentBlock.Header.Type)
+	}
+
+	switch fd.CurrentBlock.Header.Type {
+	case structure.BlockTypeRaw:
+		_, err = io.ReadFull(fd.source, pb.literalsDataBuf[:size])
+	case structure.BlockTypeCompressed:
+		fd.limitedSource = io.LimitedReader{R: fd.source, N: int64(size)}
+		err = fd.DecodeNextBlockContent()
+	default:
+		pb.rleByte, err = fd.source.ReadByte()
+	}
+	if err != nil {
+		return err
+	}
+
+	err = fd.skipChecksum()
+	pb.block = fd.CurrentBlock
+	pb.sequencesBuf = fd.sequencesBuf
+	pb.compressedOffset = fd.sourceOffset()
+	return err
+}
+
+//executePipelineBlock is the execution stage of the pipeline. It writes the content of the decoded block into the window
+func (fd *FrameDecompressor) executePipelineBlock(pb *pipelineBlock) error {
+	var start time.Time
+	if fd.metrics != nil {
+		start = time.Now()
+	}
+
+	var err error
+	size := int(pb.block.Header.BlockSize)
+	switch pb.block.Header.Type {
+	case structure.BlockTypeRaw:
+		err = fd.decodebuffer.Push(pb.literalsDataBuf[:size])
+	case structure.BlockTypeCompressed:
+		err = fd.executeBlockSequences(&pb.block)
+	default:
+		err = fd.decodebuffer.PushRLE(pb.rleByte, size)
+	}
+	if err != nil {
+		return err
+	}
+
+	fd.pipelineOffset = pb.compressedOffset
+	if fd.metrics != nil {
+		if pb.block.Header.Type == structure.BlockTypeCompressed {
+			addDuration(&fd.metrics.ExecutionTime, start)
+		}
+		fd.countBytes(pb.block.Header.LastBlock)
+	}
+	fd.reportProgress(pb.block.Header.LastBlock)
+	fd.BlockCounter++
+	return nil
+}
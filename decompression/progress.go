@@ -0,0 +1,102 @@
+package decompression
+
+import (
+	"time"
+)
+
+//Progress describes how far the decoding of a source has come. All values count from the start of the source, over all frames
+type Progress struct {
+	CompressedBytes   int64 //consumed from the source
+	DecompressedBytes int64 //decoded so far. Some of these might not have been written to the target yet
+	FinishedFrames    int
+
+	//ExpectedDecompressedBytes is DecompressedBytes plus what is still missing of the current frame. It is -1 if the current frame has
+	//no content size. Frames that follow the current one are never included, their sizes can not be known in advance
+	ExpectedDecompressedBytes int64
+
+	//CompressedSize is copied from the ProgressConfig
+	CompressedSize int64
+}
+
+//ProgressFunc is called synchronously from the decoding goroutine, so it should return fast
+type ProgressFunc func(Progress)
+
+//ProgressConfig controls how often the ProgressFunc is called. It is called after a block was decoded when at least ByteInterval
+//decompressed bytes or at least TimeInterval have passed since the last call. If both are 0 it is called after every block.
+//Additionally it is always called when a frame is finished.
+type ProgressConfig struct {
+	Callback     ProgressFunc
+	ByteInterval int64
+	TimeInterval time.Duration
+
+	//CompressedSize of the whole source (eg. the size of the file) if the caller knows it, so percentages can be calculated
+	//for frames without a content size. 0 if unknown
+	CompressedSize int64
+}
+
+//progressTracker keeps the state needed for the progress over multiple frames
+type progressTracker struct {
+	config ProgressConfig
+
+	finishedFrames int
+	finishedBytes  int64 //decompressed bytes of all finished frames
+
+	lastBytes int64
+	lastTime  time.Time
+}
+
+func (pt *progressTracker) reset() {
+	pt.finishedFrames = 0
+	pt.finishedBytes = 0
+	pt.lastBytes = 0
+	pt.lastTime = time.Now()
+}
+
+//SetProgress sets the callback for progress reports. A config without Callback disables the reports
+func (fd *FrameDecompressor) SetProgress(config ProgressConfig) {
+	fd.progress.config = config
+	fd.progress.lastBytes = fd.progress.finishedBytes + fd.decompressedOffset()
+	fd.progress.lastTime = time.Now()
+}
+
+//reportProgress is called after every block
+func (fd *FrameDecompressor) reportProgress(lastBlock bool) {
+	pt := &fd.progress
+
+	frameBytes := fd.decompressedOffset()
+	frameFinished := lastBlock
+	if frameFinished {
+		pt.finishedFrames++
+		pt.finishedBytes += frameBytes
+		frameBytes = 0
+	}
+
+	if pt.config.Callback == nil {
+		return
+	}
+
+	p := Progress{
+		CompressedBytes:           fd.compressedOffset(),
+		DecompressedBytes:         pt.finishedBytes + frameBytes,
+		FinishedFrames:            pt.finishedFrames,
+		ExpectedDecompressedBytes: -1,
+		CompressedSize:            pt.config.CompressedSize,
+	}
+	if frameFinished {
+		p.ExpectedDecompressedBytes = p.DecompressedBytes
+	} else if fd.frame.Header.FrameContentSize > 0 {
+		p.ExpectedDecompressedBytes = pt.finishedBytes + int64(fd.frame.Header.FrameContentSize)
+	}
+
+	if !frameFinished && (pt.config.ByteInterval > 0 || pt.config.TimeInterval > 0) {
+		bytesDue := pt.config.ByteInterval > 0 && p.DecompressedBytes-pt.lastBytes >= pt.config.ByteInterval
+		timeDue := pt.config.TimeInterval > 0 && time.Since(pt.lastTime) >= pt.config.TimeInterval
+		if !bytesDue && !timeDue {
+			return
+		}
+	}
+
+	pt.lastBytes = p.DecompressedBytes
+	pt.lastTime = time.Now()
+	pt.config.Callback(p)
+}
@@ -0,0 +1,103 @@
+package decompression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProgressMultipleFrames(t *testing.T) {
+	var compressed, original []byte
+	for _, name := range []string{"z000033", "z000001"} {
+		c, err := ioutil.ReadFile("../decodecorpus_files/" + name + ".zst")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o, err := ioutil.ReadFile("../decodecorpus_files/" + name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		compressed = append(compressed, c...)
+		original = append(original, o...)
+	}
+	//and a legacy frame
+	tf := legacyTestFrames[len(legacyTestFrames)-2]
+	frame, _ := hex.DecodeString(tf.frame)
+	compressed = append(compressed, frame...)
+	original = append(original, tf.content...)
+
+	var reports []Progress
+	fr, _ := NewFrameReader(nil)
+	fr.SetProgress(ProgressConfig{
+		Callback:       func(p Progress) { reports = append(reports, p) },
+		ByteInterval:   64 * 1024,
+		CompressedSize: int64(len(compressed)),
+	})
+	err := fr.Reset(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := ioutil.ReadAll(fr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(result, original) {
+		t.Fatalf("Wrong content. Got %d bytes, should be %d bytes", len(result), len(original))
+	}
+
+	if len(reports) < 4 {
+		t.Fatalf("Got only %d reports", len(reports))
+	}
+	last := Progress{}
+	for i, p := range reports {
+		if p.CompressedBytes < last.CompressedBytes || p.DecompressedBytes < last.DecompressedBytes || p.FinishedFrames < last.FinishedFrames {
+			t.Errorf("Report %d went backwards: %v after %v", i, p, last)
+		}
+		if p.FinishedFrames == last.FinishedFrames && p.DecompressedBytes-last.DecompressedBytes < 64*1024 {
+			t.Errorf("Report %d came too early: %v after %v", i, p, last)
+		}
+		if p.ExpectedDecompressedBytes != -1 && p.ExpectedDecompressedBytes < p.DecompressedBytes {
+			t.Errorf("Report %d expects less than was decoded: %v", i, p)
+		}
+		if p.CompressedSize != int64(len(compressed)) {
+			t.Errorf("Report %d has the wrong CompressedSize: %d", i, p.CompressedSize)
+		}
+		last = p
+	}
+
+	if last.FinishedFrames != 3 || last.CompressedBytes != int64(len(compressed)) || last.DecompressedBytes != int64(len(original)) {
+		t.Errorf("Wrong last report: %v", last)
+	}
+	if last.ExpectedDecompressedBytes != last.DecompressedBytes {
+		t.Errorf("Finished frames should have a known size: %v", last)
+	}
+}
+
+func TestProgressUnknownContentSize(t *testing.T) {
+	tf := legacyTestFrames[len(legacyTestFrames)-1]
+	frame, _ := hex.DecodeString(tf.frame)
+
+	var reports []Progress
+	fd := NewFrameDecompressor(bytes.NewReader(frame), &bytes.Buffer{})
+	fd.SetProgress(ProgressConfig{Callback: func(p Progress) { reports = append(reports, p) }})
+	err := fd.Decompress()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	//one report per block. Only the last one knows the size
+	if len(reports) < 2 {
+		t.Fatalf("Got only %d reports", len(reports))
+	}
+	for _, p := range reports[:len(reports)-1] {
+		if p.ExpectedDecompressedBytes != -1 || p.FinishedFrames != 0 {
+			t.Errorf("Wrong report before the end of the frame: %v", p)
+		}
+	}
+	last := reports[len(reports)-1]
+	if last.FinishedFrames != 1 || last.ExpectedDecompressedBytes != int64(len(tf.content)) {
+		t.Errorf("Wrong last report: %v", last)
+	}
+}
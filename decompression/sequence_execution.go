@@ -5,63 +5,84 @@ import (
 	"github.com/killingspark/sparkzstd/structure"
 )
 
-var counter = 0
-var totalOutput = 0
-
 var ErrDidntCopyAllLiteralBytes = errors.New("Not enough bytes read to execute literals copy")
 
 //ExecuteSequences is used after decoding to produce the actual decompressed content of the block
 func (fd *FrameDecompressor) ExecuteSequences() error {
+	return fd.executeBlockSequences(&fd.CurrentBlock)
+}
 
-	for _, seq := range fd.CurrentBlock.Sequences.Sequences {
-
-		//literals copy
-		if seq.LiteralLength > 0 {
-			lbuf := fd.literalsCopyBuf[:seq.LiteralLength]
-			n, err := fd.CurrentBlock.Literals.Read(lbuf)
-
-			if err != nil {
-				return err
-			}
-			if n != seq.LiteralLength {
-				return ErrDidntCopyAllLiteralBytes
-			}
+//executeBlockSequences executes the decoded sequences of the block
+func (fd *FrameDecompressor) executeBlockSequences(block *structure.Block) error {
+	for _, seq := range block.Sequences.Sequences {
+		err := fd.executeSequence(&block.Literals, seq)
+		if err != nil {
+			return err
+		}
+	}
+	return fd.executeLastLiterals(&block.Literals)
+}
 
-			err = fd.decodebuffer.Push(lbuf)
-			if err != nil {
-				return err
+//decodeAndExecuteSequences decodes a small batch of sequences at a time and executes them right away. The sequences are never
+//stored, so big blocks do not need the Sequences slice and the decoded values are still in the cache when they are used.
+//The batches are decoded by the assembly decoder if there is one
+func (fd *FrameDecompressor) decodeAndExecuteSequences() error {
+	ss := &fd.CurrentBlock.Sequences
+	if ss.Header.NumberOfSequences > 0 {
+		err := ss.InitSequences()
+		if err != nil {
+			return err
+		}
+		for left := ss.Header.NumberOfSequences; left > 0; {
+			batch := ss.NextSequences(fd.sequenceBatch[:])
+			for _, seq := range batch {
+				err = fd.executeSequence(&fd.CurrentBlock.Literals, seq)
+				if err != nil {
+					return err
+				}
 			}
+			left -= len(batch)
 		}
+		_, err = ss.FinishSequences()
+		if err != nil {
+			return err
+		}
+	}
+	return fd.executeLastLiterals(&fd.CurrentBlock.Literals)
+}
 
-		//print(seq.LiteralLength)
-		//print("/")
-		//print(seq.MatchLength)
-		//print("/")
-		//println(seq.Offset)
-
-		//offset & match
-		offset := fd.nextOffset(seq) //updates offset history
-		if seq.MatchLength > 0 {
-			err := fd.decodebuffer.RepeatBeforeIndex(int(seq.MatchLength), int(offset))
-			if err != nil {
-				return err
-			}
+//executeSequence copies the literals and the match of one sequence into the window
+func (fd *FrameDecompressor) executeSequence(literals *structure.LiteralSection, seq structure.Sequence) error {
+	//literals copy
+	if seq.LiteralLength > 0 {
+		lbuf, err := literals.Next(seq.LiteralLength)
+		if err != nil {
+			return ErrDidntCopyAllLiteralBytes
 		}
 
-		totalOutput += seq.LiteralLength
-		totalOutput += seq.MatchLength
+		err = fd.decodebuffer.Push(lbuf)
+		if err != nil {
+			return err
+		}
 	}
 
-	lastLiterals := fd.CurrentBlock.Literals.GetRest()
-	err := fd.decodebuffer.Push(lastLiterals)
-	if err != nil {
-		return err
+	//offset & match
+	offset := fd.nextOffset(seq) //updates offset history
+	if seq.MatchLength > 0 {
+		err := fd.decodebuffer.Repeat(int(seq.MatchLength), int(offset))
+		if err != nil {
+			return err
+		}
 	}
-	totalOutput += len(lastLiterals)
 
 	return nil
 }
 
+//executeLastLiterals copies the literals that are left after the last sequence
+func (fd *FrameDecompressor) executeLastLiterals(literals *structure.LiteralSection) error {
+	return fd.decodebuffer.Push(literals.GetRest())
+}
+
 func (fd *FrameDecompressor) nextOffset(seq structure.Sequence) int64 {
 	var offset int64
 
@@ -112,3 +133,15 @@ func (fd *FrameDecompressor) nextOffset(seq structure.Sequence) int64 {
 
 	return offset
 }
+
+//SetTwoPhaseSequences switches between executing every sequence right after it has been decoded (the default) and decoding all
+//sequences of a block first. The two phase path is slower but keeps the decoded sequences in CurrentBlock.Sequences.Sequences
+//for tools that want to inspect them. The time for decoding the sequences is counted as ExecutionTime in the Metrics if it is off
+func (fd *FrameDecompressor) SetTwoPhaseSequences(enabled bool) {
+	fd.twoPhaseSequences = enabled
+}
+
+//SetTwoPhaseSequences sets the sequence decoding of the underlying FrameDecompressor
+func (fr *FrameReader) SetTwoPhaseSequences(enabled bool) {
+	fr.fd.SetTwoPhaseSequences(enabled)
+}
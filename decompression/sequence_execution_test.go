@@ -0,0 +1,86 @@
+package decompression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTwoPhaseSequences(t *testing.T) {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, file := range files {
+		compressed, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		fused := &bytes.Buffer{}
+		fd := NewFrameDecompressor(bytes.NewReader(compressed), fused)
+		fusedErr := fd.Decompress()
+
+		twoPhase := &bytes.Buffer{}
+		fd = NewFrameDecompressor(bytes.NewReader(compressed), twoPhase)
+		fd.SetTwoPhaseSequences(true)
+		twoPhaseErr := fd.Decompress()
+
+		if fusedErr != twoPhaseErr {
+			t.Errorf("%s: Different errors: %v and %v", file, fusedErr, twoPhaseErr)
+		}
+		if !bytes.Equal(fused.Bytes(), twoPhase.Bytes()) {
+			t.Errorf("%s: Different output", file)
+		}
+	}
+}
+
+func TestPipelined(t *testing.T) {
+	files, err := filepath.Glob("../decodecorpus_files/*.zst")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	//one decoder for all files, so the buffer sets are reused between frames of different sizes
+	pipelined := NewFrameDecompressor(nil, nil)
+	pipelined.SetPipelined(true)
+	metrics := &Metrics{}
+	pipelined.SetMetrics(metrics)
+	for _, file := range files {
+		compressed, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		sequential := &bytes.Buffer{}
+		fd := NewFrameDecompressor(bytes.NewReader(compressed), sequential)
+		sequentialErr := fd.Decompress()
+
+		result := &bytes.Buffer{}
+		pipelined.Reset(bytes.NewReader(compressed), result)
+		pipelinedErr := pipelined.Decompress()
+
+		if sequentialErr != pipelinedErr {
+			t.Errorf("%s: Different errors: %v and %v", file, sequentialErr, pipelinedErr)
+		}
+		if !bytes.Equal(sequential.Bytes(), result.Bytes()) {
+			t.Errorf("%s: Different output", file)
+		}
+		if sequentialErr == nil && !pipelined.UsesPipeline() {
+			t.Errorf("%s: The pipeline was not used", file)
+		}
+		if sequentialErr == nil && pipelined.BlockCounter != fd.BlockCounter {
+			t.Errorf("%s: Counted %d blocks, should be %d", file, pipelined.BlockCounter, fd.BlockCounter)
+		}
+	}
+	if metrics.BytesOut == 0 || metrics.Frames == 0 {
+		t.Errorf("Metrics were not counted")
+	}
+
+	//the events would come from two goroutines, so an Observer turns the pipeline off
+	pipelined.Observer = &recordingObserver{}
+	if pipelined.UsesPipeline() {
+		t.Errorf("The pipeline is used with an Observer")
+	}
+}
@@ -0,0 +1,308 @@
+package decompression
+
+import (
+	"errors"
+	"io"
+)
+
+//Window holds the decoded data of the current frame that can still be referenced by matches. It provides all methods needed for
+//sequence execution (literal copy and offset/match copy).
+//
+//It is a linear buffer with room for at least two windows. New data is appended at the end. When the buffer is full everything that
+//was not yet written to Dump gets written and the newest Len bytes are moved to the front. So the history never wraps around and
+//matches are always one (possibly overlapping) copy.
+//
+//With a file-backed history (see ResetFileBacked) the buffer can be smaller than the window. Matches that reach further back than
+//the buffer read their source back from the output that was already written.
+type Window struct {
+	data   []byte //len(data) is the end of the decoded data, cap(data) is the size of the buffer
+	dumped int    //data[:dumped] has already been written to Dump
+
+	VirtualIndex int64 //index of the last decoded byte in the frame. -1 if nothing has been decoded yet
+	Len          int   //size of the window. Matches can not reach further back than this
+
+	Dump io.Writer
+
+	//only set for a file-backed history. The byte with index i in the frame is at historyStart+i in history
+	history      io.ReaderAt
+	historyStart int64
+}
+
+//HistoryFile is an output that can be read back while it is written, like an *os.File that was opened for reading and writing
+type HistoryFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+//minWindowBufferSize keeps tiny windows from sliding all the time
+const minWindowBufferSize = 4 * 1024
+
+//windowBufferSize is the size of the buffer a Window allocates for a window of n bytes. The content of a single segment frame
+//is never bigger than its window, so the buffer does not need room for a second window there
+func windowBufferSize(n int, singleSegment bool) int {
+	size := 2 * n
+	if singleSegment {
+		size = n
+	}
+	if size < minWindowBufferSize {
+		return minWindowBufferSize
+	}
+	return size
+}
+
+//NewWindow creates a new Window with the appropriatly sized buffer
+func NewWindow(n int, dump io.Writer) *Window {
+	return &Window{
+		data:         make([]byte, 0, windowBufferSize(n, false)),
+		Len:          n,
+		Dump:         dump,
+		VirtualIndex: -1,
+	}
+}
+
+//Reset prepares the Window for a new frame. The buffer is reused if it is big enough
+func (w *Window) Reset(n int, dump io.Writer) {
+	w.reset(n, windowBufferSize(n, false), dump)
+}
+
+//ResetSingleSegment prepares the Window for a single segment frame with n bytes of content. The buffer only has to hold them once
+func (w *Window) ResetSingleSegment(n int, dump io.Writer) {
+	w.reset(n, windowBufferSize(n, true), dump)
+}
+
+func (w *Window) reset(n int, size int, dump io.Writer) {
+	if cap(w.data) >= size {
+		w.data = w.data[:0]
+	} else {
+		w.data = make([]byte, 0, size)
+	}
+	w.dumped = 0
+	w.Len = n
+	w.Dump = dump
+	w.VirtualIndex = -1
+	w.history = nil
+	w.historyStart = 0
+}
+
+//ResetFileBacked prepares the Window for a new frame whose output is written to file, starting at offset start. Only the newest cache
+//bytes of the window are kept in memory (in a buffer of twice that size), older data that is referenced by matches is read back
+//from file
+func (w *Window) ResetFileBacked(n int, cache int, file HistoryFile, start int64) {
+	size := n
+	if size > cache {
+		size = cache
+	}
+	w.Reset(size, io.NewOffsetWriter(file, start))
+	w.Len = n
+	w.history = file
+	w.historyStart = start
+}
+
+//WriteFull is the equivalent to io.ReadFull
+func WriteFull(w io.Writer, data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		x, err := w.Write(data[written:])
+		written += x
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+var ErrDidntDumpAll = errors.New("Did not write all bytes. Output will likely be corrupted")
+
+//Flush writes all data that has not been written to Dump yet. The data is kept as history for following matches
+func (w *Window) Flush() error {
+	n, err := WriteFull(w.Dump, w.data[w.dumped:])
+	w.dumped += n
+	if err != nil {
+		return err
+	}
+	if w.dumped != len(w.data) {
+		return ErrDidntDumpAll
+	}
+	return nil
+}
+
+//Drain returns the data that has not been written to Dump yet and counts it as written. The slice points into the buffer of the
+//Window, so it is only valid until the Window is changed
+func (w *Window) Drain() []byte {
+	data := w.data[w.dumped:]
+	w.dumped = len(w.data)
+	return data
+}
+
+//slide flushes the buffer and moves the newest Len bytes to the front to make room for new data. A file-backed history keeps
+//at most half of the buffer
+func (w *Window) slide() error {
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	keep := w.Len
+	if keep > cap(w.data)/2 {
+		keep = cap(w.data) / 2
+	}
+	if keep > len(w.data) {
+		keep = len(w.data)
+	}
+	copy(w.data, w.data[len(w.data)-keep:])
+	w.data = w.data[:keep]
+	w.dumped = keep
+	return nil
+}
+
+//Push appends the new data at the end of the buffer
+func (w *Window) Push(newdata []byte) error {
+	w.VirtualIndex += int64(len(newdata))
+
+	for len(newdata) > 0 {
+		if len(w.data) == cap(w.data) {
+			err := w.slide()
+			if err != nil {
+				return err
+			}
+		}
+		end := len(w.data)
+		n := copy(w.data[end:cap(w.data)], newdata)
+		w.data = w.data[:end+n]
+		newdata = newdata[n:]
+	}
+	return nil
+}
+
+//PushFrom appends n bytes that are read directly from src into the buffer
+func (w *Window) PushFrom(src io.Reader, n int) error {
+	w.VirtualIndex += int64(n)
+
+	for n > 0 {
+		if len(w.data) == cap(w.data) {
+			err := w.slide()
+			if err != nil {
+				return err
+			}
+		}
+		end := len(w.data)
+		chunk := cap(w.data) - end
+		if chunk > n {
+			chunk = n
+		}
+		read, err := io.ReadFull(src, w.data[end:end+chunk])
+		w.data = w.data[:end+read]
+		n -= read
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//For the sake of it implement io.Writer interface
+func (w *Window) Write(data []byte) (int, error) {
+	err := w.Push(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+//PushRLE appends n times the byte b
+func (w *Window) PushRLE(b byte, n int) error {
+	if n == 0 {
+		return nil
+	}
+	if len(w.data) == cap(w.data) {
+		err := w.slide()
+		if err != nil {
+			return err
+		}
+	}
+	w.data = append(w.data, b)
+	w.VirtualIndex++
+	return w.Repeat(n-1, 1)
+}
+
+var ErrCantRepeatBytes = errors.New("You cant repeat bytes from before the first one or outside of the window")
+
+//Repeat executes a match: it appends n bytes, copied from offset bytes before the end of the decoded data.
+//n can be bigger than offset, then the copied bytes repeat themselves.
+// abcdefgh <- current data
+// n = 5, offset = 3
+// result: abcdefghfghfg
+func (w *Window) Repeat(n int, offset int) error {
+	next := w.VirtualIndex + 1 //index of the next byte in the frame
+	if offset <= 0 || offset > w.Len || int64(offset) > next || (w.history == nil && offset > len(w.data)) {
+		return ErrCantRepeatBytes
+	}
+	w.VirtualIndex += int64(n)
+
+	for n > 0 {
+		if len(w.data) == cap(w.data) {
+			//offset <= Len, so after sliding the referenced bytes are still there. Except for a file-backed history
+			err := w.slide()
+			if err != nil {
+				return err
+			}
+		}
+		chunk := cap(w.data) - len(w.data)
+		if chunk > n {
+			chunk = n
+		}
+		if offset > len(w.data) {
+			//the start of the match is not in the buffer anymore, but it has been written to the history already
+			if chunk > offset-len(w.data) {
+				chunk = offset - len(w.data)
+			}
+			err := w.readHistory(chunk, next-int64(offset))
+			if err != nil {
+				return err
+			}
+		} else {
+			w.copyMatch(chunk, offset)
+		}
+		n -= chunk
+		next += int64(chunk)
+	}
+	return nil
+}
+
+//readHistory appends n bytes that are read back from the history, starting at index pos in the frame. The caller makes sure
+//they fit into the buffer
+func (w *Window) readHistory(n int, pos int64) error {
+	end := len(w.data)
+	read, err := w.history.ReadAt(w.data[end:end+n], w.historyStart+pos)
+	if read < n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	w.data = w.data[:end+n]
+	return nil
+}
+
+//copyMatch appends n bytes copied from offset bytes before the end. The caller makes sure they fit into the buffer
+func (w *Window) copyMatch(n int, offset int) {
+	end := len(w.data)
+	src := end - offset
+	w.data = w.data[:end+n]
+
+	if offset >= n {
+		copy(w.data[end:], w.data[src:src+n])
+		return
+	}
+
+	//overlapping match. data[src:end] is a whole number of repetitions, so it can be copied as a block and doubles every time
+	for end < len(w.data) {
+		end += copy(w.data[end:], w.data[src:end])
+	}
+}
+
+//String is just for testing purposes but might be useful in other scenarios
+//returns the data that is currently held in the buffer
+func (w *Window) String() string {
+	return string(w.data)
+}
@@ -0,0 +1,253 @@
+package decompression
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestWindowRepeat(t *testing.T) {
+	resultbuf := &bytes.Buffer{}
+
+	w := NewWindow(10, resultbuf)
+	err := w.Push([]byte("abcdefgh"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = w.Repeat(3, 5)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.String() != "abcdefghdef" {
+		t.Errorf("Wrong content: %s, should be: %s", w.String(), "abcdefghdef")
+	}
+
+	//overlapping match
+	err = w.Repeat(5, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.String() != "abcdefghdefefefe" {
+		t.Errorf("Wrong content: %s, should be: %s", w.String(), "abcdefghdefefefe")
+	}
+
+	err = w.PushRLE('x', 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resultbuf.Len() != 0 {
+		t.Errorf("Pushed out but shouldnt at all: %s", resultbuf.String())
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resultbuf.String() != "abcdefghdefefefexxx" {
+		t.Errorf("Pushed out: %s but should be: %s", resultbuf.String(), "abcdefghdefefefexxx")
+	}
+	if w.VirtualIndex != 18 {
+		t.Errorf("Wrong VirtualIndex: %d, should be: %d", w.VirtualIndex, 18)
+	}
+
+	//offsets outside of the window are not allowed
+	if w.Repeat(1, 11) != ErrCantRepeatBytes {
+		t.Errorf("Repeated from outside of the window")
+	}
+	w.Reset(10, resultbuf)
+	w.Push([]byte("abc"))
+	if w.Repeat(1, 4) != ErrCantRepeatBytes {
+		t.Errorf("Repeated from before the first byte")
+	}
+}
+
+func TestRandomWindow(t *testing.T) {
+	resultbuf := &bytes.Buffer{}
+	w := NewWindow(100, resultbuf)
+
+	//the content as one long slice to check against
+	should := []byte{}
+
+	for i := 0; i < 10000; i++ {
+		if rand.Intn(2) == 0 || len(should) == 0 {
+			toPush := make([]byte, rand.Intn(3*w.Len))
+			for idx := range toPush {
+				//for convenience take only ascii characters, so error messages dont completly destroy your terminal
+				toPush[idx] = 33 + byte(rand.Intn(127-33))
+			}
+			err := w.Push(toPush)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			should = append(should, toPush...)
+		} else {
+			maxOffset := w.Len
+			if len(should) < maxOffset {
+				maxOffset = len(should)
+			}
+			offset := 1 + rand.Intn(maxOffset)
+			n := rand.Intn(3 * w.Len)
+			err := w.Repeat(n, offset)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			for j := 0; j < n; j++ {
+				should = append(should, should[len(should)-offset])
+			}
+		}
+
+		if w.VirtualIndex != int64(len(should)-1) {
+			t.Fatalf("Wrong VirtualIndex: %d, should be: %d", w.VirtualIndex, len(should)-1)
+		}
+		//everything that is not in the buffer anymore must have been written already
+		if resultbuf.Len()+len(w.data)-w.dumped != len(should) {
+			t.Fatalf("Wrong amount pushed out: %d, with %d bytes in the buffer for %d bytes", resultbuf.Len(), len(w.data), len(should))
+		}
+	}
+
+	err := w.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(resultbuf.Bytes(), should) {
+		t.Errorf("Wrong content pushed out")
+	}
+}
+
+//memoryFile is a HistoryFile in memory
+type memoryFile struct {
+	data []byte
+}
+
+func (mf *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(mf.data) {
+		mf.data = append(mf.data, make([]byte, end-len(mf.data))...)
+	}
+	return copy(mf.data[off:], p), nil
+}
+
+func (mf *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(mf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, mf.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestSingleSegmentWindow(t *testing.T) {
+	w := NewWindow(0, nil)
+	w.ResetSingleSegment(100000, &bytes.Buffer{})
+	if cap(w.data) != 100000 {
+		t.Errorf("Wrong buffer size: %d, should be: %d", cap(w.data), 100000)
+	}
+	w.Reset(100000, &bytes.Buffer{})
+	if cap(w.data) != 200000 {
+		t.Errorf("Wrong buffer size: %d, should be: %d", cap(w.data), 200000)
+	}
+}
+
+func TestRandomFileBackedWindow(t *testing.T) {
+	file := &memoryFile{}
+	w := NewWindow(0, nil)
+	//the window is a lot bigger than the smallest buffer of 4kb, so most matches read from the file
+	w.ResetFileBacked(50000, 100, file, 7)
+	if cap(w.data) != minWindowBufferSize {
+		t.Fatalf("Wrong buffer size: %d, should be: %d", cap(w.data), minWindowBufferSize)
+	}
+
+	should := []byte{}
+	for i := 0; i < 10000; i++ {
+		if rand.Intn(2) == 0 || len(should) == 0 {
+			toPush := make([]byte, rand.Intn(3000))
+			rand.Read(toPush)
+			err := w.Push(toPush)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			should = append(should, toPush...)
+		} else {
+			maxOffset := w.Len
+			if len(should) < maxOffset {
+				maxOffset = len(should)
+			}
+			offset := 1 + rand.Intn(maxOffset)
+			n := rand.Intn(6000)
+			err := w.Repeat(n, offset)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			for j := 0; j < n; j++ {
+				should = append(should, should[len(should)-offset])
+			}
+		}
+	}
+
+	if w.Repeat(1, w.Len+1) != ErrCantRepeatBytes {
+		t.Errorf("Repeated from outside of the window")
+	}
+	err := w.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(file.data[7:], should) {
+		t.Errorf("Wrong content written to the file")
+	}
+}
+
+func BenchmarkWindowPush(b *testing.B) {
+	data := make([]byte, 1000)
+	rand.Read(data)
+
+	w := NewWindow(64*1024, &nullDump{})
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := w.Push(data)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkWindowRepeat(b *testing.B) {
+	for _, match := range []struct {
+		name           string
+		length, offset int
+	}{
+		{"Short", 8, 1000},
+		{"Long", 4000, 10000},
+		{"Overlapping", 100, 3},
+	} {
+		b.Run(match.name, func(b *testing.B) {
+			w := NewWindow(64*1024, &nullDump{})
+			history := make([]byte, 64*1024)
+			rand.Read(history)
+			err := w.Push(history)
+			if err != nil {
+				b.Fatal(err.Error())
+			}
+
+			b.SetBytes(int64(match.length))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := w.Repeat(match.length, match.offset)
+				if err != nil {
+					b.Fatal(err.Error())
+				}
+			}
+		})
+	}
+}
+
+//nullDump throws away everything the window writes
+type nullDump struct{}
+
+func (nd *nullDump) Write(data []byte) (int, error) {
+	return len(data), nil
+}
@@ -0,0 +1,488 @@
+package fse
+
+import (
+	"errors"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"math"
+)
+
+//MinAccuracyLog is the smallest accuracy log a table description can have
+const MinAccuracyLog = 5
+
+var ErrAccuracyLogTooSmall = errors.New("The accuracy log is too small for the number of symbols")
+var ErrNoSymbols = errors.New("There are no symbols to encode")
+var ErrTooFewSymbols = errors.New("There are less symbols than streams")
+
+//Histogram counts how often each byte appears in data. The counts are appended to counts[:0] up to the biggest
+//byte that appears, so the result is empty if data is
+func Histogram(counts []int, data []byte) []int {
+	var all [MaxSymbols]int
+	for _, b := range data {
+		all[b]++
+	}
+	maxSymbol := MaxSymbols - 1
+	for maxSymbol >= 0 && all[maxSymbol] == 0 {
+		maxSymbol--
+	}
+	return append(counts[:0], all[:maxSymbol+1]...)
+}
+
+//OptimalAccuracyLog picks the accuracy log for total symbols with the given biggest symbol. Small inputs get small tables
+//because the table description would cost more than the accuracy gains. Ported from FSE_optimalTableLog of https://github.com/facebook/zstd
+func OptimalAccuracyLog(maxAccuracyLog int, total int, maxSymbol int) int {
+	accuracyLog := maxAccuracyLog
+	if total > 1 {
+		maxBitsSrc := int(BIT_highbit32(uint32(total-1))) - 2
+		if maxBitsSrc < accuracyLog {
+			accuracyLog = maxBitsSrc
+		}
+	}
+	if minBits := minAccuracyLog(total, maxSymbol); minBits > accuracyLog {
+		accuracyLog = minBits
+	}
+	if accuracyLog < MinAccuracyLog {
+		accuracyLog = MinAccuracyLog
+	}
+	if accuracyLog > MaxAccuracyLog {
+		accuracyLog = MaxAccuracyLog
+	}
+	return accuracyLog
+}
+
+//minAccuracyLog is the smallest accuracy log that still gives every symbol at least one cell
+func minAccuracyLog(total int, maxSymbol int) int {
+	minBitsSrc := int(BIT_highbit32(uint32(total))) + 1
+	minBitsSymbols := int(BIT_highbit32(uint32(maxSymbol))) + 2
+	if minBitsSrc < minBitsSymbols {
+		return minBitsSrc
+	}
+	return minBitsSymbols
+}
+
+//restToBeat decides if a small probability is rounded up. Rounding up small probabilities is cheaper than rounding up large ones
+var restToBeat = [8]uint64{0, 473195, 504333, 520860, 550000, 700000, 750000, 830000}
+
+//NormalizeCounts scales the counts of the symbols so they add up to 2^accuracyLog and appends the probabilities to dst[:0].
+//Symbols that are too rare for a full cell get the "less than 1" probability -1, which counts as 1.
+//Trailing symbols that do not appear are cut off because the table description does not need them.
+//If only one symbol appears it gets all cells, which costs no bits per symbol. A RLE table is the better choice in that case.
+//Ported from FSE_normalizeCount of https://github.com/facebook/zstd
+func NormalizeCounts(dst []int, counts []int, accuracyLog int) ([]int, error) {
+	dst = dst[:0]
+	if accuracyLog > MaxAccuracyLog {
+		return dst, ErrAccuracyLogTooLarge
+	}
+	if len(counts) > MaxSymbols {
+		return dst, ErrTooManySymbols
+	}
+	total := 0
+	maxSymbol := -1
+	for symbol, count := range counts {
+		total += count
+		if count > 0 {
+			maxSymbol = symbol
+		}
+	}
+	if total == 0 {
+		return dst, ErrNoSymbols
+	}
+	if accuracyLog < MinAccuracyLog || accuracyLog < minAccuracyLog(total, maxSymbol) {
+		return dst, ErrAccuracyLogTooSmall
+	}
+	counts = counts[:maxSymbol+1]
+
+	scale := uint(62 - accuracyLog)
+	step := (uint64(1) << 62) / uint64(total)
+	vStep := uint64(1) << (scale - 20)
+	stillToDistribute := 1 << uint(accuracyLog)
+	lowThreshold := total >> uint(accuracyLog)
+	largest := 0
+	largestProbability := 0
+
+	for symbol, count := range counts {
+		if count == total {
+			//only one symbol appears
+			dst = append(dst[:0], make([]int, maxSymbol+1)...)
+			dst[symbol] = 1 << uint(accuracyLog)
+			return dst, nil
+		}
+		switch {
+		case count == 0:
+			dst = append(dst, 0)
+		case count <= lowThreshold:
+			dst = append(dst, -1)
+			stillToDistribute--
+		default:
+			scaled := uint64(count) * step
+			probability := scaled >> scale
+			if probability < 8 && scaled-(probability<<scale) > vStep*restToBeat[probability] {
+				probability++
+			}
+			if int(probability) > largestProbability {
+				largestProbability = int(probability)
+				largest = symbol
+			}
+			dst = append(dst, int(probability))
+			stillToDistribute -= int(probability)
+		}
+	}
+
+	if -stillToDistribute >= dst[largest]>>1 {
+		//the largest symbol can not take the rounding errors, they have to be spread over all symbols
+		return normalizeSpread(dst, counts, total, accuracyLog)
+	}
+	dst[largest] += stillToDistribute
+	return dst, nil
+}
+
+//normalizeSpread is the fallback of NormalizeCounts for distributions where the rounding errors are too large for the most probable symbol.
+//Ported from FSE_normalizeM2 of https://github.com/facebook/zstd
+func normalizeSpread(dst []int, counts []int, total int, accuracyLog int) ([]int, error) {
+	const notAssigned = -2
+	distributed := 0
+	lowThreshold := total >> uint(accuracyLog)
+	lowOne := (total * 3) >> uint(accuracyLog+1)
+
+	for symbol, count := range counts {
+		switch {
+		case count == 0:
+			dst[symbol] = 0
+		case count <= lowThreshold:
+			dst[symbol] = -1
+			distributed++
+			total -= count
+		case count <= lowOne:
+			dst[symbol] = 1
+			distributed++
+			total -= count
+		default:
+			dst[symbol] = notAssigned
+		}
+	}
+	toDistribute := (1 << uint(accuracyLog)) - distributed
+	if toDistribute == 0 {
+		return dst, nil
+	}
+
+	if total/toDistribute > lowOne {
+		//risk of rounding to zero
+		lowOne = (total * 3) / (toDistribute * 2)
+		for symbol, count := range counts {
+			if dst[symbol] == notAssigned && count <= lowOne {
+				dst[symbol] = 1
+				distributed++
+				total -= count
+			}
+		}
+		toDistribute = (1 << uint(accuracyLog)) - distributed
+	}
+
+	if distributed == len(counts) {
+		//all symbols got a cell, the rest goes to the most probable one
+		largest := 0
+		for symbol, count := range counts {
+			if count > counts[largest] {
+				largest = symbol
+			}
+		}
+		dst[largest] += toDistribute
+		return dst, nil
+	}
+
+	if total == 0 {
+		//all remaining symbols are rare, spread the cells over the symbols that already have some
+		for symbol := 0; toDistribute > 0; symbol = (symbol + 1) % len(counts) {
+			if dst[symbol] > 0 {
+				dst[symbol]++
+				toDistribute--
+			}
+		}
+		return dst, nil
+	}
+
+	vStepLog := uint(62 - accuracyLog)
+	mid := uint64(1)<<(vStepLog-1) - 1
+	rStep := ((uint64(1)<<vStepLog)*uint64(toDistribute) + mid) / uint64(total)
+	tmpTotal := mid
+	for symbol, count := range counts {
+		if dst[symbol] != notAssigned {
+			continue
+		}
+		end := tmpTotal + uint64(count)*rStep
+		weight := int(end>>vStepLog) - int(tmpTotal>>vStepLog)
+		if weight < 1 {
+			return dst, ErrAccuracyLogTooSmall
+		}
+		dst[symbol] = weight
+		tmpTotal = end
+	}
+	return dst, nil
+}
+
+//symbolTransform tells the encoder how many bits a symbol costs in a state and where it finds the next state
+type symbolTransform struct {
+	deltaFindState int32
+	deltaNbBits    uint32 //the bits to write are (state+deltaNbBits)>>16
+}
+
+//FSEEncodingTable is the counterpart of FSETable for the encoder. It is not changed while encoding, so one table can be shared by many FSEEncoderStates.
+//The buffers are reused if the table is built again
+type FSEEncodingTable struct {
+	AccuracyLog   int
+	Probabilities []int //-1 for the "less than 1" probability
+	stateTable    []uint16
+	symbols       []symbolTransform
+}
+
+//NewFSEEncodingTable preallocates the buffers for tables up to the given accuracy log
+func NewFSEEncodingTable(maxAccuracyLog int) *FSEEncodingTable {
+	return &FSEEncodingTable{
+		Probabilities: make([]int, 0, MaxSymbols),
+		stateTable:    make([]uint16, 0, 1<<uint(maxAccuracyLog)),
+		symbols:       make([]symbolTransform, 0, MaxSymbols),
+	}
+}
+
+//NewFSEEncodingTableFromDistribution builds a table from a predefined distribution, so it encodes what NewFSETableFromDistribution decodes
+func NewFSEEncodingTableFromDistribution(distribution []int, accuracyLog int) *FSEEncodingTable {
+	et := &FSEEncodingTable{Probabilities: append([]int{}, distribution...), AccuracyLog: accuracyLog}
+	et.BuildEncodingTable()
+	return et
+}
+
+//InitRLE turns the table into a table with only one symbol. It is encoded with 0 bits, like the table NewRLETable makes decodes it
+func (et *FSEEncodingTable) InitRLE(symbol int) {
+	et.AccuracyLog = 0
+	et.Probabilities = append(et.Probabilities[:0], make([]int, symbol+1)...)
+	et.Probabilities[symbol] = 1
+	et.BuildEncodingTable()
+}
+
+//Normalize normalizes the counts of the symbols to the accuracy log and builds the table for them
+func (et *FSEEncodingTable) Normalize(counts []int, accuracyLog int) error {
+	var err error
+	et.Probabilities, err = NormalizeCounts(et.Probabilities, counts, accuracyLog)
+	if err != nil {
+		return err
+	}
+	et.AccuracyLog = accuracyLog
+	return et.BuildEncodingTable()
+}
+
+//BuildEncodingTable spreads the symbols over the states exactly like BuildDecodingTable does and derives the transitions of the encoder from that.
+//Ported from FSE_buildCTable of https://github.com/facebook/zstd
+func (et *FSEEncodingTable) BuildEncodingTable() error {
+	if len(et.Probabilities) > MaxSymbols {
+		return ErrTooManySymbols
+	}
+	if et.AccuracyLog > MaxAccuracyLog {
+		return ErrAccuracyLogTooLarge
+	}
+	tablesize := 1 << uint(et.AccuracyLog)
+	cells := 0
+	for _, probability := range et.Probabilities {
+		if probability == -1 {
+			cells++
+		} else if probability < -1 {
+			return ErrDidntReadAllProbabilities
+		} else {
+			cells += probability
+		}
+	}
+	if cells != tablesize {
+		return ErrDidntReadAllProbabilities
+	}
+
+	//cumul[symbol] is the first state of the symbol in the state table
+	var cumul [MaxSymbols + 1]int
+	var tableSymbol [1 << MaxAccuracyLog]byte
+	highposition := tablesize - 1
+	for symbol, probability := range et.Probabilities {
+		if probability == -1 {
+			cumul[symbol+1] = cumul[symbol] + 1
+			tableSymbol[highposition] = byte(symbol)
+			highposition--
+		} else {
+			cumul[symbol+1] = cumul[symbol] + probability
+		}
+	}
+
+	position := 0
+	for symbol, probability := range et.Probabilities {
+		for i := 0; i < probability; i++ {
+			tableSymbol[position] = byte(symbol)
+			position += (tablesize >> 1) + (tablesize >> 3) + 3
+			position &= tablesize - 1
+			for position > highposition {
+				position += (tablesize >> 1) + (tablesize >> 3) + 3
+				position &= tablesize - 1
+			}
+		}
+	}
+	if position != 0 {
+		return ErrDidntReadAllProbabilities
+	}
+
+	//the states of a symbol are sorted, the n-th occurence of the symbol in the decoding table decodes to the n-th state
+	if cap(et.stateTable) >= tablesize {
+		et.stateTable = et.stateTable[:tablesize]
+	} else {
+		et.stateTable = make([]uint16, tablesize)
+	}
+	for i := 0; i < tablesize; i++ {
+		symbol := tableSymbol[i]
+		et.stateTable[cumul[symbol]] = uint16(tablesize + i)
+		cumul[symbol]++
+	}
+
+	et.symbols = et.symbols[:0]
+	total := 0
+	accuracyLog := uint32(et.AccuracyLog)
+	for _, probability := range et.Probabilities {
+		transform := symbolTransform{}
+		switch probability {
+		case 0:
+			//never encoded, but the cost estimation should not overflow
+			transform.deltaNbBits = (accuracyLog+1)<<16 - uint32(tablesize)
+		case -1, 1:
+			transform.deltaNbBits = accuracyLog<<16 - uint32(tablesize)
+			transform.deltaFindState = int32(total - 1)
+			total++
+		default:
+			maxBitsOut := accuracyLog - BIT_highbit32(uint32(probability-1))
+			minStatePlus := uint32(probability) << maxBitsOut
+			transform.deltaNbBits = maxBitsOut<<16 - minStatePlus
+			transform.deltaFindState = int32(total - probability)
+			total += probability
+		}
+		et.symbols = append(et.symbols, transform)
+	}
+	return nil
+}
+
+//Cost estimates how many bits the symbols with the counts are encoded with. A symbol costs accuracy log - log2(probability) bits.
+//It returns -1 if a symbol has no probability in the table
+func (et *FSEEncodingTable) Cost(counts []int) int {
+	bits := 0.0
+	for symbol, count := range counts {
+		if count == 0 {
+			continue
+		}
+		if symbol >= len(et.Probabilities) || et.Probabilities[symbol] == 0 {
+			return -1
+		}
+		probability := et.Probabilities[symbol]
+		if probability == -1 {
+			probability = 1
+		}
+		bits += float64(count) * (float64(et.AccuracyLog) - math.Log2(float64(probability)))
+	}
+	return int(math.Ceil(bits))
+}
+
+//AppendTabledescription appends the table description that ReadTabledescription reads back into the same probabilities
+//Ported from FSE_writeNCount of https://github.com/facebook/zstd
+func (et *FSEEncodingTable) AppendTabledescription(dst []byte) []byte {
+	bitdst := bitstream.NewWriter(dst)
+	bitdst.Write(uint64(et.AccuracyLog-MinAccuracyLog), 4)
+
+	tablesize := 1 << uint(et.AccuracyLog)
+	remaining := tablesize + 1
+	threshold := tablesize
+	bits := uint(et.AccuracyLog + 1)
+	previousIsZero := false
+
+	for symbol := 0; symbol < len(et.Probabilities) && remaining > 1; {
+		if previousIsZero {
+			//the two bit flags tell how many more symbols have probability 0. 3 means there are more flags
+			start := symbol
+			for symbol < len(et.Probabilities) && et.Probabilities[symbol] == 0 {
+				symbol++
+			}
+			if symbol == len(et.Probabilities) {
+				break
+			}
+			for symbol >= start+3 {
+				bitdst.Write(3, 2)
+				start += 3
+			}
+			bitdst.Write(uint64(symbol-start), 2)
+		}
+
+		probability := et.Probabilities[symbol]
+		symbol++
+		max := (2*threshold - 1) - remaining
+		if probability < 0 {
+			remaining += probability
+		} else {
+			remaining -= probability
+		}
+		value := probability + 1 //value == probability+1
+		if value >= threshold {
+			value += max
+		}
+		if value < max {
+			//"small" number. The highest bit is not needed
+			bitdst.Write(uint64(value), bits-1)
+		} else {
+			bitdst.Write(uint64(value), bits)
+		}
+		previousIsZero = value == 1
+
+		for remaining < threshold {
+			bits--
+			threshold >>= 1
+		}
+	}
+	return bitdst.Finish()
+}
+
+//FSEEncoderState is the state of one stream that is encoded with a FSEEncodingTable. The symbols are encoded
+//in reverse order, so the decoder reads them in the order of the data
+type FSEEncoderState struct {
+	Table *FSEEncodingTable
+	State uint32
+}
+
+//Init starts the stream with the last symbol. It picks the state that decodes to the symbol with the fewest bits and writes nothing
+func (es *FSEEncoderState) Init(symbol int) {
+	transform := es.Table.symbols[symbol]
+	bits := (transform.deltaNbBits + (1 << 15)) >> 16
+	value := bits<<16 - transform.deltaNbBits
+	es.State = uint32(es.Table.stateTable[int32(value>>bits)+transform.deltaFindState])
+}
+
+//Encode writes the bits the decoder needs to get from the state of symbol to the current state
+func (es *FSEEncoderState) Encode(dst *bitstream.ReverseWriter, symbol int) {
+	transform := es.Table.symbols[symbol]
+	bits := (es.State + transform.deltaNbBits) >> 16
+	dst.Write(uint64(es.State), uint(bits))
+	es.State = uint32(es.Table.stateTable[int32(es.State>>bits)+transform.deltaFindState])
+}
+
+//Flush writes the state, which is the initial state the decoder reads
+func (es *FSEEncoderState) Flush(dst *bitstream.ReverseWriter) {
+	dst.Write(uint64(es.State), uint(es.Table.AccuracyLog))
+}
+
+//EncodeInterleavedFSEStreams is the counterpart of AppendInterleavedFSEStreams. Symbol i is encoded by the state i%len(states)
+//and the stream is appended to dst. There must be at least one symbol per state
+func EncodeInterleavedFSEStreams(dst []byte, states []FSEEncoderState, symbols []byte) ([]byte, error) {
+	if len(symbols) < len(states) || len(states) == 0 {
+		return dst, ErrTooFewSymbols
+	}
+	bitdst := bitstream.NewReverseWriter(dst)
+	for i := len(symbols) - 1; i >= 0; i-- {
+		state := &states[i%len(states)]
+		if i >= len(symbols)-len(states) {
+			state.Init(int(symbols[i]))
+		} else {
+			state.Encode(bitdst, int(symbols[i]))
+		}
+	}
+	//the first state is read first, so it is written last
+	for idx := len(states) - 1; idx >= 0; idx-- {
+		states[idx].Flush(bitdst)
+	}
+	return bitdst.Finish(), nil
+}
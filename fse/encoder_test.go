@@ -0,0 +1,232 @@
+package fse
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"math/rand"
+	"testing"
+)
+
+//randomSymbols returns data with a skewed distribution over the given number of symbols. Some symbols are very rare
+func randomSymbols(n int, symbols int) []byte {
+	weights := make([]float64, symbols)
+	for i := range weights {
+		weights[i] = rand.ExpFloat64() * rand.ExpFloat64()
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(rand.Intn(symbols))
+		if rand.Float64()*4 > weights[data[i]] {
+			data[i] = byte(rand.Intn(1 + symbols/8))
+		}
+	}
+	return data
+}
+
+func TestNormalizeCounts(t *testing.T) {
+	for i := 0; i < 2000; i++ {
+		data := randomSymbols(1+rand.Intn(10000), 1+rand.Intn(MaxSymbols))
+		counts := Histogram(nil, data)
+		accuracyLog := OptimalAccuracyLog(MinAccuracyLog+rand.Intn(MaxAccuracyLog-MinAccuracyLog+1), len(data), len(counts)-1)
+		probabilities, err := NormalizeCounts(nil, counts, accuracyLog)
+		if err != nil {
+			t.Fatalf("%d symbols, accuracy log %d: %s", len(data), accuracyLog, err.Error())
+		}
+		if len(probabilities) != len(counts) {
+			t.Fatalf("Got %d probabilities for %d symbols", len(probabilities), len(counts))
+		}
+		cells := 0
+		for symbol, probability := range probabilities {
+			if (probability == 0) != (counts[symbol] == 0) || probability < -1 {
+				t.Fatalf("Symbol %d appears %d times but got probability %d", symbol, counts[symbol], probability)
+			}
+			if probability == -1 {
+				cells++
+			} else {
+				cells += probability
+			}
+		}
+		if cells != 1<<uint(accuracyLog) {
+			t.Fatalf("The probabilities add up to %d instead of %d", cells, 1<<uint(accuracyLog))
+		}
+	}
+
+	if _, err := NormalizeCounts(nil, []int{0, 0}, 6); err != ErrNoSymbols {
+		t.Errorf("Normalized no symbols: %v", err)
+	}
+	if _, err := NormalizeCounts(nil, make([]int, 64), 5); err != ErrNoSymbols {
+		t.Errorf("Normalized no symbols: %v", err)
+	}
+	counts := make([]int, 100)
+	for i := range counts {
+		counts[i] = 1000
+	}
+	if _, err := NormalizeCounts(nil, counts, 6); err != ErrAccuracyLogTooSmall {
+		t.Errorf("Normalized 100 symbols into 64 cells: %v", err)
+	}
+}
+
+func TestTabledescriptionRoundtrip(t *testing.T) {
+	tables := []*FSEEncodingTable{
+		NewFSEEncodingTableFromDistribution(LiteralLengthDefaultDistributions[:], LiteralLengthDefaultAccuracyLog),
+		NewFSEEncodingTableFromDistribution(MatchLengthDefaultDistribution[:], MatchLengthDefaultAccuracyLog),
+		NewFSEEncodingTableFromDistribution(OffsetDefaultDistribution[:], OffsetDefaultAccuracyLog),
+	}
+	for i := 0; i < 1000; i++ {
+		et := NewFSEEncodingTable(MaxAccuracyLog)
+		data := randomSymbols(1+rand.Intn(5000), 1+rand.Intn(MaxSymbols))
+		err := et.Normalize(Histogram(nil, data), MaxAccuracyLog-rand.Intn(4))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		tables = append(tables, et)
+	}
+
+	fset := NewFSETable(MaxAccuracyLog)
+	for _, et := range tables {
+		prefix := []byte{1, 2, 3}
+		description := et.AppendTabledescription(append([]byte{}, prefix...))
+		if !bytes.Equal(description[:len(prefix)], prefix) {
+			t.Fatal("The prefix was changed")
+		}
+		description = description[len(prefix):]
+
+		//the trailing garbage must not be read
+		n, err := fset.ReadTabledescription(append(description, 0xFF, 0xFF, 0xFF, 0xFF))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n != len(description) {
+			t.Errorf("Wrote %d bytes but %d were read", len(description), n)
+		}
+		if fset.AccuracyLog != et.AccuracyLog || len(fset.Values) != len(et.Probabilities) {
+			t.Fatalf("Read accuracy log %d with %d values, wrote %d with %d", fset.AccuracyLog, len(fset.Values), et.AccuracyLog, len(et.Probabilities))
+		}
+		for symbol, probability := range et.Probabilities {
+			if fset.Values[symbol] != int64(probability+1) {
+				t.Fatalf("Symbol %d: read probability %d instead of %d", symbol, fset.Values[symbol]-1, probability)
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	et := NewFSEEncodingTable(MaxAccuracyLog)
+	fset := NewFSETable(MaxAccuracyLog)
+	for i := 0; i < 1000; i++ {
+		data := randomSymbols(1+rand.Intn(5000), 2+rand.Intn(MaxSymbols-1))
+		counts := Histogram(nil, data)
+		if len(counts) < 2 {
+			continue
+		}
+		err := et.Normalize(counts, OptimalAccuracyLog(MaxAccuracyLog, len(data), len(counts)-1))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		fset.AccuracyLog = et.AccuracyLog
+		fset.Values = fset.Values[:0]
+		for _, probability := range et.Probabilities {
+			fset.Values = append(fset.Values, int64(probability+1))
+		}
+		err = fset.BuildDecodingTable(nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		//one stream with a known number of symbols, like the sequences
+		bw := bitstream.NewReverseWriter(nil)
+		state := FSEEncoderState{Table: et}
+		state.Init(int(data[len(data)-1]))
+		for i := len(data) - 2; i >= 0; i-- {
+			state.Encode(bw, int(data[i]))
+		}
+		state.Flush(bw)
+		src := bw.Finish()
+
+		br := bitstream.NewReverseReader(src)
+		_, err = br.SkipPadding()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		decoder := FSEState{Table: fset}
+		decoder.InitState(br)
+		for i := 0; i < len(data)-1; i++ {
+			if symbol := decoder.DecodeSymbol(br); symbol != int(data[i]) {
+				t.Fatalf("Decoded %d instead of %d at %d", symbol, data[i], i)
+			}
+		}
+		if symbol := decoder.PeekSymbol(); symbol != int(data[len(data)-1]) {
+			t.Fatalf("Decoded %d instead of %d as last symbol", symbol, data[len(data)-1])
+		}
+		if !br.Finished() {
+			t.Fatal("Not all bits were read")
+		}
+	}
+}
+
+func TestInterleavedStreamsRoundtrip(t *testing.T) {
+	//huffman weights are encoded with two interleaved streams. Their biggest alphabet are the 13 weights
+	for i := 0; i < 1000; i++ {
+		data := randomSymbols(2+rand.Intn(255), 13)
+		counts := Histogram(nil, data)
+		if len(counts) < 2 {
+			continue
+		}
+		et := NewFSEEncodingTable(6)
+		err := et.Normalize(counts, OptimalAccuracyLog(6, len(data), len(counts)-1))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if et.Probabilities[data[0]] == 1<<uint(et.AccuracyLog) {
+			continue
+		}
+
+		src, err := EncodeInterleavedFSEStreams(nil, []FSEEncoderState{{Table: et}, {Table: et}}, data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		fset := NewFSETable(6)
+		_, err = fset.ReadTabledescription(et.AppendTabledescription(nil))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = fset.BuildDecodingTable(nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		decoded, _, err := AppendInterleavedFSEStreams(nil, []FSEState{{Table: fset}, {Table: fset}}, src)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("Decoded %v instead of %v", decoded, data)
+		}
+	}
+
+	if _, err := EncodeInterleavedFSEStreams(nil, make([]FSEEncoderState, 2), []byte{1}); err != ErrTooFewSymbols {
+		t.Errorf("Encoded one symbol into two streams: %v", err)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	data := randomSymbols(64*1024, 36)
+	et := NewFSEEncodingTable(MaxAccuracyLog)
+	err := et.Normalize(Histogram(nil, data), 9)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	states := make([]FSEEncoderState, 2)
+	dst := make([]byte, 0, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		states[0] = FSEEncoderState{Table: et}
+		states[1] = FSEEncoderState{Table: et}
+		dst, err = EncodeInterleavedFSEStreams(dst[:0], states, data)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
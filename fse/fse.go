@@ -14,75 +14,113 @@ type FSETableEntry struct {
 	Symbol                 int
 }
 
+//FSETable is not changed while decoding, so one table can be shared by many FSEStates. The buffers are reused if the table is built again
 type FSETable struct {
 	AccuracyLog   int
-	Values        map[int]int64 //note that the probability is the value in this map -1
-	DecodingTable []*FSETableEntry
+	Values        []int64 //note that the probability is the value in this slice -1
+	DecodingTable []FSETableEntry
+}
 
-	//State needed while decoding
-	State int64
+//MaxAccuracyLog is the biggest accuracy log a table can have in the current and the legacy formats
+const MaxAccuracyLog = 12
+
+//MaxSymbols is the maximum number of symbols a table description can have. The biggest alphabet are the 256 huffman weights
+const MaxSymbols = 256
+
+var ErrAccuracyLogTooLarge = errors.New("The accuracy log of the table is too large")
+var ErrTooManySymbols = errors.New("The table description has too many symbols")
+
+//NewFSETable preallocates the buffers for tables up to the given accuracy log
+func NewFSETable(maxAccuracyLog int) *FSETable {
+	return &FSETable{
+		Values:        make([]int64, 0, MaxSymbols),
+		DecodingTable: make([]FSETableEntry, 0, 1<<uint(maxAccuracyLog)),
+	}
+}
+
+//NewFSETableFromDistribution builds a table from a predefined distribution. The probabilities are given directly, -1 for the "less than 1" probability
+func NewFSETableFromDistribution(distribution []int, accuracyLog int, symbolTranslation []int, extraBits []byte) *FSETable {
+	fset := FSETable{Values: make([]int64, len(distribution)), AccuracyLog: accuracyLog}
+	for idx, prob := range distribution {
+		fset.Values[idx] = int64(prob + 1) //value == probability+1
+	}
+
+	fset.BuildDecodingTable(symbolTranslation, extraBits)
+	return &fset
 }
 
-//DecodeBitstream reads the source byte by byte until the table has been built.
+//NewRLETable makes a table that decodes every state to the same symbol without reading any bits
+func NewRLETable(symbol int, extraBits byte) *FSETable {
+	fset := &FSETable{}
+	fset.InitRLE(symbol, extraBits)
+	return fset
+}
+
+//InitRLE turns the table into the same table NewRLETable makes. The buffers are reused
+func (fset *FSETable) InitRLE(symbol int, extraBits byte) {
+	fset.AccuracyLog = 0
+	fset.Values = fset.Values[:0]
+	fset.DecodingTable = append(fset.DecodingTable[:0], FSETableEntry{Symbol: symbol, NumberOfAdditionalBits: extraBits})
+}
+
+//maxTableDescriptionSize is more than a table description can take up: 256 values with at most 14 bits plus the bits for the zeros
+const maxTableDescriptionSize = 1024
+
+//ReadTabledescriptionFromBitstream peeks the bytes of the table description from the source and discards only what was used.
 //It will report back any error and the number of bytes taken out of the reader
 func (fset *FSETable) ReadTabledescriptionFromBitstream(source *bufio.Reader) (int, error) {
-	fset.Values = make(map[int]int64)
-
-	bitsrc := bitstream.NewBitstream(source)
-	acclog, err := bitsrc.Read(4)
-	if err != nil {
+	data, err := source.Peek(maxTableDescriptionSize)
+	if len(data) == 0 {
 		return 0, err
 	}
+	bytesRead, err := fset.ReadTabledescription(data)
+	_, discardErr := source.Discard(bytesRead)
+	if err == nil {
+		err = discardErr
+	}
+	return bytesRead, err
+}
 
-	fset.AccuracyLog = int(acclog) + 5
+//ReadTabledescription reads the table description from the start of src.
+//It will report back any error and the number of bytes used
+func (fset *FSETable) ReadTabledescription(src []byte) (int, error) {
+	fset.Values = fset.Values[:0]
 
-	sumOfProbabilities := int64(1) << uint(fset.AccuracyLog) //2^accuracylog
-	remaining := sumOfProbabilities
+	bitsrc := bitstream.NewReader(src)
+	fset.AccuracyLog = int(bitsrc.Read(4)) + 5
+	if fset.AccuracyLog > MaxAccuracyLog {
+		return 1, ErrAccuracyLogTooLarge
+	}
 
-	bitsRead := 4
-	currentSymbol := 0
+	remaining := int64(1) << uint(fset.AccuracyLog) //2^accuracylog
 
 	for remaining > 0 {
-		BitsNeeded := BIT_highbit32(uint32(remaining+1)) + 1
-
-		v, err := bitsrc.Read(int(BitsNeeded))
-		bitsRead += int(BitsNeeded)
-		if err != nil {
-			bytesRead := bitsRead / 8
-			if bitsRead%8 != 0 {
-				bytesRead++
-			}
-			return bytesRead, err
+		if bitsrc.Overread() {
+			return len(src), io.ErrUnexpectedEOF
 		}
-		value := uint16(v)
+		bitsrc.Refill()
 
-		//print(currentSymbol)
-		//print(", ")
-		//println(value)
+		BitsNeeded := uint(BIT_highbit32(uint32(remaining+1)) + 1)
+		value := uint16(bitsrc.Peek(BitsNeeded))
 
 		lowermask := (uint16(1) << (BitsNeeded - 1)) - 1
 		thresh := (uint16(1) << (BitsNeeded)) - 1 - uint16((remaining + 1))
 
 		if (value & lowermask) < thresh {
-			// "small" number. Unwind last bit read
-			err := bitsrc.UnwindBit()
-			if err != nil {
-				bytesRead := bitsRead / 8
-				if bitsRead%8 != 0 {
-					bytesRead++
-				}
-				return bytesRead, err
-			}
-			bitsRead--
+			// "small" number. The highest bit is not part of the value
+			bitsrc.Consume(BitsNeeded - 1)
 			value = value & lowermask
 		} else {
+			bitsrc.Consume(BitsNeeded)
 			if value > lowermask {
 				value = value - thresh
 			}
 		}
 
-		fset.Values[currentSymbol] = int64(value)
-		currentSymbol++
+		if len(fset.Values) >= MaxSymbols {
+			return bitsrc.BytesRead(), ErrTooManySymbols
+		}
+		fset.Values = append(fset.Values, int64(value))
 
 		probabilitiy := int(value) - 1
 
@@ -98,35 +136,30 @@ func (fset *FSETable) ReadTabledescriptionFromBitstream(source *bufio.Reader) (i
 			skip := uint64(3)
 
 			for skip == 3 {
-				skip, err = bitsrc.Read(2)
-				bitsRead += 2
-				if err != nil {
-					bytesRead := bitsRead / 8
-					if bitsRead%8 != 0 {
-						bytesRead++
-					}
-					return bytesRead, err
+				skip = bitsrc.Read(2)
+				if bitsrc.Overread() {
+					return len(src), io.ErrUnexpectedEOF
 				}
 
 				for i := uint64(0); i < skip; i++ {
+					if len(fset.Values) >= MaxSymbols {
+						return bitsrc.BytesRead(), ErrTooManySymbols
+					}
 					//does not count into remaining because probability == 0
-					fset.Values[currentSymbol] = 1 //values = probability+1!
-					currentSymbol++
+					fset.Values = append(fset.Values, 1) //values = probability+1!
 				}
 			}
 		}
 	}
 
-	bytesRead := bitsRead / 8
-	if bitsRead%8 != 0 {
-		bytesRead++
+	if bitsrc.Overread() {
+		return len(src), io.ErrUnexpectedEOF
 	}
-
 	if remaining != 0 {
-		return bytesRead, ErrDidntReadAllProbabilities
+		return bitsrc.BytesRead(), ErrDidntReadAllProbabilities
 	}
 
-	return bytesRead, nil
+	return bitsrc.BytesRead(), nil
 }
 
 var ErrDidntReadAllProbabilities = errors.New("The probabilities didnt add up to the expected total sum")
@@ -134,19 +167,47 @@ var ErrDidntReadAllProbabilities = errors.New("The probabilities didnt add up to
 //BuildDecodingTable more or less is oriented on the implementation in https://github.com/facebook/zstd
 // symbolTranslation may be nil. Then the symbols will just not be translated
 func (fset *FSETable) BuildDecodingTable(symbolTranslation []int, extraBits []byte) error {
-
-	symbolNext := make([]int, len(fset.Values))
+	if len(fset.Values) > MaxSymbols {
+		return ErrTooManySymbols
+	}
+	if fset.AccuracyLog > MaxAccuracyLog {
+		return ErrAccuracyLogTooLarge
+	}
+	var symbolNext [MaxSymbols]int
 
 	tablesize := 1 << uint(fset.AccuracyLog)
+	cells := int64(0)
+	for _, value := range fset.Values {
+		if value == 0 {
+			cells++
+		} else {
+			cells += value - 1
+		}
+	}
+	if cells != int64(tablesize) {
+		return ErrDidntReadAllProbabilities
+	}
+
 	highposition := tablesize - 1
 
-	fset.DecodingTable = make([]*FSETableEntry, tablesize)
+	if cap(fset.DecodingTable) >= tablesize {
+		fset.DecodingTable = fset.DecodingTable[:tablesize]
+	} else {
+		fset.DecodingTable = make([]FSETableEntry, tablesize)
+	}
+	//cells are marked as free with a negative symbol while placing the symbols
+	for i := range fset.DecodingTable {
+		fset.DecodingTable[i] = FSETableEntry{Symbol: -1}
+	}
 
 	//first find all symbols with a -1 probability
 	for symbol := 0; symbol < len(fset.Values); symbol++ {
 		probability := fset.Values[symbol] - 1
 		if probability == -1 {
-			fset.DecodingTable[highposition] = &FSETableEntry{Symbol: symbol}
+			if highposition < 0 {
+				return ErrDidntReadAllProbabilities
+			}
+			fset.DecodingTable[highposition] = FSETableEntry{Symbol: symbol}
 			highposition--
 			symbolNext[symbol] = 1 //full reset on these symbols
 		} else {
@@ -163,12 +224,12 @@ func (fset *FSETable) BuildDecodingTable(symbolTranslation []int, extraBits []by
 		if probability > 0 {
 			//allocate probability many cells to this symbol
 			for i := int64(0); i < probability; i++ {
-				if fset.DecodingTable[position] != nil {
-					//keeping. This is an programming error
-					panic("Overwriting should never happen")
+				if fset.DecodingTable[position].Symbol >= 0 {
+					//the probabilities did not add up to the table size
+					return ErrDidntReadAllProbabilities
 				}
 
-				fset.DecodingTable[position] = &FSETableEntry{Symbol: symbol}
+				fset.DecodingTable[position] = FSETableEntry{Symbol: symbol}
 
 				//weird jumping around
 				position += (tablesize >> 1) + (tablesize >> 3) + 3
@@ -184,14 +245,16 @@ func (fset *FSETable) BuildDecodingTable(symbolTranslation []int, extraBits []by
 	}
 
 	if position != 0 {
-		//keeping. This is an programming error
-		panic("Position did not end up at 0")
+		return ErrDidntReadAllProbabilities
 	}
 
 	//ported from https://github.com/facebook/zstd
 	for i := 0; i < tablesize; i++ {
-		entry := fset.DecodingTable[i]
+		entry := &fset.DecodingTable[i]
 		symbol := entry.Symbol
+		if symbol < 0 {
+			return ErrDidntReadAllProbabilities
+		}
 		nextState := uint32(symbolNext[symbol])
 		symbolNext[symbol]++
 
@@ -209,15 +272,15 @@ func (fset *FSETable) BuildDecodingTable(symbolTranslation []int, extraBits []by
 		// iterate over these.
 		// TODO make an implementation that does the "umoptimized way". This could be way more readable and with clever
 		// use of maps this shouldnt be too bad performance wise.
-		fset.DecodingTable[i].NumberOfBits = byte(uint32(fset.AccuracyLog) - BIT_highbit32(uint32(nextState)))
-		fset.DecodingTable[i].Baseline = uint16((nextState << fset.DecodingTable[i].NumberOfBits) - uint32(tablesize))
+		entry.NumberOfBits = byte(uint32(fset.AccuracyLog) - BIT_highbit32(uint32(nextState)))
+		entry.Baseline = uint16((nextState << entry.NumberOfBits) - uint32(tablesize))
 
 		//only do translation if necessary. Offsets dont need to
 		if len(symbolTranslation) > symbol {
-			fset.DecodingTable[i].Symbol = symbolTranslation[symbol] //translate the symbols in the end to the real ones
+			entry.Symbol = symbolTranslation[symbol] //translate the symbols in the end to the real ones
 		}
 		if len(extraBits) > symbol {
-			fset.DecodingTable[i].NumberOfAdditionalBits = extraBits[symbol] //extra bits needed for decoding the sequences
+			entry.NumberOfAdditionalBits = extraBits[symbol] //extra bits needed for decoding the sequences
 		}
 
 		//print("State: ")
@@ -248,143 +311,102 @@ func BIT_highbit32(value uint32) uint32 {
 	return DeBruijnClz[uint32(uint64(v)*uint64(0x07C4ACDD))>>27]
 }
 
-//InitState reads the inital state from the bitstream
-//returns the number of bits read
-func (fset *FSETable) InitState(src *bitstream.Reversebitstream) (int, error) {
-	state, err := src.Read(fset.AccuracyLog)
-	fset.State = int64(state)
-	return fset.AccuracyLog, err
+//FSEState is the state of one stream that is decoded with a FSETable
+type FSEState struct {
+	Table *FSETable
+	State int64
 }
 
-var ErrNoSymbolForState = errors.New("Probably a bad state?")
-
-func (fset *FSETable) GetAdditionalBits() int {
-	return int(fset.DecodingTable[fset.State].NumberOfAdditionalBits)
-}
-func (fset *FSETable) GetNumberOfBits() (int, error) {
-	return int(fset.DecodingTable[fset.State].NumberOfBits), nil
+//InitState reads the inital state from the bitstream
+func (fs *FSEState) InitState(src *bitstream.ReverseReader) {
+	fs.State = int64(src.Read(uint(fs.Table.AccuracyLog)))
 }
-func (fset *FSETable) GetState() int {
-	return int(fset.State)
+
+//Entry returns the entry of the table for the current state
+func (fs *FSEState) Entry() *FSETableEntry {
+	return &fs.Table.DecodingTable[fs.State]
 }
 
-//PeekSymbol returns the symbol the current state decodes to, without advancing the state
-func (fset *FSETable) PeekSymbol() (int, error) {
-	if fset.State > int64(len(fset.DecodingTable)) {
-		return 0, ErrNoSymbolForState
-	}
+func (fs *FSEState) GetAdditionalBits() int {
+	return int(fs.Table.DecodingTable[fs.State].NumberOfAdditionalBits)
+}
 
-	return fset.DecodingTable[fset.State].Symbol, nil
+//PeekSymbol returns the symbol the current state decodes to, without advancing the state.
+//The state is always inside the table because the baselines and bits of the entries are built that way
+func (fs *FSEState) PeekSymbol() int {
+	return fs.Table.DecodingTable[fs.State].Symbol
 }
 
 //NextState reads bits from the stream to determin the next state
-//returns the number of bits read
-func (fset *FSETable) NextState(src *bitstream.Reversebitstream) (int, error) {
-	bitsNeeded := fset.DecodingTable[fset.State].NumberOfBits
-	baseLine := fset.DecodingTable[fset.State].Baseline
-	add, err := src.Read(int(bitsNeeded))
-	if err == nil {
-		fset.State = int64(baseLine) + int64(add)
-	}
-	return int(bitsNeeded), err
+func (fs *FSEState) NextState(src *bitstream.ReverseReader) {
+	entry := &fs.Table.DecodingTable[fs.State]
+	fs.State = int64(entry.Baseline) + int64(src.Read(uint(entry.NumberOfBits)))
 }
 
 //DecodeSymbol Combines PeekSymbol and NextState
-func (fset *FSETable) DecodeSymbol(src *bitstream.Reversebitstream) (symbol int, bitsRead int, err error) {
-	symbol, err = fset.PeekSymbol()
-	if err != nil {
-		return
-	}
-
-	bitsRead, err = fset.NextState(src)
-	return
+func (fs *FSEState) DecodeSymbol(src *bitstream.ReverseReader) int {
+	symbol := fs.PeekSymbol()
+	fs.NextState(src)
+	return symbol
 }
 
 var ErrBadPadding = errors.New("The padding at the end of the stream was more than a byte. Data is likely corrupted")
 
-// DecodeInterleavedFSEStreams intializes the states for each table in the order of the slice and
+// DecodeInterleavedFSEStreams intializes the states in the order of the slice and
 // then decodes values in a round robin fashion
-func DecodeInterleavedFSEStreams(decodingTables []*FSETable, src []byte, target io.Writer) (int, error) {
-	bitsRead := 0
-	bitsrc := bitstream.NewReversebitstream(src)
-	var err error
-
-	//need to read bits from the stream (the back of the data...) until the first 1 arrives
-	x := uint64(0)
-	for x == 0 {
-		x, err = bitsrc.Read(1)
-		if err != nil {
-			return bitsRead, err
-		}
-		bitsRead++
+func DecodeInterleavedFSEStreams(decodingTables []*FSEState, src []byte, target io.Writer) (int, error) {
+	states := make([]FSEState, len(decodingTables))
+	for idx, dt := range decodingTables {
+		states[idx] = *dt
 	}
-
-	if bitsRead > 8 {
-		return bitsRead, ErrBadPadding
+	decoded, bitsRead, err := AppendInterleavedFSEStreams(nil, states, src)
+	for idx, dt := range decodingTables {
+		*dt = states[idx]
+	}
+	if err != nil {
+		return bitsRead, err
 	}
 
-	//print("Padding: ")
-	//println(bitsRead)
-
-	for _, dt := range decodingTables {
-		read, err := dt.InitState(bitsrc)
-		bitsRead += read
+	written := 0
+	for written < len(decoded) {
+		w, err := target.Write(decoded[written:])
+		written += w
 		if err != nil {
 			return bitsRead, err
 		}
 	}
+	return bitsRead, nil
+}
 
-	var buf [1]byte
+//AppendInterleavedFSEStreams works like DecodeInterleavedFSEStreams but appends the symbols to target, so a reused
+//buffer can be decoded into without allocating
+func AppendInterleavedFSEStreams(target []byte, states []FSEState, src []byte) ([]byte, int, error) {
+	var bitsrc bitstream.ReverseReader
+	bitsrc.Init(src)
 
-	shouldFinish := false
-	//loop until the end of the stream is reached
-	for !shouldFinish {
-		for idx, dt := range decodingTables {
-			symbol, read, err := dt.DecodeSymbol(bitsrc)
-			if err != nil {
-				return bitsRead, err
-			}
-			bitsRead += read
-			buf[0] = byte(symbol)
-
-			w := 0
-			for w == 0 {
-				w, err = target.Write(buf[:])
-				if err != nil {
-					return bitsRead, err
-				}
-			}
+	//need to skip bits from the stream (the back of the data...) until the first 1 arrives
+	_, err := bitsrc.SkipPadding()
+	if err != nil {
+		return target, 0, ErrBadPadding
+	}
 
-			//print(symbol)
-			//print(", ")
-			//println(bitsrc.BitsStillInStream() + 1)
+	for idx := range states {
+		states[idx].InitState(&bitsrc)
+	}
+
+	//loop until the end of the stream is reached
+	for {
+		for idx := range states {
+			target = append(target, byte(states[idx].DecodeSymbol(&bitsrc)))
 
 			if bitsrc.BitsStillInStream() < -1 {
-				//collect all streams last symbol and exit outer loop
-				for i := 1; i < len(decodingTables); i++ {
-					peekIdx := (i + idx) % len(decodingTables)
-					symbol, err := decodingTables[peekIdx].PeekSymbol()
-					if err != nil {
-						return bitsRead, err
-					}
-					buf[0] = byte(symbol)
-
-					//print(symbol)
-					//print(", ")
-					//println(bitsrc.BitsStillInStream() + 1)
-
-					w := 0
-					for w == 0 {
-						w, err = target.Write(buf[:])
-						if err != nil {
-							return bitsRead, err
-						}
-					}
+				//collect all streams last symbol and exit
+				for i := 1; i < len(states); i++ {
+					peekIdx := (i + idx) % len(states)
+					target = append(target, byte(states[peekIdx].PeekSymbol()))
 				}
-				shouldFinish = true
-				break
+				return target, len(src)*8 - bitsrc.BitsStillInStream() - 1, nil
 			}
 		}
 	}
-	return bitsRead, nil
 }
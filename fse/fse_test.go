@@ -1,6 +1,7 @@
 package fse
 
 import (
+	"math/rand"
 	"testing"
 )
 
@@ -41,12 +42,12 @@ var expectedLLDecodingTable = [64]FSETableEntry{
 }
 
 func TestBuilding(t *testing.T) {
-	fset := FSETable{Values: make(map[int]int64), AccuracyLog: LiteralLengthDefaultAccuracyLog}
+	fset := FSETable{Values: make([]int64, len(LiteralLengthDefaultDistributions)), AccuracyLog: LiteralLengthDefaultAccuracyLog}
 	for idx, prob := range LiteralLengthDefaultDistributions {
 		fset.Values[idx] = int64(prob + 1) //value == probability+1
 	}
 
-	fset.BuildDecodingTable(llBaseValueTranslation)
+	fset.BuildDecodingTable(LiteralLengthBaseValueTranslation[:], LiteralLengthExtraBits[:])
 
 	println("IDX\tSymbol\tnbBits\tBase\tnbAdd")
 
@@ -63,6 +64,12 @@ func TestBuilding(t *testing.T) {
 		}
 	}
 
+	for idx, entry := range fset.DecodingTable {
+		if PredefinedLiteralLengthsTable.DecodingTable[idx] != entry {
+			t.Errorf("Predefined table didnt match at index: %d", idx)
+		}
+	}
+
 	//pretty print the decoding table similarly to the table in the doc for human readable checking of values
 	//for idx := 0; idx < len(fset.DecodingTable); idx++ {
 	//	entry := fset.DecodingTable[idx]
@@ -80,3 +87,42 @@ func TestBuilding(t *testing.T) {
 	//}
 	//panic("a")
 }
+
+func BenchmarkBuildDecodingTable(b *testing.B) {
+	fset := NewFSETable(LiteralLengthDefaultAccuracyLog)
+	for _, prob := range LiteralLengthDefaultDistributions {
+		fset.Values = append(fset.Values, int64(prob+1))
+	}
+	fset.AccuracyLog = LiteralLengthDefaultAccuracyLog
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := fset.BuildDecodingTable(LiteralLengthBaseValueTranslation[:], LiteralLengthExtraBits[:])
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkInterleavedStreams(b *testing.B) {
+	//every bitstream is a valid fse stream, it only needs the padding in the last byte
+	src := make([]byte, 16*1024)
+	rand.Read(src)
+	src[len(src)-1] |= 0x80
+
+	states := make([]FSEState, 2)
+	target := make([]byte, 0, 64*1024)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		states[0] = FSEState{Table: PredefinedOffsetTable}
+		states[1] = FSEState{Table: PredefinedOffsetTable}
+		var err error
+		target, _, err = AppendInterleavedFSEStreams(target[:0], states, src)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
@@ -20,13 +20,7 @@ var LiteralLengthExtraBits = [36]byte{
 	1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
 
 func BuildLiteralLengthsTable() *FSETable {
-	fset := FSETable{Values: make(map[int]int64), AccuracyLog: LiteralLengthDefaultAccuracyLog}
-	for idx, prob := range LiteralLengthDefaultDistributions {
-		fset.Values[idx] = int64(prob + 1) //value == probability+1
-	}
-
-	fset.BuildDecodingTable(LiteralLengthBaseValueTranslation[:], LiteralLengthExtraBits[:])
-	return &fset
+	return NewFSETableFromDistribution(LiteralLengthDefaultDistributions[:], LiteralLengthDefaultAccuracyLog, LiteralLengthBaseValueTranslation[:], LiteralLengthExtraBits[:])
 }
 
 //#####
@@ -50,13 +44,7 @@ var MatchLengthsExtraBits = [53]byte{
 	2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
 
 func BuildMatchLengthsTable() *FSETable {
-	fset := FSETable{Values: make(map[int]int64), AccuracyLog: MatchLengthDefaultAccuracyLog}
-	for idx, prob := range MatchLengthDefaultDistribution {
-		fset.Values[idx] = int64(prob + 1) //value == probability+1
-	}
-
-	fset.BuildDecodingTable(MatchLengthBaseValueTranslation[:], MatchLengthsExtraBits[:])
-	return &fset
+	return NewFSETableFromDistribution(MatchLengthDefaultDistribution[:], MatchLengthDefaultAccuracyLog, MatchLengthBaseValueTranslation[:], MatchLengthsExtraBits[:])
 }
 
 //######
@@ -68,11 +56,19 @@ var OffsetDefaultDistribution = [29]int{
 	1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
 
 func BuildOffsetTable() *FSETable {
-	fset := FSETable{Values: make(map[int]int64), AccuracyLog: OffsetDefaultAccuracyLog}
-	for idx, prob := range OffsetDefaultDistribution {
-		fset.Values[idx] = int64(prob + 1) //value == probability+1
-	}
-
-	fset.BuildDecodingTable(nil, nil)
-	return &fset
+	return NewFSETableFromDistribution(OffsetDefaultDistribution[:], OffsetDefaultAccuracyLog, nil, nil)
 }
+
+//The predefined tables are built once and shared by all decoders. They must not be changed
+var (
+	PredefinedLiteralLengthsTable = BuildLiteralLengthsTable()
+	PredefinedMatchLengthsTable   = BuildMatchLengthsTable()
+	PredefinedOffsetTable         = BuildOffsetTable()
+)
+
+//The predefined encoding tables encode what the predefined tables decode. They are shared by all encoders and must not be changed
+var (
+	PredefinedLiteralLengthsEncodingTable = NewFSEEncodingTableFromDistribution(LiteralLengthDefaultDistributions[:], LiteralLengthDefaultAccuracyLog)
+	PredefinedMatchLengthsEncodingTable   = NewFSEEncodingTableFromDistribution(MatchLengthDefaultDistribution[:], MatchLengthDefaultAccuracyLog)
+	PredefinedOffsetEncodingTable         = NewFSEEncodingTableFromDistribution(OffsetDefaultDistribution[:], OffsetDefaultAccuracyLog)
+)
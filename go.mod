@@ -1 +1,3 @@
 module github.com/killingspark/sparkzstd
+
+go 1.20
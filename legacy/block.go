@@ -0,0 +1,52 @@
+package legacy
+
+import (
+	"errors"
+)
+
+type BlockType byte
+
+const (
+	BlockTypeCompressed = BlockType(0)
+	BlockTypeRaw        = BlockType(1)
+	BlockTypeRLE        = BlockType(2)
+	BlockTypeEnd        = BlockType(3)
+)
+
+//BlockHeader of a legacy block. Other than in the current format the last block is a separate (empty) end block
+type BlockHeader struct {
+	Type BlockType
+
+	//for RLE blocks this is the regenerated size, the block itself only contains one byte
+	BlockSize int
+}
+
+var ErrNotEnoughBytesForBlockHeader = errors.New("Not enough / too much bytes to decode the legacy blockheader. Must be 3.")
+var ErrIllegalBlockSize = errors.New("Illegal block-size. Must be lower than 128kb")
+var ErrRLEBlocksNotSupported = errors.New("RLE blocks are not supported before v0.7")
+
+//DecodeHeader takes the 3 raw bytes and fills the header
+func (bh *BlockHeader) DecodeHeader(v Version, raw []byte) error {
+	if len(raw) != 3 {
+		return ErrNotEnoughBytesForBlockHeader
+	}
+
+	//big endian, the two highest bits are the type
+	bh.Type = BlockType(raw[0] >> 6)
+	bh.BlockSize = int(raw[2]) + int(raw[1])<<8 + int(raw[0]&7)<<16
+
+	switch bh.Type {
+	case BlockTypeEnd:
+		//the size bits may hold a checksum in v0.7. There is no content.
+		bh.BlockSize = 0
+	case BlockTypeRLE:
+		if v < Version07 {
+			return ErrRLEBlocksNotSupported
+		}
+	}
+
+	if bh.BlockSize > MaxBlockSize {
+		return ErrIllegalBlockSize
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package legacy
+
+import (
+	"github.com/killingspark/sparkzstd/fse"
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+//Sequence is a decoded legacy sequence. Other than structure.Sequence the Offset is already resolved
+//into the actual distance, because every legacy version has its own rules for repeated offsets
+type Sequence struct {
+	LiteralLength int
+	MatchLength   int
+	Offset        int
+}
+
+//BlockDecoder decodes the content of compressed legacy blocks. It keeps the state that is carried over
+//from one block to the next inside of a frame (tables and offset history)
+type BlockDecoder struct {
+	Version Version
+
+	//results of the last DecodeBlock. Only valid until the next call
+	Literals  []byte
+	Sequences []Sequence
+
+	huffmanTable *structure.HuffmanDecodingTable
+	llTable      *fse.FSETable
+	mlTable      *fse.FSETable
+	ofTable      *fse.FSETable
+
+	//states of the three interleaved fse streams while decoding sequences
+	llState fse.FSEState
+	mlState fse.FSEState
+	ofState fse.FSEState
+
+	offsetHistory [3]int
+
+	literalsBuf []byte //grows up to MaxBlockSize as needed and is kept for the following blocks
+}
+
+//NewBlockDecoder makes a BlockDecoder for a frame of the given version
+func NewBlockDecoder(v Version) *BlockDecoder {
+	bd := &BlockDecoder{}
+	bd.Reset(v)
+	return bd
+}
+
+//Reset prepares the BlockDecoder for a new frame
+func (bd *BlockDecoder) Reset(v Version) {
+	bd.Version = v
+	bd.Literals = nil
+	bd.Sequences = bd.Sequences[:0]
+	bd.huffmanTable = nil
+	bd.llTable = nil
+	bd.mlTable = nil
+	bd.ofTable = nil
+	bd.offsetHistory = [3]int{1, 4, 8}
+}
+
+//DecodeBlock decodes the literals and sequences of a compressed block. src must contain exactly the block content
+func (bd *BlockDecoder) DecodeBlock(src []byte) error {
+	bd.Sequences = bd.Sequences[:0]
+
+	bytesUsedByLiterals, err := bd.decodeLiterals(src)
+	if err != nil {
+		return err
+	}
+
+	return bd.decodeSequences(src[bytesUsedByLiterals:])
+}
+
+//literalsSlice returns a slice of n bytes from literalsBuf, growing it if needed
+func (bd *BlockDecoder) literalsSlice(n int) []byte {
+	if cap(bd.literalsBuf) < n {
+		bd.literalsBuf = make([]byte, n)
+	}
+	return bd.literalsBuf[:n]
+}
+
+//BufferMemory is the number of bytes currently held in buffers by the BlockDecoder. Decoding tables are not counted
+func (bd *BlockDecoder) BufferMemory() int {
+	return cap(bd.literalsBuf)
+}
@@ -0,0 +1,123 @@
+package legacy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//FrameHeader holds the information of a legacy frame header. The magic number is not part of it.
+type FrameHeader struct {
+	Version          Version
+	WindowSize       uint64
+	FrameContentSize uint64 //0 if unknown
+	DictionaryID     uint32 //only v0.7
+	ChecksumFlag     bool   //only v0.7. The checksum is not verified
+}
+
+var ErrReservedBitsSet = errors.New("Reserved bits in the legacy frame header are set")
+var ErrWindowTooLarge = errors.New("The window of the legacy frame is too large")
+
+const maxWindowLog = 27
+
+//DecodeFrameHeader reads the frame header from the source. The magic number must have been read already.
+func (fh *FrameHeader) DecodeFrameHeader(v Version, source io.Reader) error {
+	var buf [14]byte
+
+	*fh = FrameHeader{Version: v}
+
+	_, err := io.ReadFull(source, buf[:1])
+	if err != nil {
+		return err
+	}
+	descriptor := buf[0]
+
+	switch v {
+	case Version05:
+		if descriptor>>4 != 0 {
+			return ErrReservedBitsSet
+		}
+		fh.WindowSize = 1 << uint((descriptor&0xF)+11)
+		return nil
+
+	case Version06:
+		if descriptor&0x20 != 0 {
+			return ErrReservedBitsSet
+		}
+		fh.WindowSize = 1 << uint((descriptor&0xF)+12)
+
+		fcsSize := [4]int{0, 1, 2, 8}[descriptor>>6]
+		_, err = io.ReadFull(source, buf[:fcsSize])
+		if err != nil {
+			return err
+		}
+		fh.FrameContentSize = decodeContentSize(buf[:fcsSize])
+		return nil
+
+	case Version07:
+		if descriptor&0x08 != 0 {
+			return ErrReservedBitsSet
+		}
+		directMode := descriptor&0x20 != 0
+		fh.ChecksumFlag = descriptor&0x04 != 0
+
+		windowDescriptorSize := 1
+		if directMode {
+			windowDescriptorSize = 0
+		}
+		dictIDSize := [4]int{0, 1, 2, 4}[descriptor&3]
+		fcsSize := [4]int{0, 2, 4, 8}[descriptor>>6]
+		if directMode && fcsSize == 0 {
+			fcsSize = 1
+		}
+
+		headersize := windowDescriptorSize + dictIDSize + fcsSize
+		_, err = io.ReadFull(source, buf[:headersize])
+		if err != nil {
+			return err
+		}
+		raw := buf[:headersize]
+
+		if !directMode {
+			windowLog := uint(raw[0]>>3) + 10
+			if windowLog > maxWindowLog {
+				return ErrWindowTooLarge
+			}
+			fh.WindowSize = 1 << windowLog
+			fh.WindowSize += (fh.WindowSize >> 3) * uint64(raw[0]&7)
+			raw = raw[1:]
+		}
+
+		for i := 0; i < dictIDSize; i++ {
+			fh.DictionaryID += uint32(raw[i]) << uint(8*i)
+		}
+		raw = raw[dictIDSize:]
+
+		fh.FrameContentSize = decodeContentSize(raw[:fcsSize])
+
+		if fh.WindowSize == 0 {
+			fh.WindowSize = fh.FrameContentSize
+		}
+		if fh.WindowSize > 1<<maxWindowLog {
+			return ErrWindowTooLarge
+		}
+		return nil
+	}
+
+	return ErrUnknownVersion
+}
+
+//decodeContentSize reads the little endian field. The two byte variant has an offset of 256 in v0.6 and v0.7
+func decodeContentSize(raw []byte) uint64 {
+	switch len(raw) {
+	case 1:
+		return uint64(raw[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(raw)) + 256
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(raw))
+	case 8:
+		return binary.LittleEndian.Uint64(raw)
+	}
+	return 0
+}
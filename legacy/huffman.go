@@ -0,0 +1,163 @@
+package legacy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"github.com/killingspark/sparkzstd/fse"
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+//the last weight is implied, so there can be at most 255 explicit weights
+const maxHuffmanWeights = 255
+
+//weights have at most 4 bits. Everything bigger is certainly corrupted
+const maxWeightsAccuracyLog = 12
+
+//headers >= 242 describe a tree where all weights are 1. The header selects the number of weights
+var rleWeightCounts = [14]int{1, 2, 3, 4, 7, 8, 15, 16, 31, 32, 63, 64, 127, 128}
+
+var ErrCorruptedHuffmanTree = errors.New("The legacy huffman tree description is corrupted")
+
+//readHuffmanTable decodes the tree description at the beginning of src and builds the decoding table.
+//It returns the number of bytes used by the description.
+func readHuffmanTable(v Version, src []byte) (*structure.HuffmanDecodingTable, int, error) {
+	weights, bytesUsed, err := readHuffmanWeights(v, src)
+	if err != nil {
+		return nil, bytesUsed, err
+	}
+
+	htd := structure.HuffmanTreeDesc{Weights: weights}
+	table, err := htd.Build()
+	if err != nil {
+		return nil, bytesUsed, err
+	}
+	return table, bytesUsed, nil
+}
+
+func readHuffmanWeights(v Version, src []byte) ([]byte, int, error) {
+	if len(src) == 0 {
+		return nil, 0, ErrCorruptedHuffmanTree
+	}
+	header := int(src[0])
+
+	switch {
+	case header >= 242:
+		weights := make([]byte, rleWeightCounts[header-242])
+		for i := range weights {
+			weights[i] = 1
+		}
+		return weights, 1, nil
+
+	case header >= 128:
+		//direct representation, 4 bits per weight
+		weights := make([]byte, header-127)
+		bytesUsed := 1 + (len(weights)+1)/2
+		if bytesUsed > len(src) {
+			return nil, 1, ErrCorruptedHuffmanTree
+		}
+		for i := range weights {
+			if i%2 == 0 {
+				weights[i] = src[1+i/2] >> 4
+			} else {
+				weights[i] = src[1+i/2] & 0xF
+			}
+		}
+		return weights, bytesUsed, nil
+
+	default:
+		//fse compressed weights
+		bytesUsed := 1 + header
+		if bytesUsed > len(src) {
+			return nil, 1, ErrCorruptedHuffmanTree
+		}
+		weights, err := decodeFSEWeights(v, src[1:bytesUsed])
+		return weights, bytesUsed, err
+	}
+}
+
+func decodeFSEWeights(v Version, src []byte) ([]byte, error) {
+	fset := fse.NewFSETable(maxWeightsAccuracyLog)
+	bs, err := fset.ReadTabledescription(src)
+	if err != nil {
+		return nil, err
+	}
+	if bs >= len(src) || fset.AccuracyLog > maxWeightsAccuracyLog {
+		return nil, ErrCorruptedHuffmanTree
+	}
+	for _, value := range fset.Values {
+		//one symbol occupying the whole table would not use any bits and never end
+		if value-1 == int64(1)<<uint(fset.AccuracyLog) {
+			return nil, ErrCorruptedHuffmanTree
+		}
+	}
+
+	err = fset.BuildDecodingTable(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := src[bs:]
+	if stream[len(stream)-1] == 0 {
+		return nil, ErrBadPadding
+	}
+
+	var weights []byte
+	if v == Version05 {
+		weights, err = decodeFSEWeightsV05(fset, stream)
+	} else {
+		//same as in the current format. Two interleaved states that share one table
+		weightsOutput := bytes.Buffer{}
+		_, err = fse.DecodeInterleavedFSEStreams([]*fse.FSEState{{Table: fset}, {Table: fset}}, stream, &weightsOutput)
+		weights = weightsOutput.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(weights) > maxHuffmanWeights {
+		return nil, ErrCorruptedHuffmanTree
+	}
+	return weights, nil
+}
+
+var ErrBadPadding = errors.New("The padding at the end of the stream was more than a byte. Data is likely corrupted")
+
+//decodeFSEWeightsV05 decodes the two interleaved states like v0.5 did. Other than in later versions the stream
+//does not end with an overflow but when all bits are used and the states reached zero
+func decodeFSEWeightsV05(fset *fse.FSETable, stream []byte) ([]byte, error) {
+	//if no symbol takes up half of the table or more, the states do not need to reach zero
+	fast := true
+	for _, value := range fset.Values {
+		if value-1 >= int64(1)<<uint(fset.AccuracyLog-1) {
+			fast = false
+		}
+	}
+
+	bitsrc := bitstream.NewReverseReader(stream)
+	_, err := bitsrc.SkipPadding()
+	if err != nil {
+		return nil, ErrBadPadding
+	}
+
+	state1 := fse.FSEState{Table: fset}
+	state2 := fse.FSEState{Table: fset}
+	states := [2]*fse.FSEState{&state1, &state2}
+	for _, state := range states {
+		state.InitState(bitsrc)
+	}
+
+	weights := make([]byte, 0, maxHuffmanWeights)
+	for i := 0; len(weights) < maxHuffmanWeights; i++ {
+		state := states[i%2]
+		bitsLeft := bitsrc.BitsStillInStream()
+		if bitsLeft < -1 || (bitsLeft == -1 && (fast || state.State == 0)) {
+			break
+		}
+		weights = append(weights, byte(state.DecodeSymbol(bitsrc)))
+	}
+
+	if bitsrc.BitsStillInStream() != -1 || state1.State != 0 || state2.State != 0 {
+		return nil, ErrCorruptedHuffmanTree
+	}
+	return weights, nil
+}
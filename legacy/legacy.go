@@ -0,0 +1,43 @@
+//Package legacy decodes frames that were written by the zstd releases v0.5, v0.6 and v0.7.
+//These releases used their own magic numbers and differ from the final format in the frame header,
+//the block headers and in the way sequences are encoded.
+package legacy
+
+import (
+	"errors"
+)
+
+type Version int
+
+const (
+	Version05 = Version(5)
+	Version06 = Version(6)
+	Version07 = Version(7)
+)
+
+const (
+	MagicnumV05 = 0xFD2FB525
+	MagicnumV06 = 0xFD2FB526
+	MagicnumV07 = 0xFD2FB527
+)
+
+//MaxBlockSize is the maximum size of a block (compressed and decompressed) in all legacy versions
+const MaxBlockSize = 128 * 1024
+
+//MinWindowSize is used if a frame header announces a smaller (or no) window
+const MinWindowSize = 1 << 10
+
+//VersionFromMagicnum returns the legacy version that uses this magic number. ok is false if the number does not belong to a legacy version
+func VersionFromMagicnum(magicnum uint32) (Version, bool) {
+	switch magicnum {
+	case MagicnumV05:
+		return Version05, true
+	case MagicnumV06:
+		return Version06, true
+	case MagicnumV07:
+		return Version07, true
+	}
+	return 0, false
+}
+
+var ErrUnknownVersion = errors.New("Unknown legacy version")
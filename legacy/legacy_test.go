@@ -0,0 +1,90 @@
+package legacy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodeFrameHeader(t *testing.T) {
+	tests := []struct {
+		version     Version
+		header      string
+		windowSize  uint64
+		contentSize uint64
+		err         error
+	}{
+		{Version05, "06", 1 << 17, 0, nil},
+		{Version05, "16", 0, 0, ErrReservedBitsSet},
+		{Version06, "852c01", 1 << 17, 556, nil},
+		{Version06, "25", 0, 0, ErrReservedBitsSet},
+		{Version07, "0038", 1 << 17, 0, nil},
+		{Version07, "2005", 5, 5, nil},
+		{Version07, "0838", 0, 0, ErrReservedBitsSet},
+	}
+
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.header)
+		fh := FrameHeader{}
+		err := fh.DecodeFrameHeader(test.version, bytes.NewReader(raw))
+		if err != test.err {
+			t.Errorf("%s: Wrong error: %v, should be: %v", test.header, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if fh.WindowSize != test.windowSize || fh.FrameContentSize != test.contentSize {
+			t.Errorf("%s: Wrong sizes: %d/%d, should be: %d/%d", test.header, fh.WindowSize, fh.FrameContentSize, test.windowSize, test.contentSize)
+		}
+	}
+}
+
+func TestRLEBlockHeader(t *testing.T) {
+	bh := BlockHeader{}
+	err := bh.DecodeHeader(Version07, []byte{0x80, 0x00, 0x14})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bh.Type != BlockTypeRLE || bh.BlockSize != 20 {
+		t.Errorf("Wrong header: %v", bh)
+	}
+
+	err = bh.DecodeHeader(Version06, []byte{0x80, 0x00, 0x14})
+	if err != ErrRLEBlocksNotSupported {
+		t.Errorf("Wrong error: %v, should be: %v", err, ErrRLEBlocksNotSupported)
+	}
+}
+
+//The second block reuses the huffman table of the first block (v0.7 only). Both blocks have no sequences
+func TestTreelessLiterals(t *testing.T) {
+	first, _ := hex.DecodeString("014c4512914f1e3074f7ff1e4145ff7b4496d1848a120b000b000b00b506c6130be34f65fca902" +
+		"8510a24cd0abb41eab592d023ef0f8ccfa495abb6f0294583c6e4c89c5e3c6c01000")
+	second, _ := hex.DecodeString("50901283800f3c9e58187f2a8fcfac9fa4b59b124b00")
+
+	bd := NewBlockDecoder(Version07)
+	err := bd.DecodeBlock(first)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "hello hello world, this is a test of huffman literals in a block, and more and more"
+	if string(bd.Literals) != expected {
+		t.Errorf("Wrong literals: %s, should be: %s", string(bd.Literals), expected)
+	}
+
+	err = bd.DecodeBlock(second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected = "more literals in a hello world block"
+	if string(bd.Literals) != expected {
+		t.Errorf("Wrong literals: %s, should be: %s", string(bd.Literals), expected)
+	}
+
+	//without a previous table the block can not be decoded
+	bd.Reset(Version07)
+	err = bd.DecodeBlock(second)
+	if err != ErrNoHuffTableToCarryOver {
+		t.Errorf("Wrong error: %v, should be: %v", err, ErrNoHuffTableToCarryOver)
+	}
+}
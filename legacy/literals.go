@@ -0,0 +1,163 @@
+package legacy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/structure"
+)
+
+const (
+	literalsTypeHuffman = 0
+	literalsTypeRepeat  = 1 //huffman compressed with the table of the previous block
+	literalsTypeRaw     = 2
+	literalsTypeRLE     = 3
+)
+
+var ErrCorruptedLiterals = errors.New("The legacy literals section is corrupted")
+var ErrNoHuffTableToCarryOver = errors.New("No previous Huffmantree available")
+var ErrRepeatNeedsDictionary = errors.New("Repeating tables is only possible with a dictionary in this legacy version. Dictionaries are not supported")
+
+//decodeLiterals decodes the literals section at the start of a compressed block into bd.Literals
+//returns the number of bytes used by the section
+func (bd *BlockDecoder) decodeLiterals(src []byte) (int, error) {
+	if len(src) < 3 {
+		return 0, ErrCorruptedLiterals
+	}
+
+	headerSize := int(src[0]>>4) & 3
+
+	switch src[0] >> 6 {
+	case literalsTypeHuffman:
+		if len(src) < 5 {
+			return 0, ErrCorruptedLiterals
+		}
+		singleStream := false
+		regeneratedSize, compressedSize := 0, 0
+		switch headerSize {
+		case 0, 1:
+			singleStream = src[0]&16 != 0
+			headerSize = 3
+			regeneratedSize = int(src[0]&15)<<6 + int(src[1]>>2)
+			compressedSize = int(src[1]&3)<<8 + int(src[2])
+		case 2:
+			headerSize = 4
+			regeneratedSize = int(src[0]&15)<<10 + int(src[1])<<2 + int(src[2]>>6)
+			compressedSize = int(src[2]&63)<<8 + int(src[3])
+		case 3:
+			headerSize = 5
+			regeneratedSize = int(src[0]&15)<<14 + int(src[1])<<6 + int(src[2]>>2)
+			compressedSize = int(src[2]&3)<<16 + int(src[3])<<8 + int(src[4])
+		}
+		if regeneratedSize > MaxBlockSize || headerSize+compressedSize > len(src) {
+			return 0, ErrCorruptedLiterals
+		}
+		compressed := src[headerSize : headerSize+compressedSize]
+
+		table, treeSize, err := readHuffmanTable(bd.Version, compressed)
+		if err != nil {
+			return 0, err
+		}
+		bd.huffmanTable = table
+
+		bd.Literals = bd.literalsSlice(regeneratedSize)
+		err = decodeHuffmanStreams(table, compressed[treeSize:], bd.Literals, singleStream)
+		if err != nil {
+			return 0, err
+		}
+		return headerSize + compressedSize, nil
+
+	case literalsTypeRepeat:
+		if bd.Version < Version07 {
+			return 0, ErrRepeatNeedsDictionary
+		}
+		if bd.huffmanTable == nil {
+			return 0, ErrNoHuffTableToCarryOver
+		}
+		//only small single streams can reuse the table
+		if headerSize != 1 {
+			return 0, ErrCorruptedLiterals
+		}
+		headerSize = 3
+		regeneratedSize := int(src[0]&15)<<6 + int(src[1]>>2)
+		compressedSize := int(src[1]&3)<<8 + int(src[2])
+		if headerSize+compressedSize > len(src) {
+			return 0, ErrCorruptedLiterals
+		}
+
+		bd.Literals = bd.literalsSlice(regeneratedSize)
+		err := decodeHuffmanStreams(bd.huffmanTable, src[headerSize:headerSize+compressedSize], bd.Literals, true)
+		if err != nil {
+			return 0, err
+		}
+		return headerSize + compressedSize, nil
+
+	case literalsTypeRaw, literalsTypeRLE:
+		regeneratedSize := 0
+		switch headerSize {
+		case 0, 1:
+			headerSize = 1
+			regeneratedSize = int(src[0] & 31)
+		case 2:
+			regeneratedSize = int(src[0]&15)<<8 + int(src[1])
+		case 3:
+			regeneratedSize = int(src[0]&15)<<16 + int(src[1])<<8 + int(src[2])
+		}
+		if regeneratedSize > MaxBlockSize {
+			return 0, ErrCorruptedLiterals
+		}
+
+		if src[0]>>6 == literalsTypeRaw {
+			if headerSize+regeneratedSize > len(src) {
+				return 0, ErrCorruptedLiterals
+			}
+			bd.Literals = src[headerSize : headerSize+regeneratedSize]
+			return headerSize + regeneratedSize, nil
+		}
+
+		if headerSize+1 > len(src) {
+			return 0, ErrCorruptedLiterals
+		}
+		bd.Literals = bd.literalsSlice(regeneratedSize)
+		for i := range bd.Literals {
+			bd.Literals[i] = src[headerSize]
+		}
+		return headerSize + 1, nil
+	}
+
+	//keeping. Two bits can not have any other value
+	panic("Unknown literals type")
+}
+
+//decodeHuffmanStreams decodes either one stream or four streams with a jump table in front, like in the current format
+func decodeHuffmanStreams(table *structure.HuffmanDecodingTable, src []byte, output []byte, singleStream bool) error {
+	if singleStream {
+		n, err := table.DecodeStream(src, output)
+		if err != nil {
+			return err
+		}
+		if n != len(output) {
+			return structure.ErrStreamDidntDecodeToRightLength
+		}
+		return nil
+	}
+
+	if len(src) < 10 {
+		return ErrCorruptedLiterals
+	}
+	var sizes [4]int
+	sizes[0] = int(binary.LittleEndian.Uint16(src[0:2]))
+	sizes[1] = int(binary.LittleEndian.Uint16(src[2:4]))
+	sizes[2] = int(binary.LittleEndian.Uint16(src[4:6]))
+	sizes[3] = len(src) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] <= 0 {
+		return structure.ErrCorruptedJumptable
+	}
+
+	var streams [4][]byte
+	src = src[6:]
+	for i, size := range sizes {
+		streams[i] = src[:size]
+		src = src[size:]
+	}
+	return table.DecodeFourStreams(streams, output)
+}
@@ -0,0 +1,385 @@
+package legacy
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"github.com/killingspark/sparkzstd/fse"
+)
+
+//modes for the three tables in the sequences header. Note that the order differs from the current format
+const (
+	seqModePredefined = 0 //v0.5 reads the codes directly from the bitstream instead
+	seqModeRLE        = 1
+	seqModeRepeat     = 2
+	seqModeCompressed = 3
+)
+
+//limits of the codes and table sizes for one kind of table
+type codeLimits struct {
+	maxSymbol      int
+	maxAccuracyLog int
+}
+
+var (
+	llLimitsV05 = codeLimits{63, 10}
+	mlLimitsV05 = codeLimits{127, 10}
+	ofLimitsV05 = codeLimits{31, 9}
+
+	llLimits = codeLimits{35, 9}
+	mlLimits = codeLimits{52, 9}
+	ofLimits = codeLimits{28, 8}
+)
+
+//v0.5 extends literal lengths of 63 and match lengths of 127 with bytes from the "dumps" area
+const (
+	maxLiteralLengthV05 = 63
+	maxMatchLengthV05   = 127
+	minMatchV05         = 4
+)
+
+var ErrCorruptedSequences = errors.New("The legacy sequences section is corrupted")
+var ErrNoTableToCarryOver = errors.New("Needed to repeat the previous sequences table but there was none")
+
+func (bd *BlockDecoder) decodeSequences(src []byte) error {
+	if len(src) < 1 {
+		return ErrCorruptedSequences
+	}
+
+	numberOfSequences := int(src[0])
+	if numberOfSequences == 0 {
+		return nil
+	}
+	src = src[1:]
+
+	if bd.Version == Version05 {
+		if numberOfSequences >= 128 {
+			if len(src) < 1 {
+				return ErrCorruptedSequences
+			}
+			numberOfSequences = (numberOfSequences-128)<<8 + int(src[0])
+			src = src[1:]
+		}
+	} else {
+		if numberOfSequences == 255 {
+			if len(src) < 2 {
+				return ErrCorruptedSequences
+			}
+			numberOfSequences = int(binary.LittleEndian.Uint16(src)) + 0x7F00
+			src = src[2:]
+		} else if numberOfSequences >= 128 {
+			if len(src) < 1 {
+				return ErrCorruptedSequences
+			}
+			numberOfSequences = (numberOfSequences-128)<<8 + int(src[0])
+			src = src[1:]
+		}
+	}
+
+	if len(src) < 1 {
+		return ErrCorruptedSequences
+	}
+	modes := src[0]
+	llMode := int(modes >> 6)
+	ofMode := int(modes>>4) & 3
+	mlMode := int(modes>>2) & 3
+
+	var dumps []byte
+	if bd.Version == Version05 {
+		dumpsLength := 0
+		if modes&2 != 0 {
+			if len(src) < 3 {
+				return ErrCorruptedSequences
+			}
+			dumpsLength = int(src[1])<<8 + int(src[2])
+			src = src[3:]
+		} else {
+			if len(src) < 2 {
+				return ErrCorruptedSequences
+			}
+			dumpsLength = int(modes&1)<<8 + int(src[1])
+			src = src[2:]
+		}
+		if dumpsLength > len(src) {
+			return ErrCorruptedSequences
+		}
+		dumps = src[:dumpsLength]
+		src = src[dumpsLength:]
+		//at least one byte for each table
+		if len(src) < 3 {
+			return ErrCorruptedSequences
+		}
+	} else {
+		//at least one byte for each table
+		if len(src) < 4 {
+			return ErrCorruptedSequences
+		}
+		src = src[1:]
+	}
+
+	var err error
+	var bytesUsed int
+
+	ll, of, ml := llLimits, ofLimits, mlLimits
+	if bd.Version == Version05 {
+		ll, of, ml = llLimitsV05, ofLimitsV05, mlLimitsV05
+	}
+
+	bd.llTable, bytesUsed, err = bd.buildTable(llMode, src, ll, predefinedLiteralLengthsTable, bd.llTable)
+	if err != nil {
+		return err
+	}
+	src = src[bytesUsed:]
+
+	bd.ofTable, bytesUsed, err = bd.buildTable(ofMode, src, of, predefinedOffsetTable, bd.ofTable)
+	if err != nil {
+		return err
+	}
+	src = src[bytesUsed:]
+
+	bd.mlTable, bytesUsed, err = bd.buildTable(mlMode, src, ml, predefinedMatchLengthsTable, bd.mlTable)
+	if err != nil {
+		return err
+	}
+	src = src[bytesUsed:]
+
+	if len(src) == 0 || src[len(src)-1] == 0 {
+		return ErrBadPadding
+	}
+	bitsrc := bitstream.NewReverseReader(src)
+	bitsrc.SkipPadding()
+
+	bd.llState = fse.FSEState{Table: bd.llTable}
+	bd.ofState = fse.FSEState{Table: bd.ofTable}
+	bd.mlState = fse.FSEState{Table: bd.mlTable}
+	bd.llState.InitState(bitsrc)
+	bd.ofState.InitState(bitsrc)
+	bd.mlState.InitState(bitsrc)
+
+	switch bd.Version {
+	case Version05:
+		return bd.decodeSequencesV05(bitsrc, numberOfSequences, dumps)
+	case Version06:
+		//v0.6 does not carry the offsets over to the next block
+		bd.offsetHistory = [3]int{1, 1, 1}
+		return bd.decodeSequencesV06V07(bitsrc, numberOfSequences)
+	default:
+		return bd.decodeSequencesV06V07(bitsrc, numberOfSequences)
+	}
+}
+
+//The predefined tables of v0.6 and v0.7. Other than in the current format the symbols stay untranslated codes
+var (
+	predefinedLiteralLengthsTable = fse.NewFSETableFromDistribution(fse.LiteralLengthDefaultDistributions[:], fse.LiteralLengthDefaultAccuracyLog, nil, nil)
+	predefinedMatchLengthsTable   = fse.NewFSETableFromDistribution(fse.MatchLengthDefaultDistribution[:], fse.MatchLengthDefaultAccuracyLog, nil, nil)
+	predefinedOffsetTable         = fse.NewFSETableFromDistribution(fse.OffsetDefaultDistribution[:], fse.OffsetDefaultAccuracyLog, nil, nil)
+)
+
+//buildTable builds the decoding table for one of the three kinds of codes. The symbols stay untranslated codes
+//returns the table and the bytes read from src
+func (bd *BlockDecoder) buildTable(mode int, src []byte, limits codeLimits, predefined *fse.FSETable, previous *fse.FSETable) (*fse.FSETable, int, error) {
+	switch mode {
+	case seqModePredefined:
+		if bd.Version == Version05 {
+			return rawCodesTable(fse.BIT_highbit32(uint32(limits.maxSymbol)) + 1), 0, nil
+		}
+		return predefined, 0, nil
+
+	case seqModeRLE:
+		if len(src) < 1 {
+			return nil, 0, ErrCorruptedSequences
+		}
+		symbol := int(src[0])
+		if bd.Version == Version05 {
+			//v0.5 did not check the symbol
+			symbol &= limits.maxSymbol
+		}
+		if symbol > limits.maxSymbol {
+			return nil, 0, ErrCorruptedSequences
+		}
+		return fse.NewRLETable(symbol, 0), 1, nil
+
+	case seqModeRepeat:
+		if bd.Version < Version07 {
+			return nil, 0, ErrRepeatNeedsDictionary
+		}
+		if previous == nil {
+			return nil, 0, ErrNoTableToCarryOver
+		}
+		return previous, 0, nil
+
+	default:
+		fset := fse.NewFSETable(limits.maxAccuracyLog)
+		bytesRead, err := fset.ReadTabledescription(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		if bytesRead > len(src) || fset.AccuracyLog > limits.maxAccuracyLog || len(fset.Values) > limits.maxSymbol+1 {
+			return nil, 0, ErrCorruptedSequences
+		}
+		err = fset.BuildDecodingTable(nil, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		return fset, bytesRead, nil
+	}
+}
+
+//rawCodesTable is used by v0.5 in place of a predefined table. Each code is read from the stream with a fixed number of bits
+func rawCodesTable(bits uint32) *fse.FSETable {
+	fset := fse.FSETable{AccuracyLog: int(bits)}
+	fset.DecodingTable = make([]fse.FSETableEntry, 1<<bits)
+	for i := range fset.DecodingTable {
+		fset.DecodingTable[i] = fse.FSETableEntry{Symbol: i, NumberOfBits: byte(bits)}
+	}
+	return &fset
+}
+
+func (bd *BlockDecoder) decodeSequencesV06V07(bitsrc *bitstream.ReverseReader, numberOfSequences int) error {
+	for i := 0; i < numberOfSequences; i++ {
+		if bitsrc.BitsStillInStream() < -1 {
+			return ErrCorruptedSequences
+		}
+
+		llCode := bd.llState.PeekSymbol()
+		mlCode := bd.mlState.PeekSymbol()
+		ofCode := bd.ofState.PeekSymbol()
+
+		offset := 0
+		if ofCode > 0 {
+			bits := bitsrc.Read(uint(ofCode))
+			offset = offsetBase(bd.Version, ofCode) + int(bits)
+		}
+
+		//v0.7 uses codes 0 and 1 for repeated offsets. v0.6 uses the values 0 to 2 and moves all other offsets by 2
+		repeat := ofCode <= 1
+		if bd.Version == Version06 {
+			repeat = offset < 3
+			if !repeat {
+				offset -= 2
+			}
+		}
+
+		if repeat {
+			if llCode == 0 && offset <= 1 {
+				offset = 1 - offset
+			}
+			if offset != 0 {
+				selected := bd.offsetHistory[offset]
+				if offset != 1 {
+					bd.offsetHistory[2] = bd.offsetHistory[1]
+				}
+				bd.offsetHistory[1] = bd.offsetHistory[0]
+				bd.offsetHistory[0] = selected
+				offset = selected
+			} else {
+				offset = bd.offsetHistory[0]
+			}
+		} else {
+			bd.offsetHistory[2] = bd.offsetHistory[1]
+			bd.offsetHistory[1] = bd.offsetHistory[0]
+			bd.offsetHistory[0] = offset
+		}
+
+		//the length codes are the same as in the current format
+		mlExtra := bitsrc.Read(uint(fse.MatchLengthsExtraBits[mlCode]))
+		llExtra := bitsrc.Read(uint(fse.LiteralLengthExtraBits[llCode]))
+
+		bd.Sequences = append(bd.Sequences, Sequence{
+			LiteralLength: fse.LiteralLengthBaseValueTranslation[llCode] + int(llExtra),
+			MatchLength:   fse.MatchLengthBaseValueTranslation[mlCode] + int(mlExtra),
+			Offset:        offset,
+		})
+
+		bd.llState.NextState(bitsrc)
+		bd.mlState.NextState(bitsrc)
+		bd.ofState.NextState(bitsrc)
+	}
+	return nil
+}
+
+func offsetBase(v Version, code int) int {
+	if v == Version06 {
+		if code > 26 {
+			//not valid. v0.6 used a fake value here
+			return 1
+		}
+		return 1<<uint(code) - 1
+	}
+	if code == 1 {
+		return 1
+	}
+	return 1<<uint(code) - 3
+}
+
+func (bd *BlockDecoder) decodeSequencesV05(bitsrc *bitstream.ReverseReader, numberOfSequences int, dumps []byte) error {
+	//offset of the previous sequence and the one before that
+	lastOffset := 1
+	repeatOffset := 1
+
+	for i := 0; i < numberOfSequences; i++ {
+		if bitsrc.BitsStillInStream() < -1 {
+			return ErrCorruptedSequences
+		}
+
+		literalLength := bd.llState.PeekSymbol()
+		previousOffset := repeatOffset
+		if literalLength != 0 {
+			previousOffset = lastOffset
+		}
+		if literalLength == maxLiteralLengthV05 {
+			if len(dumps) == 0 {
+				return ErrCorruptedSequences
+			}
+			literalLength, dumps = extendFromDumps(literalLength, dumps)
+		}
+
+		ofCode := bd.ofState.PeekSymbol()
+		offset := previousOffset
+		if ofCode > 0 {
+			bits := bitsrc.Read(uint(ofCode - 1))
+			base := 1
+			if ofCode < 27 {
+				base = 1 << uint(ofCode-1)
+			}
+			offset = base + int(bits)
+		}
+		if ofCode != 0 || literalLength == 0 {
+			repeatOffset = lastOffset
+		}
+		bd.ofState.NextState(bitsrc)
+		bd.llState.NextState(bitsrc)
+
+		matchLength := bd.mlState.DecodeSymbol(bitsrc)
+		if matchLength == maxMatchLengthV05 && len(dumps) > 0 {
+			matchLength, dumps = extendFromDumps(matchLength, dumps)
+		}
+
+		bd.Sequences = append(bd.Sequences, Sequence{
+			LiteralLength: literalLength,
+			MatchLength:   matchLength + minMatchV05,
+			Offset:        offset,
+		})
+		lastOffset = offset
+	}
+	return nil
+}
+
+//extendFromDumps adds one byte to the length. If that byte is 255, the length is stored in the next two or three bytes instead
+func extendFromDumps(length int, dumps []byte) (int, []byte) {
+	add := int(dumps[0])
+	dumps = dumps[1:]
+	if add < 255 {
+		return length + add, dumps
+	}
+	if len(dumps) < 2 {
+		return length, dumps
+	}
+	length = int(binary.LittleEndian.Uint16(dumps))
+	dumps = dumps[2:]
+	if length&1 != 0 && len(dumps) > 0 {
+		length += int(dumps[0]) << 16
+		dumps = dumps[1:]
+	}
+	return length >> 1, dumps
+}
@@ -0,0 +1,134 @@
+//go:build !noasm
+
+package structure
+
+import (
+	"github.com/killingspark/sparkzstd/bitstream"
+	"github.com/killingspark/sparkzstd/fse"
+	"unsafe"
+)
+
+//useAsm selects the assembly decoders. They use the BMI2 shifts, so they are only used if the CPU has them.
+//Build with the tag noasm to leave them out completely
+var useAsm = cpuHasBMI2()
+
+//cpuHasBMI2 asks CPUID for the BMI2 instructions
+func cpuHasBMI2() bool
+
+//huffmanContext is shared with decodeFourStreamsBMI2. Every stream has at least 8 bytes left whenever its container is reloaded,
+//so the container is always full after a reload
+type huffmanContext struct {
+	entries  *uint16
+	shift    uint64 //64 - MaxBits
+	limit    int    //number of symbols to decode per stream at most, a multiple of 4
+	decoded  int    //number of symbols decoded per stream
+	out      [4]*byte
+	data     [4]*byte
+	ptr      [4]int
+	consumed [4]uint64
+}
+
+//go:noescape
+func decodeFourStreamsBMI2(ctx *huffmanContext)
+
+//decodeFourStreamsAsm decodes the four streams in blocks of 4 symbols per stream as long as all of them have enough bytes left
+//and the outputs have at least limit bytes. It returns how many symbols were decoded per stream
+func (ht *HuffmanDecodingTable) decodeFourStreamsAsm(readers [4]*bitstream.ReverseReader, outputs [4][]byte, limit int) int {
+	if !useAsm || limit < 4 {
+		return 0
+	}
+	ctx := huffmanContext{
+		entries: &ht.Entries[0],
+		shift:   uint64(64 - ht.MaxBits),
+		limit:   limit &^ 3,
+	}
+	for i, br := range readers {
+		ptr, consumed := br.Position()
+		if ptr < 8 {
+			return 0
+		}
+		ctx.out[i] = &outputs[i][0]
+		ctx.data[i] = &br.Data()[0]
+		ctx.ptr[i] = ptr
+		ctx.consumed[i] = uint64(consumed)
+	}
+
+	decodeFourStreamsBMI2(&ctx)
+
+	for i, br := range readers {
+		br.SetPosition(ctx.ptr[i], uint(ctx.consumed[i]))
+	}
+	return ctx.decoded
+}
+
+//sequencesContext is shared with decodeSequencesBMI2. The loop stops before the stream has less than 16 bytes left, so the
+//three reloads of a sequence always fill the container
+type sequencesContext struct {
+	llTable *fse.FSETableEntry
+	mlTable *fse.FSETableEntry
+	ofTable *fse.FSETableEntry
+	llState uint64
+	mlState uint64
+	ofState uint64
+
+	data     *byte
+	ptr      int
+	consumed uint64
+
+	out     *Sequence
+	limit   int //the states are updated after every sequence, so the last sequence of the section is left to NextSequence
+	decoded int
+}
+
+//go:noescape
+func decodeSequencesBMI2(ctx *sequencesContext)
+
+//the assembly relies on the layout of these structs from other packages
+const (
+	_ = uint(unsafe.Sizeof(fse.FSETableEntry{}) - 16)
+	_ = uint(16 - unsafe.Sizeof(fse.FSETableEntry{}))
+	_ = uint(unsafe.Offsetof(fse.FSETableEntry{}.Symbol) - 8)
+	_ = uint(8 - unsafe.Offsetof(fse.FSETableEntry{}.Symbol))
+	_ = uint(unsafe.Offsetof(fse.FSETableEntry{}.NumberOfAdditionalBits) - 2)
+	_ = uint(2 - unsafe.Offsetof(fse.FSETableEntry{}.NumberOfAdditionalBits))
+	_ = uint(unsafe.Offsetof(fse.FSETableEntry{}.NumberOfBits) - 3)
+	_ = uint(3 - unsafe.Offsetof(fse.FSETableEntry{}.NumberOfBits))
+)
+
+//decodeSequencesAsm decodes the next sequences of the section into out after InitSequences, as long as the stream has
+//enough bytes left. It returns how many sequences were decoded. NextSequence continues with the rest
+func (ss *SequencesSection) decodeSequencesAsm(out []Sequence) int {
+	limit := ss.sequencesLeft - 1
+	if len(out) < limit {
+		limit = len(out)
+	}
+	if !useAsm || limit < 1 {
+		return 0
+	}
+	ptr, consumed := ss.bitsrc.Position()
+	if ptr < 16 {
+		return 0
+	}
+	ctx := sequencesContext{
+		llTable:  &ss.llState.Table.DecodingTable[0],
+		mlTable:  &ss.mlState.Table.DecodingTable[0],
+		ofTable:  &ss.ofState.Table.DecodingTable[0],
+		llState:  uint64(ss.llState.State),
+		mlState:  uint64(ss.mlState.State),
+		ofState:  uint64(ss.ofState.State),
+		data:     &ss.bitsrc.Data()[0],
+		ptr:      ptr,
+		consumed: uint64(consumed),
+		out:      &out[0],
+		limit:    limit,
+	}
+
+	decodeSequencesBMI2(&ctx)
+
+	ss.bitsrc.SetPosition(ctx.ptr, uint(ctx.consumed))
+	ss.llState.State = int64(ctx.llState)
+	ss.mlState.State = int64(ctx.mlState)
+	ss.ofState.State = int64(ctx.ofState)
+	ss.sequencesLeft -= ctx.decoded
+	return ctx.decoded
+}
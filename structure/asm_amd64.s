@@ -0,0 +1,199 @@
+//go:build !noasm
+
+#include "textflag.h"
+#include "go_asm.h"
+
+// func cpuHasBMI2() bool
+TEXT ·cpuHasBMI2(SB), NOSPLIT, $0-1
+	XORL AX, AX
+	XORL CX, CX
+	CPUID
+	CMPL AX, $7
+	JCS  nobmi2
+	MOVL $7, AX
+	XORL CX, CX
+	CPUID
+	SHRL $8, BX
+	ANDL $1, BX
+	MOVB BX, ret+0(FP)
+	RET
+
+nobmi2:
+	MOVB $0, ret+0(FP)
+	RET
+
+// The bit readers are kept as container and bits consumed, the index of the container in the stream stays in the context.
+// A reload moves the container down by the whole bytes that have been consumed.
+// R11 holds the context, CX and R15 are scratch.
+
+// HUFF_RELOAD(off, container, consumed) reloads the stream at offset off in the arrays of huffmanContext
+#define HUFF_RELOAD(off, C, K) \
+	MOVQ K, CX; \
+	SHRQ $3, CX; \
+	ANDQ $7, K; \
+	MOVQ huffmanContext_ptr+off(R11), R15; \
+	SUBQ CX, R15; \
+	MOVQ R15, huffmanContext_ptr+off(R11); \
+	MOVQ huffmanContext_data+off(R11), CX; \
+	MOVQ (CX)(R15*1), C
+
+// HUFF_DECODE(off, j, container, consumed) decodes the symbol j of the block of 4 of one stream.
+// The next MaxBits bits index the table, the entry holds the symbol in the upper byte and the number of bits in the lower
+#define HUFF_DECODE(off, j, C, K) \
+	SHLXQ K, C, R15; \
+	SHRXQ R9, R15, R15; \
+	MOVWQZX (R8)(R15*2), R15; \
+	MOVBQZX R15B, CX; \
+	ADDQ CX, K; \
+	SHRQ $8, R15; \
+	MOVQ huffmanContext_out+off(R11), CX; \
+	MOVB R15B, j(CX)(R10*1)
+
+#define HUFF_DECODE_ALL(j) \
+	HUFF_DECODE(0, j, AX, DI); \
+	HUFF_DECODE(8, j, BX, R12); \
+	HUFF_DECODE(16, j, DX, R13); \
+	HUFF_DECODE(24, j, SI, R14)
+
+// func decodeFourStreamsBMI2(ctx *huffmanContext)
+TEXT ·decodeFourStreamsBMI2(SB), NOSPLIT, $0-8
+	MOVQ ctx+0(FP), R11
+	MOVQ huffmanContext_entries(R11), R8
+	MOVQ huffmanContext_shift(R11), R9
+	XORQ R10, R10
+	MOVQ huffmanContext_consumed+0(R11), DI
+	MOVQ huffmanContext_consumed+8(R11), R12
+	MOVQ huffmanContext_consumed+16(R11), R13
+	MOVQ huffmanContext_consumed+24(R11), R14
+
+huffloop:
+	// R10 is the number of symbols decoded per stream
+	LEAQ 4(R10), CX
+	CMPQ CX, huffmanContext_limit(R11)
+	JGT  huffdone
+
+	// with 8 bytes left every reload fills the container, then there are enough bits for 4 symbols
+	CMPQ huffmanContext_ptr+0(R11), $8
+	JLT  huffdone
+	CMPQ huffmanContext_ptr+8(R11), $8
+	JLT  huffdone
+	CMPQ huffmanContext_ptr+16(R11), $8
+	JLT  huffdone
+	CMPQ huffmanContext_ptr+24(R11), $8
+	JLT  huffdone
+
+	HUFF_RELOAD(0, AX, DI)
+	HUFF_RELOAD(8, BX, R12)
+	HUFF_RELOAD(16, DX, R13)
+	HUFF_RELOAD(24, SI, R14)
+
+	HUFF_DECODE_ALL(0)
+	HUFF_DECODE_ALL(1)
+	HUFF_DECODE_ALL(2)
+	HUFF_DECODE_ALL(3)
+
+	ADDQ $4, R10
+	JMP  huffloop
+
+huffdone:
+	MOVQ R10, huffmanContext_decoded(R11)
+	MOVQ DI, huffmanContext_consumed+0(R11)
+	MOVQ R12, huffmanContext_consumed+8(R11)
+	MOVQ R13, huffmanContext_consumed+16(R11)
+	MOVQ R14, huffmanContext_consumed+24(R11)
+	RET
+
+// SEQ_RELOAD reloads the container AX, DI are the bits consumed
+#define SEQ_RELOAD \
+	MOVQ DI, CX; \
+	SHRQ $3, CX; \
+	ANDQ $7, DI; \
+	MOVQ sequencesContext_ptr(R11), R15; \
+	SUBQ CX, R15; \
+	MOVQ R15, sequencesContext_ptr(R11); \
+	MOVQ sequencesContext_data(R11), CX; \
+	MOVQ (CX)(R15*1), AX
+
+// SEQ_READ reads the number of bits in CX into R15. Shifting in two steps gives 0 for 0 bits
+#define SEQ_READ \
+	SHLXQ DI, AX, R15; \
+	SHRQ $1, R15; \
+	ADDQ CX, DI; \
+	NEGQ CX; \
+	ADDQ $63, CX; \
+	SHRXQ CX, R15, R15
+
+// SEQ_ENTRY(table, state) sets DX to the address of the table entry of the state
+#define SEQ_ENTRY(table, state) \
+	MOVQ state, DX; \
+	SHLQ $4, DX; \
+	ADDQ table, DX
+
+// SEQ_NEXT_STATE(table, state) reads the next state: the baseline of the entry plus the number of bits of the entry
+#define SEQ_NEXT_STATE(table, state) \
+	SEQ_ENTRY(table, state); \
+	MOVBQZX 3(DX), CX; \
+	SEQ_READ; \
+	MOVWQZX 0(DX), state; \
+	ADDQ R15, state
+
+// func decodeSequencesBMI2(ctx *sequencesContext)
+TEXT ·decodeSequencesBMI2(SB), NOSPLIT, $0-8
+	MOVQ ctx+0(FP), R11
+	MOVQ sequencesContext_llTable(R11), R8
+	MOVQ sequencesContext_mlTable(R11), R9
+	MOVQ sequencesContext_ofTable(R11), R10
+	MOVQ sequencesContext_llState(R11), R12
+	MOVQ sequencesContext_mlState(R11), R13
+	MOVQ sequencesContext_ofState(R11), R14
+	MOVQ sequencesContext_consumed(R11), DI
+	MOVQ sequencesContext_out(R11), BX
+	XORQ SI, SI
+
+seqloop:
+	CMPQ SI, sequencesContext_limit(R11)
+	JGE  seqdone
+
+	// the three reloads of a sequence move the container by at most 15 bytes
+	CMPQ sequencesContext_ptr(R11), $16
+	JLT  seqdone
+
+	// offset: 1 << code plus code extra bits
+	SEQ_RELOAD
+	SEQ_ENTRY(R10, R14)
+	MOVQ 8(DX), CX
+	MOVQ CX, DX
+	SEQ_READ
+	BTSQ DX, R15
+	MOVQ R15, Sequence_Offset(BX)
+
+	// match length and literal length: symbol plus extra bits
+	SEQ_RELOAD
+	SEQ_ENTRY(R9, R13)
+	MOVBQZX 2(DX), CX
+	SEQ_READ
+	ADDQ 8(DX), R15
+	MOVQ R15, Sequence_MatchLength(BX)
+	SEQ_ENTRY(R8, R12)
+	MOVBQZX 2(DX), CX
+	SEQ_READ
+	ADDQ 8(DX), R15
+	MOVQ R15, Sequence_LiteralLength(BX)
+
+	ADDQ $Sequence__size, BX
+	INCQ SI
+
+	// the states are updated in the order literal length, match length, offset
+	SEQ_RELOAD
+	SEQ_NEXT_STATE(R8, R12)
+	SEQ_NEXT_STATE(R9, R13)
+	SEQ_NEXT_STATE(R10, R14)
+	JMP seqloop
+
+seqdone:
+	MOVQ R12, sequencesContext_llState(R11)
+	MOVQ R13, sequencesContext_mlState(R11)
+	MOVQ R14, sequencesContext_ofState(R11)
+	MOVQ DI, sequencesContext_consumed(R11)
+	MOVQ SI, sequencesContext_decoded(R11)
+	RET
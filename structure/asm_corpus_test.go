@@ -0,0 +1,54 @@
+package structure_test
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/decompression"
+	"github.com/killingspark/sparkzstd/structure"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//TestAsmCorpus decodes the decodecorpus and the benchmark files with and without the assembly decoders
+func TestAsmCorpus(t *testing.T) {
+	if !structure.SetUseAsm(true) {
+		structure.SetUseAsm(false)
+		t.Skip("The assembly decoders are not used on this machine")
+	}
+	var files []string
+	for _, pattern := range []string{"../decodecorpus_files/*.zst", "../benchmark_files/*.zst"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		files = append(files, matches...)
+	}
+
+	decode := func(compressed []byte, asm bool, twoPhase bool) ([]byte, error) {
+		structure.SetUseAsm(asm)
+		defer structure.SetUseAsm(true)
+		result := &bytes.Buffer{}
+		fd := decompression.NewFrameDecompressor(bytes.NewReader(compressed), result)
+		//the assembly decodes all sequences at once with two phases and in batches without
+		fd.SetTwoPhaseSequences(twoPhase)
+		err := fd.Decompress()
+		return result.Bytes(), err
+	}
+
+	for _, file := range files {
+		compressed, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		goResult, goErr := decode(compressed, false, false)
+		for _, twoPhase := range []bool{false, true} {
+			asmResult, asmErr := decode(compressed, true, twoPhase)
+			if asmErr != goErr {
+				t.Errorf("%s (two phases: %v): Assembly returned %v, go %v", file, twoPhase, asmErr, goErr)
+			}
+			if !bytes.Equal(asmResult, goResult) {
+				t.Errorf("%s (two phases: %v): Different output", file, twoPhase)
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !amd64 || noasm
+
+package structure
+
+import (
+	"github.com/killingspark/sparkzstd/bitstream"
+)
+
+//useAsm is always false without the assembly decoders
+var useAsm = false
+
+func (ht *HuffmanDecodingTable) decodeFourStreamsAsm(readers [4]*bitstream.ReverseReader, outputs [4][]byte, limit int) int {
+	return 0
+}
+
+func (ss *SequencesSection) decodeSequencesAsm(out []Sequence) int {
+	return 0
+}
@@ -0,0 +1,148 @@
+package structure
+
+import (
+	"bytes"
+	"github.com/killingspark/sparkzstd/fse"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//skipWithoutAsm skips differential tests if there is nothing to compare the go code with
+func skipWithoutAsm(t testing.TB) {
+	if !useAsm {
+		t.Skip("The assembly decoders are not used on this machine")
+	}
+}
+
+//decodeFourStreamsBoth decodes the streams with and without the assembly and compares the results
+func decodeFourStreamsBoth(t testing.TB, table *HuffmanDecodingTable, streams [4][]byte, size int) {
+	asmOutput := make([]byte, size)
+	asmErr := table.DecodeFourStreams(streams, asmOutput)
+
+	SetUseAsm(false)
+	goOutput := make([]byte, size)
+	goErr := table.DecodeFourStreams(streams, goOutput)
+	SetUseAsm(true)
+
+	if asmErr != goErr {
+		t.Fatalf("Size %d: Assembly returned %v, go %v", size, asmErr, goErr)
+	}
+	if !bytes.Equal(asmOutput, goOutput) {
+		t.Fatalf("Size %d: Assembly decoded other symbols than go", size)
+	}
+}
+
+func TestAsmFourStreams(t *testing.T) {
+	skipWithoutAsm(t)
+
+	htd := HuffmanTreeDesc{Weights: []byte{4, 3, 2, 0, 1, 3, 2, 1, 1, 1}}
+	table, err := htd.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	alphabet := []byte{0, 1, 2, 4, 5, 6, 7, 8, 9, 10}
+	for _, size := range []int{6, 31, 32, 100, 1003, 5000} {
+		symbols := make([]byte, size)
+		for i := range symbols {
+			symbols[i] = alphabet[rand.Intn(len(alphabet))]
+		}
+		var streams [4][]byte
+		segment := (size + 3) / 4
+		for i := range streams {
+			high := (i + 1) * segment
+			if i == 3 {
+				high = size
+			}
+			streams[i] = encodeHuffman(t, table, symbols[i*segment:high])
+		}
+		decodeFourStreamsBoth(t, table, streams, size)
+
+		//streams that are too long or too short for the output
+		decodeFourStreamsBoth(t, table, streams, size-1)
+		decodeFourStreamsBoth(t, table, streams, size+5)
+	}
+}
+
+func FuzzAsmFourStreams(f *testing.F) {
+	f.Add([]byte{4, 3, 2, 0, 1, 3, 2, 1, 1, 1}, bytes.Repeat([]byte{0x5A, 0xC3, 0x81}, 100), uint16(400))
+	f.Add([]byte{1, 1}, bytes.Repeat([]byte{0xFF}, 64), uint16(500))
+	f.Add([]byte{7, 6, 6, 5, 5, 5, 4, 4, 4, 4, 3, 3, 3, 3, 3, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, bytes.Repeat([]byte{0x12, 0x34, 0x56, 0x78, 0x9A}, 200), uint16(1500))
+	f.Fuzz(func(t *testing.T, weights []byte, data []byte, size uint16) {
+		skipWithoutAsm(t)
+		htd := HuffmanTreeDesc{Weights: weights}
+		table, err := htd.Build()
+		if err != nil {
+			return
+		}
+		var streams [4][]byte
+		quarter := len(data) / 4
+		for i := range streams {
+			streams[i] = data[i*quarter : (i+1)*quarter]
+		}
+		decodeFourStreamsBoth(t, table, streams, int(size))
+	})
+}
+
+//decodeSequencesBoth decodes the sequences of ss with and without the assembly and compares the results
+func decodeSequencesBoth(t testing.TB, ss SequencesSection) {
+	asmBits, asmErr := ss.DecodeSequences()
+	asmSequences := append([]Sequence(nil), ss.Sequences...)
+
+	SetUseAsm(false)
+	goBits, goErr := ss.DecodeSequences()
+	SetUseAsm(true)
+
+	if asmErr != goErr || asmBits != goBits {
+		t.Fatalf("Assembly returned %v after %d bits, go %v after %d bits", asmErr, asmBits, goErr, goBits)
+	}
+	if !reflect.DeepEqual(asmSequences, ss.Sequences) {
+		t.Fatalf("Assembly decoded other sequences than go")
+	}
+}
+
+//sequencesWithTables makes a section that uses the predefined tables, or RLE tables if rle is set
+func sequencesWithTables(data []byte, number int, rle bool) SequencesSection {
+	ss := SequencesSection{Data: data}
+	ss.Header.NumberOfSequences = number
+	ss.LiteralLengthsFSEDecodingTable = fse.PredefinedLiteralLengthsTable
+	ss.MatchLengthsFSEDecodingTable = fse.PredefinedMatchLengthsTable
+	ss.OffsetsFSEDecodingTable = fse.PredefinedOffsetTable
+	if rle {
+		ss.LiteralLengthsFSEDecodingTable = fse.NewRLETable(fse.LiteralLengthBaseValueTranslation[35], fse.LiteralLengthExtraBits[35])
+		ss.MatchLengthsFSEDecodingTable = fse.NewRLETable(fse.MatchLengthBaseValueTranslation[52], fse.MatchLengthsExtraBits[52])
+		ss.OffsetsFSEDecodingTable = fse.NewRLETable(31, 0)
+	}
+	return ss
+}
+
+func TestAsmSequences(t *testing.T) {
+	skipWithoutAsm(t)
+
+	//every bitstream can be decoded, only the number of bits that are used does not fit most of the time
+	for _, size := range []int{1, 16, 17, 100, 4000} {
+		data := make([]byte, size)
+		rand.Read(data)
+		data[size-1] |= 1
+		for _, number := range []int{1, 2, 3, size / 10, size, 3 * size} {
+			if number < 1 {
+				continue
+			}
+			decodeSequencesBoth(t, sequencesWithTables(data, number, false))
+			decodeSequencesBoth(t, sequencesWithTables(data, number, true))
+		}
+	}
+}
+
+func FuzzAsmSequences(f *testing.F) {
+	f.Add(bytes.Repeat([]byte{0x5A, 0xC3, 0x81}, 100), uint16(40), false)
+	f.Add(bytes.Repeat([]byte{0xFF}, 64), uint16(10), true)
+	f.Add(bytes.Repeat([]byte{0x12, 0x34, 0x56, 0x78, 0x9A}, 200), uint16(1000), false)
+	f.Fuzz(func(t *testing.T, data []byte, number uint16, rle bool) {
+		skipWithoutAsm(t)
+		if len(data) == 0 || number == 0 {
+			return
+		}
+		decodeSequencesBoth(t, sequencesWithTables(data, int(number), rle))
+	})
+}
@@ -2,6 +2,7 @@ package structure
 
 import (
 	"errors"
+	"github.com/killingspark/sparkzstd/fse"
 )
 
 type Block struct {
@@ -25,6 +26,9 @@ type BlockHeader struct {
 	BlockSize uint64
 }
 
+//MaxBlockSize is the maximum size of a block. Blocks are never bigger than the window of their frame either
+const MaxBlockSize = 128 * 1024
+
 var ErrNotEnoughBytesForBlockHeader = errors.New("Not enough / too much bytes to decode the blockheader. Must be 3.")
 var ErrIllegalBlockType = errors.New("Illegal BlockType. Must be smaller than 3.")
 var ErrIllegalBlockSize = errors.New("Illegal block-size. Must be lower than 128kb")
@@ -46,10 +50,45 @@ func (bl *Block) DecodeHeader(raw []byte) error {
 		return ErrIllegalBlockType
 	}
 
-	//maximum 128KB
-	if bl.Header.BlockSize > (128 * 1024) {
+	if bl.Header.BlockSize > MaxBlockSize {
 		return ErrIllegalBlockSize
 	}
 
 	return nil
 }
+
+//Encode appends the 3 bytes of the header to dst. It is the inverse of Block.DecodeHeader
+func (bh *BlockHeader) Encode(dst []byte) []byte {
+	raw := uint32(bh.BlockSize)<<3 | uint32(bh.Type)<<1
+	if bh.LastBlock {
+		raw |= 1
+	}
+	return append(dst, byte(raw), byte(raw>>8), byte(raw>>16))
+}
+
+//TableBuffers are the tables the sections of a frame are decoded with. A new table of a kind replaces the table of that kind
+//from the previous block, so it can be built into the same buffer and one buffer per kind is enough for all blocks and frames.
+//Sections without TableBuffers allocate new tables
+type TableBuffers struct {
+	LiteralLengths *fse.FSETable
+	Offsets        *fse.FSETable
+	MatchLengths   *fse.FSETable
+	Huffman        *HuffmanDecodingTable
+
+	huffmanWeightsTable *fse.FSETable
+	huffmanWeights      []byte
+	huffmanNumBits      []int
+}
+
+//NewTableBuffers allocates the buffers for the biggest tables the current format allows
+func NewTableBuffers() *TableBuffers {
+	return &TableBuffers{
+		LiteralLengths:      fse.NewFSETable(MaxLiteralLengthsAccuracyLog),
+		Offsets:             fse.NewFSETable(MaxOffsetsAccuracyLog),
+		MatchLengths:        fse.NewFSETable(MaxMatchLengthsAccuracyLog),
+		Huffman:             &HuffmanDecodingTable{Entries: make([]uint16, 0, 1<<HuffmanMaxBits)},
+		huffmanWeightsTable: fse.NewFSETable(MaxWeightsAccuracyLog),
+		huffmanWeights:      make([]byte, 0, fse.MaxSymbols),
+		huffmanNumBits:      make([]int, 0, fse.MaxSymbols+1),
+	}
+}
@@ -0,0 +1,8 @@
+package structure
+
+//SetUseAsm switches the assembly decoders on or off for the differential tests. It returns the previous setting
+func SetUseAsm(enabled bool) bool {
+	previous := useAsm
+	useAsm = enabled
+	return previous
+}
@@ -71,8 +71,117 @@ func (fh *FrameHeader) SetWindowSize(raw byte) {
 	fh.WindowSize = windowBase + windowAdd
 }
 
+//EncodeWindowSize is the inverse of DecodeWindowSize. It returns the window descriptor of the smallest window that is at least
+//WindowSize bytes big and sets WindowSize to the size of that window. Sizes above the biggest window the format allows give that window
+func (fh *FrameHeader) EncodeWindowSize() byte {
+	if fh.WindowSize < MinWindowSize {
+		fh.WindowSize = MinWindowSize
+	}
+	exp := byte(0)
+	for uint64(1)<<(10+exp+1) <= fh.WindowSize && exp < maxWindowExponent {
+		exp++
+	}
+	windowBase := uint64(1) << (10 + exp)
+	mantissa := (fh.WindowSize - windowBase + windowBase/8 - 1) / (windowBase / 8)
+	if mantissa > 7 && exp < maxWindowExponent {
+		//rounded up to the next power of two
+		exp++
+		mantissa = 0
+	} else if mantissa > 7 {
+		//the biggest window the format allows
+		mantissa = 7
+	}
+	raw := exp<<3 | byte(mantissa)
+	fh.DecodeWindowSize(raw)
+	return raw
+}
+
+//MinWindowSize is the smallest window a window descriptor can express
+const MinWindowSize = 1 << 10
+
+//maxWindowExponent is the biggest exponent of a window descriptor. The window log is 10 bigger
+const maxWindowExponent = 31
+
+var ErrContentSizeTooSmall = errors.New("A content size field of 2 bytes can not hold content sizes below 256")
+
+//Encode appends the header without the magic number to dst. It is the inverse of the decoding: the Descriptor decides which of
+//the fields are written and how big the content size field is. The window descriptor is made with EncodeWindowSize
+func (fh *FrameHeader) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(fh.Descriptor))
+	if !fh.Descriptor.GetSingleSegmentFlag() {
+		dst = append(dst, fh.EncodeWindowSize())
+	}
+
+	dictIDsize, err := fh.Descriptor.GetDictionaryFlag()
+	if err != nil {
+		return dst, err
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], fh.DictionaryID)
+	dst = append(dst, buf[:dictIDsize]...)
+
+	framecontentsize, err := fh.Descriptor.GetContentSizeFlag()
+	if err != nil {
+		return dst, err
+	}
+	contentSize := fh.FrameContentSize
+	if framecontentsize == 2 {
+		if contentSize < 256 {
+			return dst, ErrContentSizeTooSmall
+		}
+		contentSize -= 256
+	}
+	binary.LittleEndian.PutUint64(buf[:], contentSize)
+	return append(dst, buf[:framecontentsize]...), nil
+}
+
 type FrameDescriptor byte
 
+//NewFrameDescriptor makes the descriptor for a frame without dictionary. contentSizeBytes is the size of the content size field,
+//it can be 0, 2, 4 or 8 and also 1 for single segment frames
+func NewFrameDescriptor(contentSizeBytes byte, singleSegment bool, checksum bool) (FrameDescriptor, error) {
+	var fd FrameDescriptor
+	switch contentSizeBytes {
+	case 0:
+		if singleSegment {
+			return 0, ErrIllegalContentSizeFlag
+		}
+	case 1:
+		if !singleSegment {
+			return 0, ErrIllegalContentSizeFlag
+		}
+	case 2:
+		fd = 1 << 6
+	case 4:
+		fd = 2 << 6
+	case 8:
+		fd = 3 << 6
+	default:
+		return 0, ErrIllegalContentSizeFlag
+	}
+	if singleSegment {
+		fd |= 1 << 5
+	}
+	if checksum {
+		fd |= 1 << 2
+	}
+	return fd, nil
+}
+
+//ContentSizeBytes returns the size of the smallest content size field that can hold size
+func ContentSizeBytes(size uint64, singleSegment bool) byte {
+	switch {
+	case size < 256 && singleSegment:
+		return 1
+	case size >= 256 && size <= 0xFFFF+256:
+		return 2
+	case size <= 0xFFFFFFFF:
+		return 4
+	default:
+		return 8
+	}
+}
+
 var ErrIllegalContentSizeFlag = errors.New("The SizeFlag for the Field ContentSize has an illegal value bigger than 3")
 
 //GetContentSizeFlag returns the number of bytes the Field FrameContentSize uses
@@ -0,0 +1,78 @@
+package structure
+
+import (
+	"testing"
+)
+
+func TestEncodeWindowSize(t *testing.T) {
+	for _, size := range []uint64{0, 1, 1024, 1025, 1152, 1153, 128 * 1024, 3 << 20, 1<<41 + 7<<38, 1 << 50} {
+		fh := FrameHeader{WindowSize: size}
+		raw := fh.EncodeWindowSize()
+		decoded := FrameHeader{}
+		decoded.DecodeWindowSize(raw)
+		if decoded.WindowSize != fh.WindowSize {
+			t.Errorf("%d: Encoded window %d decodes to %d", size, fh.WindowSize, decoded.WindowSize)
+		}
+		if fh.WindowSize < size && size <= 1<<41+7<<38 {
+			t.Errorf("%d: Window got smaller: %d", size, fh.WindowSize)
+		}
+		//the next smaller descriptor must be too small
+		if raw > 0 {
+			decoded.DecodeWindowSize(raw - 1)
+			if decoded.WindowSize >= size {
+				t.Errorf("%d: Window %d is not the smallest one, %d is big enough", size, fh.WindowSize, decoded.WindowSize)
+			}
+		}
+	}
+}
+
+func TestEncodeFrameHeader(t *testing.T) {
+	for _, singleSegment := range []bool{false, true} {
+		for _, size := range []uint64{0, 255, 256, 0xFFFF + 256, 0xFFFF + 257, 0xFFFFFFFF, 1 << 40} {
+			descriptor, err := NewFrameDescriptor(ContentSizeBytes(size, singleSegment), singleSegment, true)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			fh := FrameHeader{Descriptor: descriptor, WindowSize: 128 * 1024, FrameContentSize: size}
+			raw, err := fh.Encode(nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			decoded := FrameHeader{}
+			decoded.DecodeFrameDescriptor(raw[0])
+			raw = raw[1:]
+			if !decoded.Descriptor.GetContentChecksumFlag() || decoded.Descriptor.GetSingleSegmentFlag() != singleSegment {
+				t.Errorf("%d: Wrong flags in the descriptor %x", size, raw[0])
+			}
+			if !singleSegment {
+				decoded.DecodeWindowSize(raw[0])
+				raw = raw[1:]
+				if decoded.WindowSize != fh.WindowSize {
+					t.Errorf("%d: Wrong window size: %d", size, decoded.WindowSize)
+				}
+			}
+			contentSizeBytes, _ := decoded.Descriptor.GetContentSizeFlag()
+			if int(contentSizeBytes) != len(raw) {
+				t.Fatalf("%d: Content size field has %d bytes but %d are left", size, contentSizeBytes, len(raw))
+			}
+			decoded.DecodeFrameContentSize(raw)
+			if decoded.FrameContentSize != size {
+				t.Errorf("Wrong content size: %d, should be: %d", decoded.FrameContentSize, size)
+			}
+		}
+	}
+}
+
+func TestEncodeBlockHeader(t *testing.T) {
+	for _, header := range []BlockHeader{{true, BlockTypeRaw, 0}, {false, BlockTypeRLE, 128 * 1024}, {true, BlockTypeCompressed, 12345}} {
+		block := Block{}
+		err := block.DecodeHeader(header.Encode(nil))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if block.Header != header {
+			t.Errorf("Header %+v decoded to %+v", header, block.Header)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package structure
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"github.com/killingspark/sparkzstd/bitstream"
 	"github.com/killingspark/sparkzstd/fse"
@@ -21,19 +20,18 @@ type HuffmanTreeDesc struct {
 	LengthInByte    int //only relevant if type == Compressed
 	NumberOfWeights int //only relevant if type == Direct
 
-	NumBits map[int]int `json:"-"`
-	Weights []byte      `json:"-"`
+	NumBits []int  `json:"-"` //number of bits of the code for each symbol. Filled by Build
+	Weights []byte `json:"-"`
 
-	DecodingTable map[int]int
+	weightsTable         *fse.FSETable         //reused for the table of compressed weights if not nil
+	weightsEncodingTable *fse.FSEEncodingTable //made by Encode when it is needed first
 }
 
+//HuffmanDecodingTable is a flat table that is indexed by the next MaxBits bits of a stream. Each entry holds the symbol
+//in the upper byte and the number of bits of its code in the lower byte
 type HuffmanDecodingTable struct {
 	MaxBits int
-
-	NumberOfBits []int
-	Symbols      []int
-
-	State int
+	Entries []uint16
 }
 
 //DecodeFramStream returns number of bytes used
@@ -49,45 +47,54 @@ func (htd *HuffmanTreeDesc) DecodeFromStream(source *bufio.Reader) (int, error)
 		htd.Type = HuffmanEncodingTypeCompressed
 		htd.LengthInByte = int(header)
 
-		fset := fse.FSETable{}
+		fset := htd.weightsTable
+		if fset == nil {
+			fset = fse.NewFSETable(MaxWeightsAccuracyLog)
+		}
 		bs, err := fset.ReadTabledescriptionFromBitstream(source)
 		bytesRead += bs
 		if err != nil {
 			return bytesRead, err
 		}
+		if fset.AccuracyLog > MaxWeightsAccuracyLog {
+			return bytesRead, ErrCorruptedHuffTree
+		}
 
 		err = fset.BuildDecodingTable(nil, nil)
 		if err != nil {
 			return bytesRead, err
 		}
-		// Need to copy because we read two interleaved streams but with the same decoding table.
-		// This shallow copy is enough because we only need to have separate states. The decoding table can be shared
-		fset2 := fset
 
+		//the stream is at most 127 bytes, so it can be decoded directly from the buffer of the source
 		bitStreamLength := htd.LengthInByte - bs
-		buffer := make([]byte, bitStreamLength)
-		read, err := io.ReadFull(source, buffer)
-		bytesRead += read
+		if bitStreamLength < 0 {
+			return bytesRead, ErrCorruptedHuffTree
+		}
+		stream, err := source.Peek(bitStreamLength)
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return bytesRead, err
 		}
 
-		weightsOutput := bytes.Buffer{}
-		fse.DecodeInterleavedFSEStreams([]*fse.FSETable{&fset, &fset2}, buffer, &weightsOutput)
-
-		htd.Weights = weightsOutput.Bytes()
-
-		//for _, b := range htd.Weights {
-		//	print(b)
-		//	print(" ")
-		//}
-		//println("")
+		//two interleaved streams with separate states but the same decoding table
+		states := [2]fse.FSEState{{Table: fset}, {Table: fset}}
+		htd.Weights, _, err = fse.AppendInterleavedFSEStreams(htd.Weights[:0], states[:], stream)
+		read, _ := source.Discard(len(stream))
+		bytesRead += read
+		if err != nil {
+			return bytesRead, err
+		}
 
 	} else {
 		htd.Type = HuffmanEncodingTypeDirect
 		htd.NumberOfWeights = int(header - 127)
 
-		htd.Weights = make([]byte, htd.NumberOfWeights)
+		if cap(htd.Weights) < htd.NumberOfWeights {
+			htd.Weights = make([]byte, htd.NumberOfWeights)
+		}
+		htd.Weights = htd.Weights[:htd.NumberOfWeights]
 		var buf byte
 		for i := 0; i < htd.NumberOfWeights; i++ {
 			if i%2 == 0 {
@@ -109,36 +116,61 @@ func (htd *HuffmanTreeDesc) DecodeFromStream(source *bufio.Reader) (int, error)
 var ErrWrongSumOfWeights = errors.New("The weights didnt leave a power of two for the last weight")
 var ErrCorruptedHuffTree = errors.New("The tree in the description is corrupted")
 
+//MaxWeightsAccuracyLog is the maximum accuracy log of the fse table for compressed huffman weights
+const MaxWeightsAccuracyLog = 6
+
+//HuffmanMaxBits is the longest code a huffman table can have. The current format allows 11 bits, the legacy formats 12
+const HuffmanMaxBits = 12
+
+//Build builds a new decoding table from the weights
 func (htd *HuffmanTreeDesc) Build() (*HuffmanDecodingTable, error) {
+	table := &HuffmanDecodingTable{}
+	err := htd.BuildInto(table)
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+//BuildInto builds the decoding table from the weights into table. The entries of table are reused if they are big enough
+func (htd *HuffmanTreeDesc) BuildInto(table *HuffmanDecodingTable) error {
 	sum := uint64(0)
 	for _, w := range htd.Weights {
+		if w > HuffmanMaxBits {
+			return ErrCorruptedHuffTree
+		}
 		weight := uint64(0)
 		if w > 0 {
 			weight = uint64(1) << uint(w-1)
 		}
 		sum += weight
 	}
-
-	//print("Weightsum: ")
-	//println(sum)
+	if sum == 0 {
+		return ErrCorruptedHuffTree
+	}
 
 	log := fse.BIT_highbit32(uint32(sum)) + 1
 	actualSum := uint64(1) << log
 	leftOver := actualSum - sum
 	if leftOver&(leftOver-1) != 0 {
-		return nil, ErrWrongSumOfWeights
+		return ErrWrongSumOfWeights
 	}
 	lastWeight := fse.BIT_highbit32(uint32(leftOver)) + 1
 
-	maxBits := log
-	htd.NumBits = make(map[int]int, len(htd.Weights)+1)
-
-	rankCount := make([]int, maxBits+1)
+	maxBits := int(log)
+	if maxBits > HuffmanMaxBits {
+		return ErrCorruptedHuffTree
+	}
+	if cap(htd.NumBits) < len(htd.Weights)+1 {
+		htd.NumBits = make([]int, len(htd.Weights)+1)
+	}
+	htd.NumBits = htd.NumBits[:len(htd.Weights)+1]
 
+	var rankCount [HuffmanMaxBits + 1]int
 	for idx, w := range htd.Weights {
 		nob := 0
 		if w > 0 {
-			nob = int(maxBits) + 1 - int(w)
+			nob = maxBits + 1 - int(w)
 		}
 		htd.NumBits[idx] = nob
 		rankCount[nob]++
@@ -146,7 +178,7 @@ func (htd *HuffmanTreeDesc) Build() (*HuffmanDecodingTable, error) {
 
 	lastNob := 0
 	if lastWeight > 0 {
-		lastNob = int(maxBits) + 1 - int(lastWeight)
+		lastNob = maxBits + 1 - int(lastWeight)
 	}
 	htd.NumBits[len(htd.Weights)] = lastNob
 	rankCount[lastNob]++
@@ -155,110 +187,132 @@ func (htd *HuffmanTreeDesc) Build() (*HuffmanDecodingTable, error) {
 	//##Actually fill the Table
 	//########
 
-	table := HuffmanDecodingTable{}
-	table.MaxBits = int(maxBits)
-	table.Symbols = make([]int, 1<<maxBits)
-	table.NumberOfBits = make([]int, 1<<maxBits)
+	table.MaxBits = maxBits
+	if cap(table.Entries) < 1<<uint(maxBits) {
+		table.Entries = make([]uint16, 1<<uint(maxBits))
+	}
+	table.Entries = table.Entries[:1<<uint(maxBits)]
 
-	rankIdx := make([]int, maxBits+1)
+	//codes with fewer bits come first in the table. rankIdx[n] is where the codes with n bits start
+	var rankIdx [HuffmanMaxBits + 1]int
 	for i := maxBits; i >= 1; i-- {
-		rankIdx[i-1] = rankIdx[i] + rankCount[i]*(1<<(maxBits-i))
-
-		base := rankIdx[i]
-		for j := 0; j < rankIdx[i-1]-rankIdx[i]; j++ {
-			table.NumberOfBits[base+j] = int(i)
-		}
+		rankIdx[i-1] = rankIdx[i] + rankCount[i]*(1<<uint(maxBits-i))
 	}
-
-	if rankIdx[0] != len(table.NumberOfBits) {
-		return nil, ErrCorruptedHuffTree
+	if rankIdx[0] != len(table.Entries) {
+		return ErrCorruptedHuffTree
 	}
 
-	for i := 0; i < len(htd.NumBits); i++ {
-		if htd.NumBits[i] != 0 {
-			code := rankIdx[htd.NumBits[i]]
-			len := 1 << uint(int(maxBits)-htd.NumBits[i])
+	for symbol, nob := range htd.NumBits {
+		if nob != 0 {
+			code := rankIdx[nob]
+			len := 1 << uint(maxBits-nob)
 
+			entry := uint16(symbol)<<8 | uint16(nob)
 			for j := 0; j < len; j++ {
-				table.Symbols[code+j] = i
+				table.Entries[code+j] = entry
 			}
-			rankIdx[htd.NumBits[i]] += len
+			rankIdx[nob] += len
 		}
 	}
 
-	return &table, nil
+	return nil
 }
 
-func (ht *HuffmanDecodingTable) InitState(source *bitstream.Reversebitstream) error {
-	state, err := source.Read(ht.MaxBits)
-	ht.State = int(state)
-	return err
-}
-
-//returns bits, symbol, error
-func (ht *HuffmanDecodingTable) DecodeSymbol(source *bitstream.Reversebitstream) (int, int, error) {
-	symbol := ht.Symbols[ht.State]
-	bits := ht.NumberOfBits[ht.State]
-	rest, err := source.Read(bits)
-	//print("Bits")
-	//println(bits)
-	//print("Bitsleft")
-	//println(source.BitsStillInStream())
-	//print("Rest: ")
-	//println(rest)
+var ErrBadPadding = errors.New("The padding at the end of the stream was more than a byte. Data is likely corrupted")
+var ErrDidntUseAllBitsToDecodeHuffman = errors.New("Didnt read all bits to decode huffman stream. Data is likely corrupted")
 
+//initHuffmanReader prepares br for the stream in data and skips the padding
+func initHuffmanReader(br *bitstream.ReverseReader, data []byte) error {
+	br.Init(data)
+	_, err := br.SkipPadding()
 	if err != nil {
-		return 0, 0, err
+		return ErrBadPadding
 	}
-
-	ht.State = int(uint16((ht.State<<uint(bits))+int(rest)) & ((uint16(1) << uint(ht.MaxBits)) - 1))
-	return bits, symbol, nil
+	return nil
 }
 
-var ErrBadPadding = errors.New("The padding at the end of the stream was more than a byte. Data is likely corrupted")
-var ErrDidntUseAllBitsToDecodeHuffman = errors.New("Didnt read all bits to decode huffman stream. Data is likely corrupted")
+//decodeSymbol peeks MaxBits bits to find the symbol and consumes the bits of its code
+func (ht *HuffmanDecodingTable) decodeSymbol(br *bitstream.ReverseReader) byte {
+	entry := ht.Entries[br.Peek(uint(ht.MaxBits))]
+	br.Consume(uint(entry & 0xFF))
+	return byte(entry >> 8)
+}
 
+//DecodeStream decodes exactly len(output) symbols from data. The stream has to be used up exactly by them
 func (ht *HuffmanDecodingTable) DecodeStream(data, output []byte) (int, error) {
-	bitsum := 0
-	bitsrc := bitstream.NewReversebitstream(data)
-
-	var err error
-	//need to read bits from the stream (the back of the data...) until the first 1 arrives
-	x := uint64(0)
-	for x == 0 && bitsum <= 8 {
-		x, err = bitsrc.Read(1)
-		if err != nil {
-			return 0, err
-		}
-		bitsum++
+	br := bitstream.ReverseReader{}
+	err := initHuffmanReader(&br, data)
+	if err != nil {
+		return 0, err
 	}
 
-	if bitsum > 8 {
-		return bitsum, ErrBadPadding
+	//after a reload there are enough bits for four symbols
+	i := 0
+	for ; i+4 <= len(output); i += 4 {
+		br.Reload()
+		output[i] = ht.decodeSymbol(&br)
+		output[i+1] = ht.decodeSymbol(&br)
+		output[i+2] = ht.decodeSymbol(&br)
+		output[i+3] = ht.decodeSymbol(&br)
 	}
-
-	err = ht.InitState(bitsrc)
-	if err != nil {
-		return 0, err
+	br.Reload()
+	for ; i < len(output); i++ {
+		output[i] = ht.decodeSymbol(&br)
 	}
-	bitsum += ht.MaxBits
 
-	totalOutput := 0
+	if !br.Finished() {
+		return i, ErrDidntUseAllBitsToDecodeHuffman
+	}
+	return i, nil
+}
 
-	for i := 0; bitsrc.BitsStillInStream()+1 > -ht.MaxBits; i++ {
-		bits, symbol, err := ht.DecodeSymbol(bitsrc)
-		bitsum += bits
+//DecodeFourStreams decodes the four streams interleaved, so the CPU can work on all of them at the same time.
+//The first three streams decode to (len(output)+3)/4 bytes each, the last one to the rest of output
+func (ht *HuffmanDecodingTable) DecodeFourStreams(streams [4][]byte, output []byte) error {
+	segment := (len(output) + 3) / 4
+	if 3*segment > len(output) {
+		return ErrStreamDidntDecodeToRightLength
+	}
+	out1 := output[:segment]
+	out2 := output[segment : 2*segment]
+	out3 := output[2*segment : 3*segment]
+	out4 := output[3*segment:]
+
+	var br1, br2, br3, br4 bitstream.ReverseReader
+	for i, br := range [4]*bitstream.ReverseReader{&br1, &br2, &br3, &br4} {
+		err := initHuffmanReader(br, streams[i])
 		if err != nil {
-			return totalOutput, err
+			return err
 		}
-		output[i] = byte(symbol)
-		totalOutput++
 	}
-	if bitsrc.BitsStillInStream()+1 != -ht.MaxBits {
-		println(bitsrc.BitsStillInStream() + 1)
-		println(-ht.MaxBits)
-		return totalOutput, ErrDidntUseAllBitsToDecodeHuffman
+
+	//the last stream is the shortest, as long as it has output all four can be decoded together
+	i := ht.decodeFourStreamsAsm([4]*bitstream.ReverseReader{&br1, &br2, &br3, &br4}, [4][]byte{out1, out2, out3, out4}, len(out4))
+	for ; i+4 <= len(out4); i += 4 {
+		br1.Reload()
+		br2.Reload()
+		br3.Reload()
+		br4.Reload()
+		for j := i; j < i+4; j++ {
+			out1[j] = ht.decodeSymbol(&br1)
+			out2[j] = ht.decodeSymbol(&br2)
+			out3[j] = ht.decodeSymbol(&br3)
+			out4[j] = ht.decodeSymbol(&br4)
+		}
 	}
 
-	return totalOutput, nil
+	//the rest of each stream are only a few symbols
+	for _, rest := range [4]struct {
+		br  *bitstream.ReverseReader
+		out []byte
+	}{{&br1, out1}, {&br2, out2}, {&br3, out3}, {&br4, out4}} {
+		for j := i; j < len(rest.out); j++ {
+			rest.br.Reload()
+			rest.out[j] = ht.decodeSymbol(rest.br)
+		}
+		if !rest.br.Finished() {
+			return ErrDidntUseAllBitsToDecodeHuffman
+		}
+	}
+	return nil
 }
@@ -0,0 +1,132 @@
+package structure
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+//encodeHuffman writes the symbols as a backwards stream, so the first symbol is read first
+func encodeHuffman(t testing.TB, table *HuffmanDecodingTable, symbols []byte) []byte {
+	value := big.NewInt(1) //marks the start of the stream
+	for _, s := range symbols {
+		found := false
+		for idx, entry := range table.Entries {
+			if byte(entry>>8) == s {
+				nob := uint(entry & 0xFF)
+				code := int64(idx >> (uint(table.MaxBits) - nob))
+				value.Lsh(value, nob)
+				value.Or(value, big.NewInt(code))
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Symbol %d has no code", s)
+		}
+	}
+
+	//big.Int gives big endian bytes
+	be := value.Bytes()
+	le := make([]byte, len(be))
+	for i := range be {
+		le[i] = be[len(be)-1-i]
+	}
+	return le
+}
+
+func TestHuffmanStreams(t *testing.T) {
+	htd := HuffmanTreeDesc{Weights: []byte{4, 3, 2, 0, 1, 3, 2, 1, 1, 1}}
+	table, err := htd.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	alphabet := []byte{0, 1, 2, 4, 5, 6, 7, 8, 9, 10} //10 gets the weight that is left over
+	for _, size := range []int{0, 1, 7, 100, 1003} {
+		symbols := make([]byte, size)
+		for i := range symbols {
+			symbols[i] = alphabet[rand.Intn(len(alphabet))]
+		}
+
+		output := make([]byte, size)
+		_, err := table.DecodeStream(encodeHuffman(t, table, symbols), output)
+		if err != nil {
+			t.Fatalf("Size %d: %s", size, err.Error())
+		}
+		if !bytes.Equal(output, symbols) {
+			t.Errorf("Size %d: Wrong symbols decoded from one stream", size)
+		}
+
+		if size < 6 {
+			continue
+		}
+		var streams [4][]byte
+		segment := (size + 3) / 4
+		for i := range streams {
+			high := (i + 1) * segment
+			if i == 3 {
+				high = size
+			}
+			streams[i] = encodeHuffman(t, table, symbols[i*segment:high])
+		}
+		output = make([]byte, size)
+		err = table.DecodeFourStreams(streams, output)
+		if err != nil {
+			t.Fatalf("Size %d: %s", size, err.Error())
+		}
+		if !bytes.Equal(output, symbols) {
+			t.Errorf("Size %d: Wrong symbols decoded from four streams", size)
+		}
+
+		//one symbol too few leaves bits in the stream
+		_, err = table.DecodeStream(encodeHuffman(t, table, symbols), output[:size-1])
+		if err != ErrDidntUseAllBitsToDecodeHuffman {
+			t.Errorf("Size %d: Unused bits were not detected", size)
+		}
+	}
+}
+
+func BenchmarkHuffmanBuild(b *testing.B) {
+	htd := HuffmanTreeDesc{Weights: []byte{7, 6, 6, 5, 5, 5, 4, 4, 4, 4, 3, 3, 3, 3, 3, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}
+	table := &HuffmanDecodingTable{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := htd.BuildInto(table)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkHuffmanFourStreams(b *testing.B) {
+	htd := HuffmanTreeDesc{Weights: []byte{4, 3, 2, 0, 1, 3, 2, 1, 1, 1}}
+	table, err := htd.Build()
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	alphabet := []byte{0, 1, 2, 4, 5, 6, 7, 8, 9, 10}
+	symbols := make([]byte, 16*1024)
+	for i := range symbols {
+		symbols[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	var streams [4][]byte
+	segment := len(symbols) / 4
+	for i := range streams {
+		streams[i] = encodeHuffman(b, table, symbols[i*segment:(i+1)*segment])
+	}
+
+	output := make([]byte, len(symbols))
+	b.SetBytes(int64(len(symbols)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := table.DecodeFourStreams(streams, output)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package structure
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"github.com/killingspark/sparkzstd/fse"
+	"sort"
+)
+
+//HuffmanMaxEncodingBits is the longest code the encoder makes. It is the limit of the current format
+const HuffmanMaxEncodingBits = 11
+
+//maxDirectWeights is the most weights that can be written with 4 bits each, the header can not tell more
+const maxDirectWeights = 128
+
+//maxCompressedWeightsSize is the biggest size of compressed weights the header can tell
+const maxCompressedWeightsSize = 127
+
+var ErrTooFewHuffmanSymbols = errors.New("Huffman coding needs at least two different symbols")
+var ErrHuffmanTreeTooBig = errors.New("The weights of the huffman tree can not be written in the tree description")
+var ErrHuffmanStreamTooBig = errors.New("A huffman stream is too big for the jump table")
+var ErrNoHuffmanCode = errors.New("A symbol has no code in the huffman table")
+
+//HuffmanEncodingTable is the counterpart of HuffmanDecodingTable for the encoder. It holds the code of every symbol
+type HuffmanEncodingTable struct {
+	MaxBits   int
+	MaxSymbol int
+	NumBits   [fse.MaxSymbols]byte //0 for symbols without code
+	codes     [fse.MaxSymbols]uint16
+}
+
+//huffmanNode is a leaf or an inner node of the tree while the code lengths are searched
+type huffmanNode struct {
+	count  int
+	parent int
+}
+
+//Build builds the codes for symbols that appear counts[symbol] times. No code is longer than maxBits and the codes are
+//assigned exactly like HuffmanTreeDesc.BuildInto assigns them from the weights
+func (het *HuffmanEncodingTable) Build(counts []int, maxBits int) error {
+	if maxBits > HuffmanMaxEncodingBits {
+		maxBits = HuffmanMaxEncodingBits
+	}
+	if len(counts) > fse.MaxSymbols {
+		return fse.ErrTooManySymbols
+	}
+	symbols := make([]int, 0, fse.MaxSymbols)
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) < 2 {
+		return ErrTooFewHuffmanSymbols
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return counts[symbols[i]] < counts[symbols[j]]
+	})
+
+	//the two smallest leaves or inner nodes are merged until only the root is left. The inner nodes are made in
+	//ascending order, so the smallest ones are always at the front of the leaves and of the inner nodes
+	n := len(symbols)
+	var nodes [2*fse.MaxSymbols - 1]huffmanNode
+	for i, symbol := range symbols {
+		nodes[i].count = counts[symbol]
+	}
+	leaf, inner := 0, n
+	for next := n; next < 2*n-1; next++ {
+		var children [2]int
+		for c := range children {
+			if leaf < n && (inner == next || nodes[leaf].count <= nodes[inner].count) {
+				children[c] = leaf
+				leaf++
+			} else {
+				children[c] = inner
+				inner++
+			}
+		}
+		nodes[next].count = nodes[children[0]].count + nodes[children[1]].count
+		nodes[children[0]].parent = next
+		nodes[children[1]].parent = next
+	}
+
+	//the depth of the nodes is their code length. Parents come after their children
+	var depth [2*fse.MaxSymbols - 1]int
+	for i := 2*n - 3; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+
+	het.NumBits = [fse.MaxSymbols]byte{}
+	for i, symbol := range symbols {
+		if depth[i] > maxBits {
+			depth[i] = maxBits
+		}
+		het.NumBits[symbol] = byte(depth[i])
+	}
+	het.limitLengths(symbols, maxBits)
+
+	het.MaxSymbol = 0
+	het.MaxBits = 0
+	for symbol, bits := range het.NumBits {
+		if bits > 0 {
+			het.MaxSymbol = symbol
+		}
+		if int(bits) > het.MaxBits {
+			het.MaxBits = int(bits)
+		}
+	}
+	het.assignCodes()
+	return nil
+}
+
+//limitLengths repairs the code lengths after they were cut to maxBits, so they make a full tree again. The sum of 2^(maxBits-bits)
+//over all symbols is 2^maxBits for a full tree. symbols has to be sorted by ascending count
+func (het *HuffmanEncodingTable) limitLengths(symbols []int, maxBits int) {
+	limit := 1 << uint(maxBits)
+	sum := 0
+	for _, symbol := range symbols {
+		sum += 1 << uint(maxBits-int(het.NumBits[symbol]))
+	}
+
+	//too many short codes: the rarest symbols get longer codes until the codes fit
+	for _, symbol := range symbols {
+		for sum > limit && int(het.NumBits[symbol]) < maxBits {
+			het.NumBits[symbol]++
+			sum -= 1 << uint(maxBits-int(het.NumBits[symbol]))
+		}
+	}
+
+	//space is left: the most common symbols get shorter codes. The longest code always fits into what is left
+	for sum < limit {
+		for i := len(symbols) - 1; i >= 0; i-- {
+			symbol := symbols[i]
+			for het.NumBits[symbol] > 1 && sum+1<<uint(maxBits-int(het.NumBits[symbol])) <= limit {
+				sum += 1 << uint(maxBits-int(het.NumBits[symbol]))
+				het.NumBits[symbol]--
+			}
+		}
+	}
+}
+
+//assignCodes gives every symbol its code. The codes of one length are consecutive in the order of the symbols and the longest codes come first
+func (het *HuffmanEncodingTable) assignCodes() {
+	var rankCount [HuffmanMaxEncodingBits + 1]int
+	for _, bits := range het.NumBits {
+		rankCount[bits]++
+	}
+	var rankIdx [HuffmanMaxEncodingBits + 1]int
+	for i := het.MaxBits; i >= 1; i-- {
+		rankIdx[i-1] = rankIdx[i] + rankCount[i]<<uint(het.MaxBits-i)
+	}
+	for symbol, bits := range het.NumBits {
+		het.codes[symbol] = 0
+		if bits > 0 {
+			het.codes[symbol] = uint16(rankIdx[bits] >> uint(het.MaxBits-int(bits)))
+			rankIdx[bits] += 1 << uint(het.MaxBits-int(bits))
+		}
+	}
+}
+
+//Weights appends the weights of the symbols to dst. The weight of MaxSymbol is left out, the decoder infers it
+func (het *HuffmanEncodingTable) Weights(dst []byte) []byte {
+	for symbol := 0; symbol < het.MaxSymbol; symbol++ {
+		weight := byte(0)
+		if het.NumBits[symbol] > 0 {
+			weight = byte(het.MaxBits) + 1 - het.NumBits[symbol]
+		}
+		dst = append(dst, weight)
+	}
+	return dst
+}
+
+//EstimateBits returns the number of bits the symbols with the counts are encoded with, without the padding of the streams.
+//It returns -1 if a symbol has no code
+func (het *HuffmanEncodingTable) EstimateBits(counts []int) int {
+	bits := 0
+	for symbol, count := range counts {
+		if count == 0 {
+			continue
+		}
+		if het.NumBits[symbol] == 0 {
+			return -1
+		}
+		bits += count * int(het.NumBits[symbol])
+	}
+	return bits
+}
+
+//AppendStream appends data encoded as one stream to dst, which DecodeStream decodes back
+func (het *HuffmanEncodingTable) AppendStream(dst []byte, data []byte) []byte {
+	bw := bitstream.NewReverseWriter(dst)
+	//the first symbol is read first, so it is written last
+	for i := len(data) - 1; i >= 0; i-- {
+		bw.Write(uint64(het.codes[data[i]]), uint(het.NumBits[data[i]]))
+	}
+	return bw.Finish()
+}
+
+//AppendFourStreams appends the jump table and data encoded as the four streams DecodeFourStreams decodes.
+//The first three streams encode (len(data)+3)/4 bytes each, the last one the rest
+func (het *HuffmanEncodingTable) AppendFourStreams(dst []byte, data []byte) ([]byte, error) {
+	segment := (len(data) + 3) / 4
+	if 3*segment > len(data) {
+		return dst, ErrStreamDidntDecodeToRightLength
+	}
+	jumpTable := len(dst)
+	dst = append(dst, make([]byte, 6)...)
+	for i := 0; i < 4; i++ {
+		end := (i + 1) * segment
+		if i == 3 {
+			end = len(data)
+		}
+		start := len(dst)
+		dst = het.AppendStream(dst, data[i*segment:end])
+		if i < 3 {
+			if len(dst)-start > 0xFFFF {
+				return dst, ErrHuffmanStreamTooBig
+			}
+			binary.LittleEndian.PutUint16(dst[jumpTable+2*i:], uint16(len(dst)-start))
+		}
+	}
+	return dst, nil
+}
+
+//Encode appends the header byte and the weights to dst. It is the inverse of DecodeFromStream. The weights are compressed
+//with FSE if that is smaller than writing them directly. Type, LengthInByte and NumberOfWeights tell what was written
+func (htd *HuffmanTreeDesc) Encode(dst []byte) ([]byte, error) {
+	start := len(dst)
+	directSize := 1 + (len(htd.Weights)+1)/2
+	dst, err := htd.appendCompressedWeights(dst)
+	if err == nil && (len(dst)-start < directSize || len(htd.Weights) > maxDirectWeights) {
+		return dst, nil
+	}
+	dst = dst[:start]
+	if len(htd.Weights) > maxDirectWeights {
+		return dst, ErrHuffmanTreeTooBig
+	}
+
+	htd.Type = HuffmanEncodingTypeDirect
+	htd.NumberOfWeights = len(htd.Weights)
+	dst = append(dst, byte(127+htd.NumberOfWeights))
+	for i := 0; i < len(htd.Weights); i += 2 {
+		b := htd.Weights[i] << 4
+		if i+1 < len(htd.Weights) {
+			b |= htd.Weights[i+1] & 0xF
+		}
+		dst = append(dst, b)
+	}
+	return dst, nil
+}
+
+//appendCompressedWeights appends the header, the fse table description and the two interleaved streams of the weights
+func (htd *HuffmanTreeDesc) appendCompressedWeights(dst []byte) ([]byte, error) {
+	var countsBuf [HuffmanMaxBits + 1]int
+	counts := fse.Histogram(countsBuf[:0], htd.Weights)
+	for _, count := range counts {
+		if count == len(htd.Weights) {
+			//one weight alone would be encoded with 0 bits and the decoder could not find the end of the streams
+			return dst, ErrHuffmanTreeTooBig
+		}
+	}
+
+	if htd.weightsEncodingTable == nil {
+		htd.weightsEncodingTable = fse.NewFSEEncodingTable(MaxWeightsAccuracyLog)
+	}
+	table := htd.weightsEncodingTable
+	err := table.Normalize(counts, fse.OptimalAccuracyLog(MaxWeightsAccuracyLog, len(htd.Weights), len(counts)-1))
+	if err != nil {
+		return dst, err
+	}
+
+	start := len(dst)
+	dst = table.AppendTabledescription(append(dst, 0))
+	states := [2]fse.FSEEncoderState{{Table: table}, {Table: table}}
+	dst, err = fse.EncodeInterleavedFSEStreams(dst, states[:], htd.Weights)
+	if err != nil {
+		return dst, err
+	}
+	if len(dst)-start-1 > maxCompressedWeightsSize {
+		return dst, ErrHuffmanTreeTooBig
+	}
+	htd.Type = HuffmanEncodingTypeCompressed
+	htd.LengthInByte = len(dst) - start - 1
+	dst[start] = byte(htd.LengthInByte)
+	return dst, nil
+}
@@ -0,0 +1,229 @@
+package structure
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/killingspark/sparkzstd/fse"
+	"math/rand"
+	"testing"
+)
+
+//skewedLiterals returns n literals over the given number of symbols. Some symbols are a lot more common than others
+func skewedLiterals(rng *rand.Rand, n int, symbols int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		s := rng.Intn(symbols)
+		data[i] = byte(rng.Intn(s + 1))
+	}
+	return data
+}
+
+func TestHuffmanEncodingTable(t *testing.T) {
+	het := HuffmanEncodingTable{}
+	htd := HuffmanTreeDesc{}
+	decoded := HuffmanTreeDesc{}
+	table := &HuffmanDecodingTable{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		data := skewedLiterals(rng, 1+rng.Intn(20000), 2+rng.Intn(fse.MaxSymbols-1))
+		counts := fse.Histogram(nil, data)
+		if i%10 == 0 {
+			//fibonacci counts make the longest codes
+			a, b := 1, 1
+			for symbol := range counts {
+				counts[symbol] = a
+				a, b = b, a+b
+				if b > 1<<30 {
+					a, b = 1, 1
+				}
+			}
+		}
+		err := het.Build(counts, HuffmanMaxEncodingBits)
+		if err == ErrTooFewHuffmanSymbols {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if het.MaxBits > HuffmanMaxEncodingBits {
+			t.Fatalf("The longest code has %d bits", het.MaxBits)
+		}
+		for symbol, count := range counts {
+			if (count > 0) != (het.NumBits[symbol] > 0) {
+				t.Fatalf("Symbol %d appears %d times but has a code of %d bits", symbol, count, het.NumBits[symbol])
+			}
+		}
+
+		//the tree description must decode to the same codes
+		htd.Weights = het.Weights(htd.Weights[:0])
+		description, err := htd.Encode(nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		source := bufio.NewReader(bytes.NewReader(append(description, 0xFF)))
+		n, err := decoded.DecodeFromStream(source)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n != len(description) || decoded.Type != htd.Type {
+			t.Fatalf("Wrote %d bytes of type %d, read %d of type %d", len(description), htd.Type, n, decoded.Type)
+		}
+		if !bytes.Equal(decoded.Weights, htd.Weights) {
+			t.Fatalf("Decoded the weights %v instead of %v", decoded.Weights, htd.Weights)
+		}
+		err = decoded.BuildInto(table)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if table.MaxBits != het.MaxBits {
+			t.Fatalf("Decoding table has %d bits instead of %d", table.MaxBits, het.MaxBits)
+		}
+
+		output := make([]byte, len(data))
+		_, err = table.DecodeStream(het.AppendStream(nil, data), output)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(output, data) {
+			t.Fatal("Wrong symbols decoded from one stream")
+		}
+		if bits := het.EstimateBits(fse.Histogram(nil, data)); bits/8+1 != len(het.AppendStream(nil, data)) {
+			t.Fatalf("Estimated %d bits for a stream of %d bytes", bits, len(het.AppendStream(nil, data)))
+		}
+
+		if len(data) < 6 {
+			continue
+		}
+		encoded, err := het.AppendFourStreams(nil, data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		lsh := LiteralSectionHeader{CompressedSize: len(encoded)}
+		err = lsh.DecodeJumpTable(encoded)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		encoded = encoded[6:]
+		var streams [4][]byte
+		for i, size := range [3]uint16{lsh.StreamSize1, lsh.StreamSize2, lsh.StreamSize3} {
+			streams[i] = encoded[:size]
+			encoded = encoded[size:]
+		}
+		streams[3] = encoded
+		output = make([]byte, len(data))
+		err = table.DecodeFourStreams(streams, output)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(output, data) {
+			t.Fatal("Wrong symbols decoded from four streams")
+		}
+	}
+}
+
+func TestEncodeLiteralSectionHeader(t *testing.T) {
+	decoded := LiteralSectionHeader{}
+	for _, lsh := range []LiteralSectionHeader{
+		{Type: LiteralsBlockTypeRaw, RegeneratedSize: 0},
+		{Type: LiteralsBlockTypeRaw, RegeneratedSize: 31},
+		{Type: LiteralsBlockTypeRLE, RegeneratedSize: 32},
+		{Type: LiteralsBlockTypeRaw, RegeneratedSize: 4095},
+		{Type: LiteralsBlockTypeRLE, RegeneratedSize: MaxBlockSize},
+		{Type: LiteralsBlockTypeCompressed, RegeneratedSize: 1023, CompressedSize: 12, NumberOfStreams: 1},
+		{Type: LiteralsBlockTypeTreeless, RegeneratedSize: 1000, CompressedSize: 1023, NumberOfStreams: 4},
+		{Type: LiteralsBlockTypeCompressed, RegeneratedSize: 1024, CompressedSize: 1000, NumberOfStreams: 4},
+		{Type: LiteralsBlockTypeCompressed, RegeneratedSize: 16383, CompressedSize: 16000, NumberOfStreams: 4},
+		{Type: LiteralsBlockTypeTreeless, RegeneratedSize: MaxBlockSize, CompressedSize: MaxBlockSize - 1, NumberOfStreams: 4},
+	} {
+		raw, err := lsh.Encode(nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = decoded.DecodeType(raw[0])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n, _ := decoded.BytesNeededToDecodeSizes(raw[0]); n != len(raw) || n != lsh.BytesUsedByHeader {
+			t.Fatalf("%+v: Wrote %d bytes but %d are read", lsh, len(raw), n)
+		}
+		err = decoded.DecodeSizes(raw)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if decoded.Type != lsh.Type || decoded.RegeneratedSize != lsh.RegeneratedSize {
+			t.Errorf("%+v: Decoded %+v", lsh, decoded)
+		}
+		if lsh.NumberOfStreams > 0 && (decoded.CompressedSize != lsh.CompressedSize || decoded.NumberOfStreams != lsh.NumberOfStreams) {
+			t.Errorf("%+v: Decoded %+v", lsh, decoded)
+		}
+	}
+
+	lsh := LiteralSectionHeader{Type: LiteralsBlockTypeCompressed, RegeneratedSize: 1024, CompressedSize: 1000, NumberOfStreams: 1}
+	if _, err := lsh.Encode(nil); err != ErrLiteralsSizeNotEncodable {
+		t.Errorf("Encoded one stream with 1024 literals: %v", err)
+	}
+}
+
+func TestLiteralsEncoder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rng.Read(random)
+	inputs := [][]byte{
+		{},
+		{1},
+		bytes.Repeat([]byte{7}, 1000),
+		bytes.Repeat([]byte("aaaabbcd"), 125),
+		bytes.Repeat([]byte("aaaabbcd"), 5), //too short for a tree of its own, reuses the tree of the literals before
+		skewedLiterals(rng, MaxBlockSize, 256),
+		skewedLiterals(rng, 300, 30),
+		skewedLiterals(rng, 1000, 2),
+		random,
+	}
+
+	le := NewLiteralsEncoder()
+	prev := &Block{}
+	types := map[LiteralsBlockType]bool{}
+	for i, literals := range inputs {
+		section, err := le.AppendLiteralsSection(nil, literals)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		le.Commit()
+		types[le.Type] = true
+		if (le.Type == LiteralsBlockTypeCompressed || le.Type == LiteralsBlockTypeTreeless) && len(section) >= len(literals) {
+			t.Errorf("Input %d: Compressed literals are bigger than the raw literals", i)
+		}
+
+		ls := LiteralSection{Data: make([]byte, 0, MaxBlockSize), CompressedData: make([]byte, 0, MaxBlockSize)}
+		source := bufio.NewReader(bytes.NewReader(section))
+		err = ls.DecodeNextLiteralsSection(source, prev)
+		if err != nil {
+			t.Fatalf("Input %d: %s", i, err.Error())
+		}
+		if ls.Header.Type != le.Type {
+			t.Errorf("Input %d: Decoded type %d instead of %d", i, ls.Header.Type, le.Type)
+		}
+		if source.Buffered() != 0 {
+			t.Errorf("Input %d: %d bytes were not read", i, source.Buffered())
+		}
+		if !bytes.Equal(ls.Data, literals) {
+			t.Errorf("Input %d: Wrong literals decoded", i)
+		}
+		if ls.DecodingTable != nil {
+			prev.Literals.DecodingTable = ls.DecodingTable
+		}
+	}
+	for _, typ := range []LiteralsBlockType{LiteralsBlockTypeRaw, LiteralsBlockTypeRLE, LiteralsBlockTypeCompressed, LiteralsBlockTypeTreeless} {
+		if !types[typ] {
+			t.Errorf("No literals of type %d were written", typ)
+		}
+	}
+
+	//without Commit the table must not be reused
+	le.Reset()
+	le.AppendLiteralsSection(nil, inputs[3])
+	le.AppendLiteralsSection(nil, inputs[4])
+	if le.Type == LiteralsBlockTypeTreeless {
+		t.Error("Reused a table that was not committed")
+	}
+}
@@ -16,6 +16,11 @@ type LiteralSection struct {
 	Data           []byte `json:"-"`
 	CompressedData []byte `json:"-"`
 	dataRead       int
+
+	//the huffman table is built into these buffers. May be nil
+	Tables *TableBuffers `json:"-"`
+
+	headerbuffer [6]byte
 }
 
 type LiteralsBlockType byte
@@ -51,7 +56,7 @@ func (lsh *LiteralSectionHeader) DecodeJumpTable(raw []byte) error {
 	lsh.StreamSize2 = binary.LittleEndian.Uint16(raw[2:4])
 	lsh.StreamSize3 = binary.LittleEndian.Uint16(raw[4:6])
 
-	if int(lsh.StreamSize1+lsh.StreamSize2+lsh.StreamSize3) > lsh.CompressedSize {
+	if int(lsh.StreamSize1)+int(lsh.StreamSize2)+int(lsh.StreamSize3) > lsh.CompressedSize {
 		return ErrCorruptedJumptable
 	}
 	return nil
@@ -204,13 +209,14 @@ func (lsh *LiteralSectionHeader) BytesNeededToDecodeSizes(raw byte) (int, error)
 }
 
 var ErrNoHuffTableToCarryOver = errors.New("No previous Huffmantree available")
+var ErrLiteralsTooLarge = errors.New("The literals section is larger than the maximum block size")
 var ErrStreamDidntDecodeToRightLength = errors.New("Huffstream did not decode to the correct length")
 
 func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBlock *Block) error {
 	//read literals section
 	var err error
 
-	var headerbuffer [6]byte
+	headerbuffer := &ls.headerbuffer
 
 	//read first byte
 	headerbuffer[0], err = source.ReadByte()
@@ -243,6 +249,11 @@ func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBl
 		return err
 	}
 
+	//the buffers are sized to the maximum block size of the frame
+	if ls.Header.RegeneratedSize > cap(ls.Data) || ls.Header.CompressedSize > cap(ls.CompressedData) {
+		return ErrLiteralsTooLarge
+	}
+
 	//carry over old huffman tree if no new one is included
 	if ls.Header.Type == LiteralsBlockTypeTreeless {
 		ls.DecodingTable = prevBlock.Literals.DecodingTable
@@ -252,12 +263,7 @@ func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBl
 	}
 
 	if ls.Header.Type == LiteralsBlockTypeCompressed {
-		bytes, err := ls.TreeDesc.DecodeFromStream(source)
-		if err != nil {
-			return err
-		}
-
-		ls.DecodingTable, err = ls.TreeDesc.Build()
+		bytes, err := ls.decodeTree(source)
 		if err != nil {
 			return err
 		}
@@ -274,8 +280,11 @@ func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBl
 			return err
 		}
 		ls.Header.BytesUsedByHeader += 6
-		ls.Header.DecodeJumpTable(headerbuffer[0:6])
 		ls.Header.CompressedSize -= 6
+		err = ls.Header.DecodeJumpTable(headerbuffer[0:6])
+		if err != nil {
+			return err
+		}
 	}
 
 	//read the data for this literals section
@@ -287,10 +296,18 @@ func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBl
 		return err
 	}
 
-	if ls.Header.Type == LiteralsBlockTypeRaw || ls.Header.Type == LiteralsBlockTypeRLE {
+	if ls.Header.Type == LiteralsBlockTypeRaw {
 		ls.Data = ls.CompressedData
 	}
 
+	//expand RLE literals here so they can be read like all other literals
+	if ls.Header.Type == LiteralsBlockTypeRLE {
+		ls.Data = ls.Data[:ls.Header.RegeneratedSize]
+		for i := range ls.Data {
+			ls.Data[i] = ls.CompressedData[0]
+		}
+	}
+
 	//decompress if necessary
 	if ls.Header.Type == LiteralsBlockTypeCompressed || ls.Header.Type == LiteralsBlockTypeTreeless {
 		output := ls.Data[:ls.Header.RegeneratedSize]
@@ -303,75 +320,50 @@ func (ls *LiteralSection) DecodeNextLiteralsSection(source *bufio.Reader, prevBl
 			}
 			ls.Data = output
 		} else {
-			normalSize := (ls.Header.RegeneratedSize + 3) / 4
-			lastSize := ls.Header.RegeneratedSize - 3*normalSize
-			output1 := output[normalSize*0 : normalSize*1]
-			output2 := output[normalSize*1 : normalSize*2]
-			output3 := output[normalSize*2 : normalSize*3]
-			output4 := output[normalSize*3 : normalSize*3+lastSize]
-
-			low := 0
-			high := int(ls.Header.StreamSize1)
-
-			bytes1, err := ls.DecodingTable.DecodeStream(ls.CompressedData[low:high], output1)
-			if err != nil {
-				return err
-			}
-			if bytes1 != (ls.Header.RegeneratedSize+3)/4 {
-				return ErrStreamDidntDecodeToRightLength
-			}
-
-			low += int(ls.Header.StreamSize1)
-			high += int(ls.Header.StreamSize2)
-
-			bytes2, err := ls.DecodingTable.DecodeStream(ls.CompressedData[low:high], output2)
-			if err != nil {
-				return err
-			}
-
-			if bytes2 != (ls.Header.RegeneratedSize+3)/4 {
-				return ErrStreamDidntDecodeToRightLength
-			}
-
-			low += int(ls.Header.StreamSize2)
-			high += int(ls.Header.StreamSize3)
-
-			if int(high) > len(ls.Data) {
-				//keeping. should have been checked beforehand
-				panic("Corrupt stream sizes")
+			var streams [4][]byte
+			data := ls.CompressedData
+			for i, size := range [3]uint16{ls.Header.StreamSize1, ls.Header.StreamSize2, ls.Header.StreamSize3} {
+				streams[i] = data[:size]
+				data = data[size:]
 			}
+			streams[3] = data
 
-			bytes3, err := ls.DecodingTable.DecodeStream(ls.CompressedData[low:high], output3)
+			err := ls.DecodingTable.DecodeFourStreams(streams, output)
 			if err != nil {
 				return err
 			}
-
-			if bytes3 != (ls.Header.RegeneratedSize+3)/4 {
-				return ErrStreamDidntDecodeToRightLength
-			}
-
-			low += int(ls.Header.StreamSize3)
-			high += int(ls.Header.CalcStreamsize4())
-
-			if int(high) != ls.Header.CompressedSize {
-				//keeping. should ahve been checked beforehand
-				panic("Corrupt stream sizes")
-			}
-
-			bytes4, err := ls.DecodingTable.DecodeStream(ls.CompressedData[low:high], output4)
-			if err != nil {
-				return err
-			}
-
-			if bytes1+bytes2+bytes3+bytes4 != ls.Header.RegeneratedSize {
-				//keeping. These should match because the checks before didnt fail
-				panic("Streams decoded combined didnt have correct length")
-			}
 		}
 	}
 	return nil
 }
 
+//decodeTree decodes the huffman tree and builds the decoding table. The buffers from Tables are used if there are any
+func (ls *LiteralSection) decodeTree(source *bufio.Reader) (int, error) {
+	if ls.Tables == nil {
+		bytes, err := ls.TreeDesc.DecodeFromStream(source)
+		if err != nil {
+			return bytes, err
+		}
+		ls.DecodingTable, err = ls.TreeDesc.Build()
+		return bytes, err
+	}
+
+	tables := ls.Tables
+	ls.TreeDesc.Weights = tables.huffmanWeights[:0]
+	ls.TreeDesc.NumBits = tables.huffmanNumBits[:0]
+	ls.TreeDesc.weightsTable = tables.huffmanWeightsTable
+	bytes, err := ls.TreeDesc.DecodeFromStream(source)
+	//keep the buffers if they had to grow
+	tables.huffmanWeights = ls.TreeDesc.Weights[:0]
+	if err != nil {
+		return bytes, err
+	}
+	err = ls.TreeDesc.BuildInto(tables.Huffman)
+	tables.huffmanNumBits = ls.TreeDesc.NumBits[:0]
+	ls.DecodingTable = tables.Huffman
+	return bytes, err
+}
+
 func bitsToByte(bits int) int {
 	x := bits / 8
 	if bits%8 != 0 {
@@ -381,20 +373,9 @@ func bitsToByte(bits int) int {
 }
 
 func (ls *LiteralSection) Read(target []byte) (int, error) {
-	//TODO just return subslices.... absolutely no need for this much copying
-	//actually there might be the need to. Else we'd need to allocate new slices in RLE literal sections. This is probably cheaper.
-
 	//TODO decode huffman on the fly instead of before hand
 	//might be an overomptimization. Blocks can be only 128kb big anyways...
 
-	if ls.Header.Type == LiteralsBlockTypeRLE {
-		for i := range target {
-			target[i] = ls.Data[0]
-		}
-		ls.dataRead += len(target)
-		return len(target), nil
-	}
-
 	if ls.dataRead == len(ls.Data) {
 		return 0, io.EOF
 	}
@@ -408,13 +389,16 @@ func (ls *LiteralSection) Read(target []byte) (int, error) {
 	return diff, nil
 }
 
-func (ls *LiteralSection) GetRest() []byte {
-	if ls.Header.Type == LiteralsBlockTypeRLE {
-		buf := make([]byte, ls.Header.RegeneratedSize-ls.dataRead)
-		for i := range buf {
-			buf[i] = ls.Data[0]
-		}
-		return buf
+//Next returns the next n literals without copying them. The slice is only valid until the next literals section is decoded
+func (ls *LiteralSection) Next(n int) ([]byte, error) {
+	if ls.dataRead+n > len(ls.Data) {
+		return nil, io.ErrUnexpectedEOF
 	}
+	data := ls.Data[ls.dataRead : ls.dataRead+n]
+	ls.dataRead += n
+	return data, nil
+}
+
+func (ls *LiteralSection) GetRest() []byte {
 	return ls.Data[ls.dataRead:]
 }
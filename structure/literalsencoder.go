@@ -0,0 +1,199 @@
+package structure
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/killingspark/sparkzstd/fse"
+)
+
+var ErrLiteralsSizeNotEncodable = errors.New("The sizes of the literals section do not fit into its header")
+
+//Encode appends the header to dst. It is the inverse of DecodeType and DecodeSizes and uses the smallest size format that holds the sizes.
+//For compressed and treeless literals CompressedSize is everything after the header: the tree, the jump table and the streams
+func (lsh *LiteralSectionHeader) Encode(dst []byte) ([]byte, error) {
+	var raw uint64
+	n := 0
+	if lsh.Type == LiteralsBlockTypeRaw || lsh.Type == LiteralsBlockTypeRLE {
+		size := uint64(lsh.RegeneratedSize)
+		switch {
+		case size < 1<<5:
+			raw, n = size<<3, 1
+		case size < 1<<12:
+			raw, n = 1<<2|size<<4, 2
+		case size < 1<<20:
+			raw, n = 3<<2|size<<4, 3
+		default:
+			return dst, ErrLiteralsSizeNotEncodable
+		}
+	} else {
+		size := lsh.RegeneratedSize
+		if lsh.CompressedSize > size {
+			size = lsh.CompressedSize
+		}
+		sizeformat, bits := uint64(0), uint(0)
+		switch {
+		case size < 1<<10:
+			sizeformat, bits, n = 1, 10, 3
+		case size < 1<<14:
+			sizeformat, bits, n = 2, 14, 4
+		case size < 1<<18:
+			sizeformat, bits, n = 3, 18, 5
+		default:
+			return dst, ErrLiteralsSizeNotEncodable
+		}
+		switch {
+		case lsh.NumberOfStreams == 1 && sizeformat == 1:
+			//only the smallest size format has a variant with one stream
+			sizeformat = 0
+		case lsh.NumberOfStreams != 4:
+			return dst, ErrLiteralsSizeNotEncodable
+		}
+		raw = sizeformat<<2 | uint64(lsh.RegeneratedSize)<<4 | uint64(lsh.CompressedSize)<<(4+bits)
+	}
+	raw |= uint64(lsh.Type)
+	lsh.BytesUsedByHeader = n
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], raw)
+	return append(dst, buf[:n]...), nil
+}
+
+//minLiteralsToCompress are the fewest literals that get a new huffman tree. With fewer the tree costs more than it saves.
+//Reusing the tree of the previous literals pays off much earlier
+const minLiteralsToCompress = 64
+const minLiteralsToReuseTree = 8
+
+//minLiteralsForFourStreams are the fewest literals that are split into four streams. The jump table does not pay off for fewer
+const minLiteralsForFourStreams = 256
+
+//LiteralsEncoder writes the literals sections of the blocks of a frame. It picks raw, RLE, compressed or treeless literals,
+//whichever is estimated to be the smallest. Compressed literals have to save a few bytes because raw literals are faster to decode
+type LiteralsEncoder struct {
+	table    HuffmanEncodingTable //the decoder has this table from the last compressed literals
+	hasTable bool
+	next     HuffmanEncodingTable //the table of the last section. It replaces table on Commit
+	nextNew  bool
+
+	//the type of the last section
+	Type LiteralsBlockType
+
+	treeDesc HuffmanTreeDesc
+	counts   []int
+	tree     []byte
+	body     []byte
+}
+
+//NewLiteralsEncoder creates a LiteralsEncoder for a new frame
+func NewLiteralsEncoder() *LiteralsEncoder {
+	return &LiteralsEncoder{
+		counts: make([]int, 0, fse.MaxSymbols),
+		body:   make([]byte, 0, MaxBlockSize),
+	}
+}
+
+//Reset prepares the LiteralsEncoder for a new frame. The table of the previous frame is forgotten
+func (le *LiteralsEncoder) Reset() {
+	le.hasTable = false
+	le.nextNew = false
+}
+
+//Commit has to be called after the block with the last section was written as compressed block. Only then the decoder has the
+//huffman table of the section and the following sections can reuse it
+func (le *LiteralsEncoder) Commit() {
+	if le.nextNew {
+		le.table = le.next
+		le.hasTable = true
+	}
+	le.nextNew = false
+}
+
+//literalsMinGain is how much compressed literals have to save
+func literalsMinGain(size int) int {
+	return size>>6 + 2
+}
+
+//AppendLiteralsSection appends the literals section with the literals to dst
+func (le *LiteralsEncoder) AppendLiteralsSection(dst []byte, literals []byte) ([]byte, error) {
+	le.nextNew = false
+	if len(literals) > MaxBlockSize {
+		return dst, ErrLiteralsTooLarge
+	}
+	le.counts = fse.Histogram(le.counts, literals)
+	if len(literals) > 1 && le.counts[len(le.counts)-1] == len(literals) {
+		le.Type = LiteralsBlockTypeRLE
+		header := LiteralSectionHeader{Type: LiteralsBlockTypeRLE, RegeneratedSize: len(literals)}
+		dst, err := header.Encode(dst)
+		return append(dst, literals[0]), err
+	}
+	if len(literals) < minLiteralsToReuseTree || (len(literals) < minLiteralsToCompress && !le.hasTable) {
+		return le.appendRaw(dst, literals)
+	}
+
+	streams, overhead := 1, 1 //the padding
+	if len(literals) >= minLiteralsForFourStreams {
+		streams, overhead = 4, 6+4 //the jump table and the padding of each stream
+	}
+	maxSize := len(literals) - literalsMinGain(len(literals))
+
+	treeless := false
+	bestSize := maxSize
+	if le.hasTable {
+		if bits := le.table.EstimateBits(le.counts); bits >= 0 && bits/8+overhead < bestSize {
+			bestSize = bits/8 + overhead
+			treeless = true
+		}
+	}
+	tree := false
+	if len(literals) >= minLiteralsToCompress && le.next.Build(le.counts, HuffmanMaxEncodingBits) == nil {
+		le.treeDesc.Weights = le.next.Weights(le.treeDesc.Weights[:0])
+		var err error
+		le.tree, err = le.treeDesc.Encode(le.tree[:0])
+		if err == nil && len(le.tree)+le.next.EstimateBits(le.counts)/8+overhead < bestSize {
+			tree = true
+			treeless = false
+		}
+	}
+	if !tree && !treeless {
+		return le.appendRaw(dst, literals)
+	}
+
+	header := LiteralSectionHeader{Type: LiteralsBlockTypeTreeless, RegeneratedSize: len(literals), NumberOfStreams: streams}
+	table := &le.table
+	body := le.body[:0]
+	if tree {
+		header.Type = LiteralsBlockTypeCompressed
+		table = &le.next
+		body = append(body, le.tree...)
+	}
+	if streams == 1 {
+		body = table.AppendStream(body, literals)
+	} else {
+		var err error
+		body, err = table.AppendFourStreams(body, literals)
+		if err != nil {
+			return le.appendRaw(dst, literals)
+		}
+	}
+	le.body = body
+	if len(body) >= maxSize {
+		return le.appendRaw(dst, literals)
+	}
+
+	header.CompressedSize = len(body)
+	dst, err := header.Encode(dst)
+	if err != nil {
+		return dst, err
+	}
+	le.Type = header.Type
+	le.nextNew = tree
+	return append(dst, body...), nil
+}
+
+//appendRaw appends the literals as raw literals
+func (le *LiteralsEncoder) appendRaw(dst []byte, literals []byte) ([]byte, error) {
+	le.Type = LiteralsBlockTypeRaw
+	le.nextNew = false
+	header := LiteralSectionHeader{Type: LiteralsBlockTypeRaw, RegeneratedSize: len(literals)}
+	dst, err := header.Encode(dst)
+	return append(dst, literals...), err
+}
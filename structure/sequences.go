@@ -6,6 +6,7 @@ import (
 	"github.com/killingspark/sparkzstd/bitstream"
 	"github.com/killingspark/sparkzstd/fse"
 	"io"
+	"math"
 )
 
 type Sequence struct {
@@ -16,193 +17,142 @@ type Sequence struct {
 
 type SequencesSection struct {
 	Header                         SequencesSectionHeader
-	LiteralLengthsFSEDecodingTable DecodingTable `json:"-"`
-	MatchLengthsFSEDecodingTable   DecodingTable `json:"-"`
-	OffsetsFSEDecodingTable        DecodingTable `json:"-"`
+	LiteralLengthsFSEDecodingTable *fse.FSETable `json:"-"`
+	MatchLengthsFSEDecodingTable   *fse.FSETable `json:"-"`
+	OffsetsFSEDecodingTable        *fse.FSETable `json:"-"`
 	Data                           []byte        `json:"-"`
 
 	Sequences []Sequence `json:"-"`
-}
 
-type RepeatingDecodingTable struct {
-	value          int
-	additionalBits int
-}
+	//the tables for compressed and RLE modes are built into these buffers. May be nil
+	Tables *TableBuffers `json:"-"`
 
-type DecodingTable interface {
-	DecodeSymbol(src *bitstream.Reversebitstream) (symbol int, bitsRead int, err error)
-	NextState(src *bitstream.Reversebitstream) (int, error)
-	PeekSymbol() (int, error)
-	GetAdditionalBits() int
-	GetNumberOfBits() (int, error)
-	InitState(src *bitstream.Reversebitstream) (int, error)
-	GetState() int
-}
+	//states of the three interleaved fse streams while decoding
+	llState       fse.FSEState
+	mlState       fse.FSEState
+	ofState       fse.FSEState
+	bitsrc        bitstream.ReverseReader
+	sequencesLeft int
 
-func (rdc *RepeatingDecodingTable) DecodeSymbol(src *bitstream.Reversebitstream) (symbol int, bitsRead int, err error) {
-	return int(rdc.value), 0, nil
-}
-func (rdc *RepeatingDecodingTable) NextState(src *bitstream.Reversebitstream) (int, error) {
-	return 0, nil
-}
-func (rdc *RepeatingDecodingTable) PeekSymbol() (int, error) {
-	return rdc.value, nil
-}
-func (rdc *RepeatingDecodingTable) GetAdditionalBits() int {
-	return rdc.additionalBits
+	headerbuffer [3]byte
 }
-func (rdc *RepeatingDecodingTable) GetNumberOfBits() (int, error) {
-	return 0, nil
-}
-func (rdc *RepeatingDecodingTable) InitState(src *bitstream.Reversebitstream) (int, error) {
-	return 0, nil
+
+//offsetValue calculates the offset value of a sequence from its code and the extra bits. Codes up to 31 give values that do not fit
+//into an int on 32-bit platforms. They are bigger than any window that can be decoded there, so they are clamped to the biggest int
+//and rejected when the sequence is executed
+func offsetValue(code int, bits uint64) int {
+	value := 1<<uint(code) + bits
+	if value > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(value)
 }
-func (rdc *RepeatingDecodingTable) GetState() int {
-	return 0
+
+//DecodeSequence reads the extra bits of the sequence the states currently point to
+func (ss *SequencesSection) DecodeSequence(source *bitstream.ReverseReader) Sequence {
+	ofEntry := ss.ofState.Entry()
+	mlEntry := ss.mlState.Entry()
+	llEntry := ss.llState.Entry()
+
+	//at most 31+16+16 bits are needed. Reload once for the offset and once for both lengths
+	source.Reload()
+	offset := source.Peek(uint(ofEntry.Symbol))
+	source.Consume(uint(ofEntry.Symbol))
+	source.Reload()
+	mlextra := source.Peek(uint(mlEntry.NumberOfAdditionalBits))
+	source.Consume(uint(mlEntry.NumberOfAdditionalBits))
+	llextra := source.Peek(uint(llEntry.NumberOfAdditionalBits))
+	source.Consume(uint(llEntry.NumberOfAdditionalBits))
+
+	return Sequence{
+		Offset:        offsetValue(ofEntry.Symbol, offset),
+		MatchLength:   mlEntry.Symbol + int(mlextra),
+		LiteralLength: llEntry.Symbol + int(llextra),
+	}
 }
 
-func (ss *SequencesSection) DecodeSequence(source *bitstream.Reversebitstream) (Sequence, int, error) {
-	var seq Sequence
+//InitSequences prepares the states to decode the sequences one by one with NextSequence. This way the sequences can be executed
+//while they are decoded, without storing them in Sequences
+func (ss *SequencesSection) InitSequences() error {
+	ss.bitsrc.Init(ss.Data)
 
-	ofcode, err := ss.OffsetsFSEDecodingTable.PeekSymbol()
+	//need to skip bits from the stream (the back of the data...) until the first 1 arrives
+	_, err := ss.bitsrc.SkipPadding()
 	if err != nil {
-		return seq, 0, err
+		return ErrBadPadding
 	}
-	llcode, err := ss.LiteralLengthsFSEDecodingTable.PeekSymbol()
-	if err != nil {
-		return seq, 0, err
-	}
-	mlcode, err := ss.MatchLengthsFSEDecodingTable.PeekSymbol()
-	if err != nil {
-		return seq, 0, err
-	}
-
-	//print("of stat: ")
-	//println(ss.OffsetsFSEDecodingTable.GetState())
-	//print("of Code: ")
-	//println(ofcode)
-	//print("ll stat: ")
-	//println(ss.LiteralLengthsFSEDecodingTable.GetState())
-	//print("ll bits: ")
-	//println(ss.LiteralLengthsFSEDecodingTable.GetAdditionalBits())
-	//print("ll Code: ")
-	//println(llcode)
-	//print("ml stat: ")
-	//println(ss.MatchLengthsFSEDecodingTable.GetState())
-	//print("ml bits: ")
-	//println(ss.MatchLengthsFSEDecodingTable.GetAdditionalBits())
-	//print("ml Code: ")
-	//println(mlcode)
-
-	bitsRead := 0
-
-	offset, err := source.Read(ofcode)
-	if err != nil {
-		return seq, bitsRead, err
-	}
-	bitsRead += ofcode
-	seq.Offset = (1 << uint(ofcode)) + int(offset)
 
-	mlextrabits := ss.MatchLengthsFSEDecodingTable.GetAdditionalBits()
-	mlextra, err := source.Read(mlextrabits)
-	if err != nil {
-		return seq, bitsRead, err
-	}
-	bitsRead += mlextrabits
-	seq.MatchLength = mlcode + int(mlextra)
+	ss.llState = fse.FSEState{Table: ss.LiteralLengthsFSEDecodingTable}
+	ss.ofState = fse.FSEState{Table: ss.OffsetsFSEDecodingTable}
+	ss.mlState = fse.FSEState{Table: ss.MatchLengthsFSEDecodingTable}
 
-	llextrabits := ss.LiteralLengthsFSEDecodingTable.GetAdditionalBits()
-	llextra, err := source.Read(llextrabits)
-	if err != nil {
-		return seq, bitsRead, err
-	}
-	bitsRead += llextrabits
-	seq.LiteralLength = llcode + int(llextra)
+	//the three states need at most 3*9 bits
+	ss.bitsrc.Reload()
+	ss.llState.InitState(&ss.bitsrc)
+	ss.ofState.InitState(&ss.bitsrc)
+	ss.mlState.InitState(&ss.bitsrc)
 
-	return seq, bitsRead, nil
+	ss.sequencesLeft = ss.Header.NumberOfSequences
+	return nil
 }
 
-//return bits read
-func (ss *SequencesSection) DecodeSequences() (int, error) {
-	bitsRead := 0
-	bitsrc := bitstream.NewReversebitstream(ss.Data)
-	var err error
+//NextSequence decodes the next of the Header.NumberOfSequences sequences
+func (ss *SequencesSection) NextSequence() Sequence {
+	seq := ss.DecodeSequence(&ss.bitsrc)
+	ss.sequencesLeft--
 
-	//need to read bits from the stream (the back of the data...) until the first 1 arrives
-	x := uint64(0)
-	for x == 0 {
-		x, err = bitsrc.Read(1)
-		if err != nil {
-			return bitsRead, err
-		}
-		bitsRead++
+	//dont update on the last sequence
+	if ss.sequencesLeft > 0 {
+		ss.bitsrc.Reload()
+		ss.llState.NextState(&ss.bitsrc)
+		ss.mlState.NextState(&ss.bitsrc)
+		ss.ofState.NextState(&ss.bitsrc)
 	}
+	return seq
+}
 
-	if bitsRead > 8 {
-		return bitsRead, ErrBadPadding
+//NextSequences decodes up to len(dst) of the next sequences into dst. It uses the assembly decoder if there is one and decodes
+//at least one sequence as long as there are sequences left
+func (ss *SequencesSection) NextSequences(dst []Sequence) []Sequence {
+	if ss.sequencesLeft == 0 || len(dst) == 0 {
+		return dst[:0]
 	}
-
-	bits, err := ss.LiteralLengthsFSEDecodingTable.InitState(bitsrc)
-	bitsRead += bits
-	if err != nil {
-		return bitsRead, err
+	n := ss.decodeSequencesAsm(dst)
+	if n == 0 {
+		dst[0] = ss.NextSequence()
+		n = 1
 	}
-	bits, err = ss.OffsetsFSEDecodingTable.InitState(bitsrc)
-	bitsRead += bits
-	if err != nil {
-		return bitsRead, err
+	return dst[:n]
+}
+
+//FinishSequences checks that the sequences used up the whole bitstream
+//returns bits read
+func (ss *SequencesSection) FinishSequences() (int, error) {
+	bitsRead := len(ss.Data)*8 - ss.bitsrc.BitsStillInStream() - 1
+	if ss.bitsrc.BitsStillInStream() != -1 {
+		return bitsRead, ErrNotAllBitsUsed
 	}
-	bits, err = ss.MatchLengthsFSEDecodingTable.InitState(bitsrc)
-	bitsRead += bits
+	return bitsRead, nil
+}
+
+//DecodeSequences decodes all sequences into Sequences
+//return bits read
+func (ss *SequencesSection) DecodeSequences() (int, error) {
+	err := ss.InitSequences()
 	if err != nil {
-		return bitsRead, err
+		return 0, err
 	}
 
-	ss.Sequences = make([]Sequence, ss.Header.NumberOfSequences)
-
-	for i := 0; i < ss.Header.NumberOfSequences; i++ {
-		ss.Sequences[i], bits, err = ss.DecodeSequence(bitsrc)
-		if err != nil {
-			return bitsRead, err
-		}
-		bitsRead += bits
-
-		//print(ss.Sequences[i].LiteralLength)
-		//print(" ")
-		//print(ss.Sequences[i].MatchLength)
-		//print(" ")
-		//print(ss.Sequences[i].Offset)
-		//println("\n")
-
-		//dont update on the last index.
-		if i < ss.Header.NumberOfSequences-1 {
-			bits, err := ss.LiteralLengthsFSEDecodingTable.NextState(bitsrc)
-			bitsRead += bits
-			if err != nil {
-				return bitsRead, err
-			}
-			bits, err = ss.MatchLengthsFSEDecodingTable.NextState(bitsrc)
-			bitsRead += bits
-			if err != nil {
-				return bitsRead, err
-			}
-			bits, err = ss.OffsetsFSEDecodingTable.NextState(bitsrc)
-			bitsRead += bits
-			if err != nil {
-				return bitsRead, err
-			}
-		}
+	//reuse the slice if it is big enough
+	if cap(ss.Sequences) >= ss.Header.NumberOfSequences {
+		ss.Sequences = ss.Sequences[:ss.Header.NumberOfSequences]
+	} else {
+		ss.Sequences = make([]Sequence, ss.Header.NumberOfSequences)
 	}
-
-	if bitsrc.BitsStillInStream() != -1 {
-		print("Bits originally in stream: ")
-		println(len(bitsrc.Data) * 8)
-		print("Bits still in stream: ")
-		println(bitsrc.BitsStillInStream())
-		//panic("Not all bits read")
-		return bitsRead, ErrNotAllBitsUsed
+	for i := ss.decodeSequencesAsm(ss.Sequences); i < len(ss.Sequences); i++ {
+		ss.Sequences[i] = ss.NextSequence()
 	}
-	return bitsRead, nil
+	return ss.FinishSequences()
 }
 
 var ErrNotAllBitsUsed = errors.New("Did not read all bits to decode sequences. Likely data is corrupted.")
@@ -268,17 +218,40 @@ func (ssh *SequencesSectionHeader) DecodeNumberOfSequences(raw []byte) (int, err
 	return 0, nil
 }
 
+//maximum accuracy logs and codes of the tables, as given by the format
+const (
+	MaxLiteralLengthsAccuracyLog = 9
+	MaxMatchLengthsAccuracyLog   = 9
+	MaxOffsetsAccuracyLog        = 8
+	MaxOffsetCode                = 31
+)
+
+var ErrCorruptedTableDescription = errors.New("The table description uses a too large accuracy log or too many symbols")
+var ErrIllegalRLESymbol = errors.New("The symbol of a RLE table is out of range")
 var ErrNoLLTableToCarryOver = errors.New("Needed to copy old LiteralLenghts table but there was none")
 var ErrNoMLTableToCarryOver = errors.New("Needed to copy old MathcLenghts table but there was none")
 var ErrNoOFTableToCarryOver = errors.New("Needed to copy old Offsets table but there was none")
 
+//tableBuffer returns buf or a new table if there is no buffer
+func tableBuffer(buf *fse.FSETable, maxAccuracyLog int) *fse.FSETable {
+	if buf == nil {
+		return fse.NewFSETable(maxAccuracyLog)
+	}
+	return buf
+}
+
 func (ss *SequencesSection) DecodeTables(source *bufio.Reader, previousBlock *Block) (int, error) {
 	bytesUsed := 0
 
+	var llBuf, ofBuf, mlBuf *fse.FSETable
+	if ss.Tables != nil {
+		llBuf, ofBuf, mlBuf = ss.Tables.LiteralLengths, ss.Tables.Offsets, ss.Tables.MatchLengths
+	}
+
 	switch ss.Header.LiteralsLengthMode {
 	case SymbolCompressionModePredefined:
 		bytesUsed += 0
-		ss.LiteralLengthsFSEDecodingTable = fse.BuildLiteralLengthsTable()
+		ss.LiteralLengthsFSEDecodingTable = fse.PredefinedLiteralLengthsTable
 	case SymbolCompressionModeRLE:
 		//read the byte that should be repeated
 		b, err := source.ReadByte()
@@ -286,29 +259,38 @@ func (ss *SequencesSection) DecodeTables(source *bufio.Reader, previousBlock *Bl
 			return bytesUsed, err
 		}
 		bytesUsed++
-		byteToRepeat := fse.LiteralLengthBaseValueTranslation[b]
-		rdc := RepeatingDecodingTable{value: byteToRepeat, additionalBits: int(fse.LiteralLengthExtraBits[b])}
-		ss.LiteralLengthsFSEDecodingTable = &rdc
+		if int(b) >= len(fse.LiteralLengthBaseValueTranslation) {
+			return bytesUsed, ErrIllegalRLESymbol
+		}
+		fset := tableBuffer(llBuf, 0)
+		fset.InitRLE(fse.LiteralLengthBaseValueTranslation[b], fse.LiteralLengthExtraBits[b])
+		ss.LiteralLengthsFSEDecodingTable = fset
 	case SymbolCompressionModeRepeat:
 		ss.LiteralLengthsFSEDecodingTable = previousBlock.Sequences.LiteralLengthsFSEDecodingTable
 		if previousBlock.Sequences.LiteralLengthsFSEDecodingTable == nil {
 			return bytesUsed, ErrNoLLTableToCarryOver
 		}
 	case SymbolCompressionModeCompressed:
-		fset := fse.FSETable{}
+		fset := tableBuffer(llBuf, MaxLiteralLengthsAccuracyLog)
 		bytesread, err := fset.ReadTabledescriptionFromBitstream(source)
 		if err != nil {
 			return bytesUsed, err
 		}
 		bytesUsed += bytesread
-		fset.BuildDecodingTable(fse.LiteralLengthBaseValueTranslation[:], fse.LiteralLengthExtraBits[:])
-		ss.LiteralLengthsFSEDecodingTable = &fset
+		if fset.AccuracyLog > MaxLiteralLengthsAccuracyLog || len(fset.Values) > len(fse.LiteralLengthBaseValueTranslation) {
+			return bytesUsed, ErrCorruptedTableDescription
+		}
+		err = fset.BuildDecodingTable(fse.LiteralLengthBaseValueTranslation[:], fse.LiteralLengthExtraBits[:])
+		if err != nil {
+			return bytesUsed, err
+		}
+		ss.LiteralLengthsFSEDecodingTable = fset
 	}
 
 	switch ss.Header.OffsetsMode {
 	case SymbolCompressionModePredefined:
 		bytesUsed += 0
-		ss.OffsetsFSEDecodingTable = fse.BuildOffsetTable()
+		ss.OffsetsFSEDecodingTable = fse.PredefinedOffsetTable
 	case SymbolCompressionModeRLE:
 		//read the byte that should be repeated
 		b, err := source.ReadByte()
@@ -316,30 +298,40 @@ func (ss *SequencesSection) DecodeTables(source *bufio.Reader, previousBlock *Bl
 			return bytesUsed, err
 		}
 		bytesUsed++
-		rdc := RepeatingDecodingTable{value: int(b), additionalBits: 0}
-		ss.OffsetsFSEDecodingTable = &rdc
+		if b > MaxOffsetCode {
+			return bytesUsed, ErrIllegalRLESymbol
+		}
+		fset := tableBuffer(ofBuf, 0)
+		fset.InitRLE(int(b), 0)
+		ss.OffsetsFSEDecodingTable = fset
 	case SymbolCompressionModeRepeat:
 		ss.OffsetsFSEDecodingTable = previousBlock.Sequences.OffsetsFSEDecodingTable
 		if previousBlock.Sequences.OffsetsFSEDecodingTable == nil {
 			return bytesUsed, ErrNoOFTableToCarryOver
 		}
 	case SymbolCompressionModeCompressed:
-		fset := fse.FSETable{}
+		fset := tableBuffer(ofBuf, MaxOffsetsAccuracyLog)
 		bytesread, err := fset.ReadTabledescriptionFromBitstream(source)
 
 		if err != nil {
 			return bytesUsed, err
 		}
 		bytesUsed += bytesread
+		if fset.AccuracyLog > MaxOffsetsAccuracyLog || len(fset.Values) > MaxOffsetCode+1 {
+			return bytesUsed, ErrCorruptedTableDescription
+		}
 
-		fset.BuildDecodingTable(nil, nil)
-		ss.OffsetsFSEDecodingTable = &fset
+		err = fset.BuildDecodingTable(nil, nil)
+		if err != nil {
+			return bytesUsed, err
+		}
+		ss.OffsetsFSEDecodingTable = fset
 	}
 
 	switch ss.Header.MatchLengthsMode {
 	case SymbolCompressionModePredefined:
 		bytesUsed += 0
-		ss.MatchLengthsFSEDecodingTable = fse.BuildMatchLengthsTable()
+		ss.MatchLengthsFSEDecodingTable = fse.PredefinedMatchLengthsTable
 	case SymbolCompressionModeRLE:
 		//read the byte that should be repeated
 		b, err := source.ReadByte()
@@ -347,34 +339,71 @@ func (ss *SequencesSection) DecodeTables(source *bufio.Reader, previousBlock *Bl
 			return bytesUsed, err
 		}
 		bytesUsed++
-		rdc := RepeatingDecodingTable{value: fse.MatchLengthBaseValueTranslation[b], additionalBits: int(fse.MatchLengthsExtraBits[b])}
-		ss.MatchLengthsFSEDecodingTable = &rdc
+		if int(b) >= len(fse.MatchLengthBaseValueTranslation) {
+			return bytesUsed, ErrIllegalRLESymbol
+		}
+		fset := tableBuffer(mlBuf, 0)
+		fset.InitRLE(fse.MatchLengthBaseValueTranslation[b], fse.MatchLengthsExtraBits[b])
+		ss.MatchLengthsFSEDecodingTable = fset
 	case SymbolCompressionModeRepeat:
 		ss.MatchLengthsFSEDecodingTable = previousBlock.Sequences.MatchLengthsFSEDecodingTable
 		if previousBlock.Sequences.MatchLengthsFSEDecodingTable == nil {
 			return bytesUsed, ErrNoMLTableToCarryOver
 		}
 	case SymbolCompressionModeCompressed:
-		fset := fse.FSETable{}
+		fset := tableBuffer(mlBuf, MaxMatchLengthsAccuracyLog)
 		bytesread, err := fset.ReadTabledescriptionFromBitstream(source)
 		if err != nil {
 			return bytesUsed, err
 		}
 		bytesUsed += bytesread
-		fset.BuildDecodingTable(fse.MatchLengthBaseValueTranslation[:], fse.MatchLengthsExtraBits[:])
-		ss.MatchLengthsFSEDecodingTable = &fset
+		if fset.AccuracyLog > MaxMatchLengthsAccuracyLog || len(fset.Values) > len(fse.MatchLengthBaseValueTranslation) {
+			return bytesUsed, ErrCorruptedTableDescription
+		}
+		err = fset.BuildDecodingTable(fse.MatchLengthBaseValueTranslation[:], fse.MatchLengthsExtraBits[:])
+		if err != nil {
+			return bytesUsed, err
+		}
+		ss.MatchLengthsFSEDecodingTable = fset
 	}
 
 	return bytesUsed, nil
 }
 
+//DecodeNextSequenceSection reads the section and decodes all sequences into Sequences
 func (ss *SequencesSection) DecodeNextSequenceSection(source *bufio.Reader, bytesLeftInBlock int, previousBlock *Block) error {
+	err := ss.ReadNextSequenceSection(source, bytesLeftInBlock, previousBlock)
+	if err != nil || ss.Header.NumberOfSequences == 0 {
+		return err
+	}
+
+	//ss.Data should now only include the bitsream containing the sequences
+	bits, err := ss.DecodeSequences()
+	if err != nil {
+		return err
+	}
+
+	bytesUsed := bits / 8
+	if bits%8 != 0 {
+		bytesUsed++
+	}
+
+	if bytesUsed < len(ss.Data) {
+		return ErrNotAllBytesUsedWhileSequenceDecoding
+	}
+
+	return nil
+}
+
+//ReadNextSequenceSection reads the header, the decoding tables and the bitstream of the section. The sequences are not decoded,
+//that can be done with DecodeSequences or with InitSequences and NextSequence
+func (ss *SequencesSection) ReadNextSequenceSection(source *bufio.Reader, bytesLeftInBlock int, previousBlock *Block) error {
 	//read sequence section
 	var err error
 
 	bytesUsedInHeader := 0
 
-	var buf [3]byte //maximum 3 byte
+	buf := &ss.headerbuffer //maximum 3 byte
 	buf[0], err = source.ReadByte()
 	if err != nil {
 		return err
@@ -425,28 +454,7 @@ func (ss *SequencesSection) DecodeNextSequenceSection(source *bufio.Reader, byte
 	//it can be processed
 	_, err = io.ReadFull(source, ss.Data)
 
-	if err != nil {
-		return err
-	}
-
-	//ss.Data should now only include the bitsream containing the sequences
-	bits, err := ss.DecodeSequences()
-	if err != nil {
-		return err
-	}
-
-	bytesUsed := bits / 8
-	if bits%8 != 0 {
-		bytesUsed++
-	}
-
-	if bytesUsed < len(ss.Data) {
-		print("bytesUsed: ")
-		println(bits)
-		return ErrNotAllBytesUsedWhileSequenceDecoding
-	}
-
-	return nil
+	return err
 }
 
 var ErrNotAllBytesUsedWhileSequenceDecoding = errors.New("Didnt use all bytes from the sequence stream. Data is likely corrupted")
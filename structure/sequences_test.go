@@ -0,0 +1,23 @@
+package structure
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestOffsetValue(t *testing.T) {
+	if v := offsetValue(5, 7); v != 39 {
+		t.Errorf("Wrong offset value: %d, should be: %d", v, 39)
+	}
+
+	//the biggest offset value the format allows does not fit into an int on 32-bit platforms
+	biggest := offsetValue(MaxOffsetCode, 1<<MaxOffsetCode-1)
+	should := uint64(math.MaxInt)
+	if strconv.IntSize == 64 {
+		should = 1<<32 - 1
+	}
+	if uint64(biggest) != should {
+		t.Errorf("Wrong offset value: %d, should be: %d", biggest, should)
+	}
+}
@@ -0,0 +1,309 @@
+package structure
+
+import (
+	"errors"
+	"github.com/killingspark/sparkzstd/bitstream"
+	"github.com/killingspark/sparkzstd/fse"
+)
+
+//OffsetHistory holds the last three offsets. The encoder keeps it exactly like the decoder does, so it knows which offsets
+//can be written as repeat offsets
+type OffsetHistory [3]int
+
+//NewOffsetHistory returns the history every frame starts with
+func NewOffsetHistory() OffsetHistory {
+	return OffsetHistory{1, 4, 8}
+}
+
+//Encode returns the offset value of a sequence with the given offset and literal length and updates the history.
+//It is the inverse of the decoders nextOffset: one of the last three offsets becomes 1 to 3, all other offsets are given +3
+func (oh *OffsetHistory) Encode(offset int, literalLength int) int {
+	value := offset + 3
+	if literalLength > 0 {
+		switch offset {
+		case oh[0]:
+			return 1
+		case oh[1]:
+			value = 2
+		case oh[2]:
+			value = 3
+		}
+	} else {
+		switch offset {
+		case oh[1]:
+			value = 1
+		case oh[2]:
+			value = 2
+		case oh[0] - 1:
+			value = 3
+		}
+	}
+
+	//the offset moves to the front, the ones before it move back
+	switch offset {
+	case oh[1]:
+		oh[1] = oh[0]
+	default:
+		oh[2] = oh[1]
+		oh[1] = oh[0]
+	}
+	oh[0] = offset
+	return value
+}
+
+//the codes of the small lengths are looked up, the larger ones are calculated from their highest bit
+var literalLengthCodes, matchLengthCodes [128]byte
+
+func init() {
+	for code, base := range fse.LiteralLengthBaseValueTranslation {
+		for value := base; value < len(literalLengthCodes); value++ {
+			literalLengthCodes[value] = byte(code)
+		}
+	}
+	for code, base := range fse.MatchLengthBaseValueTranslation {
+		for value := base - 3; value < len(matchLengthCodes); value++ {
+			matchLengthCodes[value] = byte(code)
+		}
+	}
+}
+
+//LiteralLengthCode returns the code of a literal length. The extra bits are the difference to the base value of the code
+func LiteralLengthCode(literalLength int) byte {
+	if literalLength < len(literalLengthCodes) {
+		return literalLengthCodes[literalLength]
+	}
+	return byte(fse.BIT_highbit32(uint32(literalLength))) + 19
+}
+
+//MatchLengthCode returns the code of a match length, which is at least 3
+func MatchLengthCode(matchLength int) byte {
+	if matchLength-3 < len(matchLengthCodes) {
+		return matchLengthCodes[matchLength-3]
+	}
+	return byte(fse.BIT_highbit32(uint32(matchLength-3))) + 36
+}
+
+//OffsetCode returns the code of an offset value. The offset value has as many extra bits as the code says
+func OffsetCode(offsetValue int) byte {
+	return byte(fse.BIT_highbit32(uint32(offsetValue)))
+}
+
+var ErrTooManySequences = errors.New("There are too many sequences for the header of the sequences section")
+
+//maxSequences is the most sequences the 3 byte form of the number of sequences can tell
+const maxSequences = 0x7F00 + 0xFFFF
+
+//Encode appends the number of sequences and the byte with the modes of the tables. It is the inverse of DecodeNumberOfSequences
+//and DecodeSymbolCompressionModes. Without sequences the modes are left out
+func (ssh *SequencesSectionHeader) Encode(dst []byte) ([]byte, error) {
+	n := ssh.NumberOfSequences
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+		ssh.BytesUsedByHeader = 1
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+		ssh.BytesUsedByHeader = 2
+	case n <= maxSequences:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+		ssh.BytesUsedByHeader = 3
+	default:
+		return dst, ErrTooManySequences
+	}
+	if n == 0 {
+		return dst, nil
+	}
+	ssh.BytesUsedByHeader++
+	return append(dst, byte(ssh.LiteralsLengthMode)<<6|byte(ssh.OffsetsMode)<<4|byte(ssh.MatchLengthsMode)<<2), nil
+}
+
+//codeEncoder picks the table for one kind of codes and remembers the table the decoder has for the repeat mode
+type codeEncoder struct {
+	predefined     *fse.FSEEncodingTable
+	maxAccuracyLog int
+
+	previous *fse.FSEEncodingTable //the table of the last committed section, nil if there is none
+	next     *fse.FSEEncodingTable //built for the current section. It is one of buffers and never previous
+	buffers  [2]*fse.FSEEncodingTable
+
+	Mode        SymbolCompressionMode
+	table       *fse.FSEEncodingTable //the table of the current section
+	counts      []int
+	description []byte
+}
+
+func newCodeEncoder(predefined *fse.FSEEncodingTable, maxAccuracyLog int) codeEncoder {
+	ce := codeEncoder{
+		predefined:     predefined,
+		maxAccuracyLog: maxAccuracyLog,
+		buffers:        [2]*fse.FSEEncodingTable{fse.NewFSEEncodingTable(maxAccuracyLog), fse.NewFSEEncodingTable(maxAccuracyLog)},
+		counts:         make([]int, 0, fse.MaxSymbols),
+	}
+	ce.next = ce.buffers[0]
+	return ce
+}
+
+//choose picks the mode with the smallest estimated size for the codes. There has to be at least one code
+func (ce *codeEncoder) choose(codes []byte) {
+	ce.counts = fse.Histogram(ce.counts, codes)
+	maxSymbol := len(ce.counts) - 1
+
+	//a RLE table costs one byte, the predefined table costs nothing
+	ce.Mode, ce.table = SymbolCompressionModePredefined, ce.predefined
+	best := ce.predefined.Cost(ce.counts)
+	if ce.counts[maxSymbol] == len(codes) {
+		if best < 0 || best > 8 {
+			ce.next.InitRLE(maxSymbol)
+			ce.Mode, ce.table, best = SymbolCompressionModeRLE, ce.next, 8
+		}
+	} else {
+		err := ce.next.Normalize(ce.counts, fse.OptimalAccuracyLog(ce.maxAccuracyLog, len(codes), maxSymbol))
+		if err == nil {
+			ce.description = ce.next.AppendTabledescription(ce.description[:0])
+			if cost := len(ce.description)*8 + ce.next.Cost(ce.counts); best < 0 || cost < best {
+				ce.Mode, ce.table, best = SymbolCompressionModeCompressed, ce.next, cost
+			}
+		}
+	}
+	if ce.previous != nil {
+		if cost := ce.previous.Cost(ce.counts); cost >= 0 && cost <= best {
+			ce.Mode, ce.table = SymbolCompressionModeRepeat, ce.previous
+		}
+	}
+}
+
+//appendTable appends what the decoder needs to build the table of the current mode
+func (ce *codeEncoder) appendTable(dst []byte) []byte {
+	switch ce.Mode {
+	case SymbolCompressionModeRLE:
+		return append(dst, byte(len(ce.table.Probabilities)-1))
+	case SymbolCompressionModeCompressed:
+		return append(dst, ce.description...)
+	}
+	return dst
+}
+
+//commit makes the table of the current section the table of the repeat mode
+func (ce *codeEncoder) commit() {
+	switch ce.Mode {
+	case SymbolCompressionModePredefined:
+		ce.previous = ce.predefined
+	case SymbolCompressionModeRLE, SymbolCompressionModeCompressed:
+		ce.previous = ce.next
+		if ce.next == ce.buffers[0] {
+			ce.next = ce.buffers[1]
+		} else {
+			ce.next = ce.buffers[0]
+		}
+	}
+}
+
+//SequencesEncoder writes the sequences sections of the blocks of a frame. For the literal lengths, match lengths and offsets it
+//picks the predefined table, a RLE table, a new table or the table of the previous section, whichever is estimated to be the smallest
+type SequencesEncoder struct {
+	literalLengths codeEncoder
+	matchLengths   codeEncoder
+	offsets        codeEncoder
+	committed      bool //the tables of the last section can be used in the repeat mode
+
+	//the header of the last section
+	Header SequencesSectionHeader
+
+	llCodes []byte
+	mlCodes []byte
+	ofCodes []byte
+}
+
+//NewSequencesEncoder creates a SequencesEncoder for a new frame
+func NewSequencesEncoder() *SequencesEncoder {
+	return &SequencesEncoder{
+		literalLengths: newCodeEncoder(fse.PredefinedLiteralLengthsEncodingTable, MaxLiteralLengthsAccuracyLog),
+		matchLengths:   newCodeEncoder(fse.PredefinedMatchLengthsEncodingTable, MaxMatchLengthsAccuracyLog),
+		offsets:        newCodeEncoder(fse.PredefinedOffsetEncodingTable, MaxOffsetsAccuracyLog),
+	}
+}
+
+//Reset prepares the SequencesEncoder for a new frame. The tables of the previous frame are forgotten
+func (se *SequencesEncoder) Reset() {
+	for _, ce := range []*codeEncoder{&se.literalLengths, &se.matchLengths, &se.offsets} {
+		ce.previous = nil
+	}
+	se.committed = true
+}
+
+//Commit has to be called after the block with the last section was written as compressed block. Only then the decoder has the
+//tables of the section and the following sections can repeat them
+func (se *SequencesEncoder) Commit() {
+	if se.committed || se.Header.NumberOfSequences == 0 {
+		return
+	}
+	se.literalLengths.commit()
+	se.matchLengths.commit()
+	se.offsets.commit()
+	se.committed = true
+}
+
+//AppendSequencesSection appends the sequences section with the sequences to dst. The offsets of the sequences are offset values
+//as OffsetHistory.Encode returns them
+func (se *SequencesEncoder) AppendSequencesSection(dst []byte, sequences []Sequence) ([]byte, error) {
+	se.committed = false
+	se.Header = SequencesSectionHeader{NumberOfSequences: len(sequences)}
+	if len(sequences) == 0 {
+		return se.Header.Encode(dst)
+	}
+
+	se.llCodes, se.mlCodes, se.ofCodes = se.llCodes[:0], se.mlCodes[:0], se.ofCodes[:0]
+	for _, seq := range sequences {
+		se.llCodes = append(se.llCodes, LiteralLengthCode(seq.LiteralLength))
+		se.mlCodes = append(se.mlCodes, MatchLengthCode(seq.MatchLength))
+		se.ofCodes = append(se.ofCodes, OffsetCode(seq.Offset))
+	}
+	se.literalLengths.choose(se.llCodes)
+	se.matchLengths.choose(se.mlCodes)
+	se.offsets.choose(se.ofCodes)
+	se.Header.LiteralsLengthMode = se.literalLengths.Mode
+	se.Header.OffsetsMode = se.offsets.Mode
+	se.Header.MatchLengthsMode = se.matchLengths.Mode
+
+	dst, err := se.Header.Encode(dst)
+	if err != nil {
+		return dst, err
+	}
+	dst = se.literalLengths.appendTable(dst)
+	dst = se.offsets.appendTable(dst)
+	dst = se.matchLengths.appendTable(dst)
+	return se.appendBitstream(dst, sequences), nil
+}
+
+//appendBitstream writes the sequences backwards, so the decoder reads the first sequence first. Each sequence is made of the
+//transitions of the three states to its codes and the extra bits of its values
+func (se *SequencesEncoder) appendBitstream(dst []byte, sequences []Sequence) []byte {
+	bw := bitstream.NewReverseWriter(dst)
+	ll := fse.FSEEncoderState{Table: se.literalLengths.table}
+	ml := fse.FSEEncoderState{Table: se.matchLengths.table}
+	of := fse.FSEEncoderState{Table: se.offsets.table}
+
+	last := len(sequences) - 1
+	for i := last; i >= 0; i-- {
+		llCode, mlCode, ofCode := se.llCodes[i], se.mlCodes[i], se.ofCodes[i]
+		if i == last {
+			ll.Init(int(llCode))
+			ml.Init(int(mlCode))
+			of.Init(int(ofCode))
+		} else {
+			of.Encode(bw, int(ofCode))
+			ml.Encode(bw, int(mlCode))
+			ll.Encode(bw, int(llCode))
+		}
+		seq := sequences[i]
+		bw.Write(uint64(seq.LiteralLength-fse.LiteralLengthBaseValueTranslation[llCode]), uint(fse.LiteralLengthExtraBits[llCode]))
+		bw.Write(uint64(seq.MatchLength-fse.MatchLengthBaseValueTranslation[mlCode]), uint(fse.MatchLengthsExtraBits[mlCode]))
+		bw.Write(uint64(seq.Offset)-1<<ofCode, uint(ofCode))
+	}
+
+	//the decoder reads the literal lengths state first
+	ml.Flush(bw)
+	of.Flush(bw)
+	ll.Flush(bw)
+	return bw.Finish()
+}
@@ -0,0 +1,146 @@
+package xxhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+//Digest calculates the 64 bit xxHash (XXH64) with seed 0, which is what the content checksum of a zstd frame is made of.
+//It implements hash.Hash64
+type Digest struct {
+	v     [4]uint64
+	total uint64
+	buf   [32]byte
+	n     int //bytes in buf
+}
+
+const (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+//New creates a new Digest
+func New() *Digest {
+	d := &Digest{}
+	d.Reset()
+	return d
+}
+
+//Reset starts a new hash
+func (d *Digest) Reset() {
+	//the constants overflow on purpose, which go only allows for variables
+	p1, p2 := prime1, prime2
+	d.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	d.total = 0
+	d.n = 0
+}
+
+//Size is the number of bytes Sum appends
+func (d *Digest) Size() int {
+	return 8
+}
+
+//BlockSize is the number of bytes that are consumed at once
+func (d *Digest) BlockSize() int {
+	return 32
+}
+
+func round(acc, input uint64) uint64 {
+	acc += input * prime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime1
+}
+
+func mergeRound(acc, val uint64) uint64 {
+	acc ^= round(0, val)
+	return acc*prime1 + prime4
+}
+
+//stripes consumes all full 32 byte stripes of data and returns the rest
+func (d *Digest) stripes(data []byte) []byte {
+	for len(data) >= 32 {
+		d.v[0] = round(d.v[0], binary.LittleEndian.Uint64(data))
+		d.v[1] = round(d.v[1], binary.LittleEndian.Uint64(data[8:]))
+		d.v[2] = round(d.v[2], binary.LittleEndian.Uint64(data[16:]))
+		d.v[3] = round(d.v[3], binary.LittleEndian.Uint64(data[24:]))
+		data = data[32:]
+	}
+	return data
+}
+
+//Write adds data to the hash. It never returns an error
+func (d *Digest) Write(data []byte) (int, error) {
+	n := len(data)
+	d.total += uint64(n)
+
+	if d.n > 0 {
+		copied := copy(d.buf[d.n:], data)
+		d.n += copied
+		data = data[copied:]
+		if d.n < 32 {
+			return n, nil
+		}
+		d.stripes(d.buf[:])
+		d.n = 0
+	}
+
+	data = d.stripes(data)
+	d.n = copy(d.buf[:], data)
+	return n, nil
+}
+
+//Sum64 returns the hash of the data written so far
+func (d *Digest) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v[0], 1) + bits.RotateLeft64(d.v[1], 7) + bits.RotateLeft64(d.v[2], 12) + bits.RotateLeft64(d.v[3], 18)
+		h = mergeRound(h, d.v[0])
+		h = mergeRound(h, d.v[1])
+		h = mergeRound(h, d.v[2])
+		h = mergeRound(h, d.v[3])
+	} else {
+		h = prime5
+	}
+	h += d.total
+
+	rest := d.buf[:d.n]
+	for len(rest) >= 8 {
+		h ^= round(0, binary.LittleEndian.Uint64(rest))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+		rest = rest[8:]
+	}
+	if len(rest) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(rest)) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		rest = rest[4:]
+	}
+	for _, b := range rest {
+		h ^= uint64(b) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
+
+//Sum appends the hash in big endian order to b, like the hashes of the standard library do
+func (d *Digest) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], d.Sum64())
+	return append(b, sum[:]...)
+}
+
+//Sum64 returns the hash of data
+func Sum64(data []byte) uint64 {
+	d := Digest{}
+	d.Reset()
+	d.Write(data)
+	return d.Sum64()
+}
@@ -0,0 +1,37 @@
+package xxhash
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSum64(t *testing.T) {
+	known := map[string]uint64{
+		"":    0xEF46DB3751D8E999,
+		"a":   0xD24EC4F1A98C6E5B,
+		"abc": 0x44BC2CF5AD770999,
+	}
+	for input, should := range known {
+		if sum := Sum64([]byte(input)); sum != should {
+			t.Errorf("Wrong hash for %q: %x, should be: %x", input, sum, should)
+		}
+	}
+
+	//writing in pieces has to give the same hash as writing all at once, no matter where the stripes are cut
+	data := make([]byte, 1000)
+	rand.Read(data)
+	d := New()
+	for _, n := range []int{1, 7, 13, 32, 33, 64, 100} {
+		d.Reset()
+		for i := 0; i < len(data); i += n {
+			end := i + n
+			if end > len(data) {
+				end = len(data)
+			}
+			d.Write(data[i:end])
+		}
+		if d.Sum64() != Sum64(data) {
+			t.Errorf("Writing in pieces of %d bytes gave a different hash", n)
+		}
+	}
+}